@@ -0,0 +1,143 @@
+// Package apperrors describes the errors a client can act on. Services
+// return them with a status kind, a machine-readable code and a detail that
+// is safe to show. Any other error reaching a handler is internal: it is
+// logged, and the client only learns that something went wrong.
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+type Kind string
+
+const (
+	KindNotFound     Kind = "not_found"
+	KindConflict     Kind = "conflict"
+	KindUnauthorized Kind = "unauthorized"
+	KindForbidden    Kind = "forbidden"
+	KindValidation   Kind = "validation"
+	KindRateLimited  Kind = "rate_limited"
+	KindInternal     Kind = "internal"
+)
+
+var statuses = map[Kind]int{
+	KindNotFound:     http.StatusNotFound,
+	KindConflict:     http.StatusConflict,
+	KindUnauthorized: http.StatusUnauthorized,
+	KindForbidden:    http.StatusForbidden,
+	KindValidation:   http.StatusBadRequest,
+	KindRateLimited:  http.StatusTooManyRequests,
+	KindInternal:     http.StatusInternalServerError,
+}
+
+// Code of every internal error
+const CodeInternal = "internal_error"
+
+// FieldError is one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"` // Path in the request, such as owned_accounts[0].action
+	Code    string `json:"code"`  // The rule broken, such as required or phone
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Kind Kind
+	// Stable identifier clients can branch on, such as user_not_found
+	Code string
+	// Shown to the client as is
+	Detail string
+	// More members of the problem body, such as the invalid fields
+	Extensions map[string]any
+	// What caused the error, only logged
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors of the same kind and code, so errors.Is still finds a
+// package level error after With or Wrap copied it
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// Status is the HTTP status the error is answered with
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Kind]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// With returns a copy of the error that adds a member to the problem body
+func (e *Error) With(key string, value any) *Error {
+	copied := *e
+	copied.Extensions = make(map[string]any, len(e.Extensions)+1)
+	for k, v := range e.Extensions {
+		copied.Extensions[k] = v
+	}
+	copied.Extensions[key] = value
+	return &copied
+}
+
+// Wrap returns a copy of the error caused by err, which is logged but never
+// shown to the client
+func (e *Error) Wrap(err error) *Error {
+	copied := *e
+	copied.Err = err
+	return &copied
+}
+
+func NotFound(code, detail string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Detail: detail}
+}
+
+func Conflict(code, detail string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Detail: detail}
+}
+
+func Unauthorized(code, detail string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Detail: detail}
+}
+
+func Forbidden(code, detail string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Detail: detail}
+}
+
+func Validation(code, detail string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Detail: detail}
+}
+
+func RateLimited(code, detail string) *Error {
+	return &Error{Kind: KindRateLimited, Code: code, Detail: detail}
+}
+
+// InvalidFields lists every invalid field of a request under errors
+func InvalidFields(fields []FieldError) *Error {
+	return Validation("invalid_input", "Invalid input").With("errors", fields)
+}
+
+// Internal hides err from the client behind a generic detail
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: CodeInternal, Detail: "Internal server error", Err: err}
+}
+
+// From finds the *Error in err's chain, treating an error without one as
+// internal
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err)
+}
@@ -0,0 +1,149 @@
+// Command audit checks and archives the audit log.
+//
+//	audit verify [-chain id]
+//	audit export [-format jsonl|csv] [-chain id] [-since time] [-until time] [-o file]
+//
+// A chain is identified by the account ID, by the user ID for a user's own
+// security events, or is "system" for events about nobody known. verify needs the AUDIT_CHAIN_KEY the server hashed the
+// events with, and exits with status 1 when a chain was tampered with.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go-backend/config"
+	"go-backend/models"
+	"go-backend/services"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(verify(os.Args[2:]))
+	case "export":
+		export(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: audit verify [-chain id]")
+	fmt.Fprintln(os.Stderr, "       audit export [-format jsonl|csv] [-chain id] [-since time] [-until time] [-o file]")
+	os.Exit(2)
+}
+
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	chainID := flags.String("chain", "", "only verify the chain of this account or user, or system")
+	flags.Parse(args)
+
+	if len(config.AuditChainKey()) == 0 {
+		log.Fatal("AUDIT_CHAIN_KEY must be set to the key the server hashes the audit log with")
+	}
+
+	config.Init()
+	auditService := services.NewAuditService()
+
+	var reports []models.AuditChainReport
+	if *chainID != "" {
+		report, err := auditService.VerifyChain(*chainID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports = append(reports, *report)
+	} else {
+		var err error
+		if reports, err = auditService.VerifyChains(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	status := 0
+	for _, report := range reports {
+		if report.OK() {
+			fmt.Printf("%s: ok, %d events (%d to %d)\n", report.ChainID, report.Events, report.FirstSequence, report.LastSequence)
+			continue
+		}
+
+		status = 1
+		fmt.Printf("%s: %d problems in %d events\n", report.ChainID, len(report.Problems), report.Events)
+		for _, problem := range report.Problems {
+			if problem.EventID != "" {
+				fmt.Printf("  %d (%s): %s\n", problem.Sequence, problem.EventID, problem.Problem)
+			} else {
+				fmt.Printf("  %d: %s\n", problem.Sequence, problem.Problem)
+			}
+		}
+	}
+	return status
+}
+
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", string(models.AuditExportJSONLines), "jsonl or csv")
+	chainID := flags.String("chain", "", "only export the chain of this account or user, or system")
+	since := flags.String("since", "", "only events at or after this RFC 3339 time")
+	until := flags.String("until", "", "only events before this RFC 3339 time")
+	output := flags.String("o", "", "file to write, standard output when empty")
+	flags.Parse(args)
+
+	exportFormat := models.AuditExportFormat(*format)
+	if exportFormat != models.AuditExportJSONLines && exportFormat != models.AuditExportCSV {
+		log.Fatalf("Unsupported format %q", *format)
+	}
+
+	query := models.AuditExportQuery{ChainID: *chainID}
+	for _, bound := range []struct {
+		value string
+		dest  *time.Time
+	}{{*since, &query.Since}, {*until, &query.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			log.Fatalf("Invalid time %q: %v", bound.value, err)
+		}
+		*bound.dest = t
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := bufio.NewWriter(out)
+
+	config.Init()
+	auditService := services.NewAuditService()
+
+	exported, err := auditService.Export(writer, exportFormat, query)
+	if err != nil {
+		log.Fatalf("Export failed after %d events: %v", exported, err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Exported %d audit events", exported)
+}
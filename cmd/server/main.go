@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
 	"go-backend/config"
+	"go-backend/events"
 	"go-backend/handlers"
+	"go-backend/logging"
+	"go-backend/metrics"
 	"go-backend/middleware"
+	"go-backend/services"
+	"go-backend/tracing"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -17,42 +30,277 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	logger := logging.New(os.Stdout, config.LogLevel(), config.LogFormat())
+	// Code that still uses the log package goes through the same redaction
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Init(context.Background(), config.TraceExporter())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	if len(config.AuditChainKey()) == 0 {
+		log.Fatal("AUDIT_CHAIN_KEY must be set to key the audit log's hash chains")
+	}
+
+	if err := services.InitPasswordHasher(); err != nil {
+		log.Fatalf("Invalid password hashing settings: %v", err)
+	}
+
 	config.Init()
+	metrics.RegisterDBStats(config.DB.DB(), "mysql")
+	tracing.RegisterCallbacks(config.DB)
 
-	r := gin.Default()
+	services.RegisterSubscribers(events.Default)
 
-	rateLimiter := middleware.NewRateLimiter(5, time.Minute)
+	stopWorkers := make(chan struct{})
+	var workers sync.WaitGroup
+	services.StartInvitationSweeper(config.InvitationSweepInterval, stopWorkers, &workers)
+	services.StartUserDeletionSweeper(config.UserDeletionSweepInterval, stopWorkers, &workers)
+	services.StartAuditRetentionSweeper(config.AuditRetentionSweepInterval, stopWorkers, &workers)
+	services.StartWebhookDispatcher(config.WebhookDispatchInterval, stopWorkers, &workers)
+
+	if err := handlers.RegisterValidators(); err != nil {
+		log.Fatalf("Failed to register validators: %v", err)
+	}
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(middleware.RequestID(), middleware.AccessLog(logger), middleware.Metrics(), middleware.Tracing(), middleware.Errors(logger), middleware.Recovery(logger))
+
+	r.NoRoute(handlers.NoRoute)
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/healthz", handlers.Healthz)
+	r.GET("/readyz", handlers.Readyz)
+
+	rateLimiter := middleware.NewRateLimiter("auth", 5, time.Minute)
+	tokenRateLimiter := middleware.NewRateLimiter("oauth_token", 30, time.Minute)
+	passwordChangeRateLimiter := middleware.NewRateLimiter("password_change", 5, 15*time.Minute)
 
 	auth := r.Group("/auth")
 	{
 		auth.POST("/register", rateLimiter.RateLimit(), handlers.Register)
 		auth.POST("/login", rateLimiter.RateLimit(), handlers.Login)
+		auth.POST("/login/magic-link", rateLimiter.RateLimit(), handlers.RequestMagicLink)
+		auth.POST("/login/magic-link/verify", rateLimiter.RateLimit(), handlers.MagicLinkLogin)
 		auth.POST("/verify-email", handlers.VerifyEmail)
 		auth.POST("/verify-phone", handlers.VerifyPhone)
 		auth.POST("/forgot-password", handlers.ForgotPassword)
 		auth.POST("/reset-password", handlers.ResetPassword)
 
-		authorized := auth.Use(middleware.AuthRequired())
+		auth.POST("/sso/exchange", rateLimiter.RateLimit(), handlers.SSOExchange)
+
+		auth.POST("/oauth/register", rateLimiter.RateLimit(), handlers.SocialRegister)
+		auth.POST("/oauth/:provider/authorize", handlers.StartSocialLogin)
+		auth.POST("/oauth/:provider/callback", rateLimiter.RateLimit(), handlers.SocialLoginCallback)
+
+		authorized := auth.Use(middleware.AuthRequired(), middleware.UserRequired())
 		{
 			authorized.POST("/mfa/enable", handlers.EnableMFA)
 			authorized.POST("/mfa/verify", handlers.VerifyMFA)
+			authorized.POST("/password/change", passwordChangeRateLimiter.RateLimitBy(middleware.UserKey), handlers.ChangePassword)
 		}
 	}
 
+	users := r.Group("/users")
+	users.Use(middleware.AuthRequired(), middleware.UserRequired())
+	{
+		users.GET("/me", handlers.GetMe)
+		users.PATCH("/me", handlers.UpdateMe)
+		users.DELETE("/me", handlers.DeleteMe)
+		users.POST("/me/deletion/cancel", handlers.CancelDeleteMe)
+		users.GET("/me/export", handlers.ExportMe)
+		users.GET("/me/security-events", handlers.ListSecurityEvents)
+
+		users.GET("/me/identities", handlers.ListIdentities)
+		users.POST("/me/identities/:provider", handlers.StartLinkIdentity)
+		users.POST("/me/identities/:provider/callback", handlers.LinkIdentityCallback)
+		users.DELETE("/me/identities/:provider", handlers.UnlinkIdentity)
+		users.GET("/:id", handlers.GetUser)
+
+		users.POST("/me/email", handlers.ChangeEmail)
+		users.POST("/me/email/confirm", rateLimiter.RateLimit(), handlers.ConfirmEmailChange)
+		users.POST("/me/phone", handlers.ChangePhone)
+		users.POST("/me/phone/confirm", rateLimiter.RateLimit(), handlers.ConfirmPhoneChange)
+	}
+
+	r.POST("/contact-changes/revert", handlers.RevertContactChange)
+
+	r.POST("/oauth/token", tokenRateLimiter.RateLimitBy(middleware.OAuthClientKey), handlers.OAuthToken)
+
+	// Called by browsers on their way to and from an account's identity provider
+	saml := r.Group("/saml/:accountId")
+	{
+		saml.GET("/metadata", handlers.SAMLMetadata)
+		saml.GET("/login", handlers.SAMLLogin)
+		saml.POST("/acs", handlers.SAMLAssertionConsumer)
+	}
+
+	// Identity providers provision an account's users with an API key that has
+	// the scim:provision scope
+	scim := r.Group("/scim/v2", middleware.SCIMRequired())
+	{
+		scim.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig)
+
+		scim.GET("/Users", handlers.SCIMListUsers)
+		scim.POST("/Users", handlers.SCIMCreateUser)
+		scim.GET("/Users/:id", handlers.SCIMGetUser)
+		scim.PUT("/Users/:id", handlers.SCIMReplaceUser)
+		scim.PATCH("/Users/:id", handlers.SCIMPatchUser)
+		scim.DELETE("/Users/:id", handlers.SCIMDeleteUser)
+
+		scim.GET("/Groups", handlers.SCIMListGroups)
+		scim.GET("/Groups/:id", handlers.SCIMGetGroup)
+		scim.PUT("/Groups/:id", handlers.SCIMReplaceGroup)
+		scim.PATCH("/Groups/:id", handlers.SCIMPatchGroup)
+	}
+
 	accounts := r.Group("/accounts")
-	accounts.Use(middleware.AuthRequired())
+	userAccounts := accounts.Group("", middleware.AuthRequired(), middleware.UserRequired())
 	{
-		accounts.POST("", handlers.CreateAccount)
-		accounts.GET("", handlers.ListAccounts)
-		accounts.POST("/:accountId/invitations", handlers.InviteMember)
+		userAccounts.POST("", handlers.CreateAccount)
+		userAccounts.GET("", handlers.ListAccounts)
+		userAccounts.GET("/discover", handlers.DiscoverAccounts)
+		userAccounts.POST("/:accountId/join", handlers.JoinAccount)
+	}
+
+	// Account resources can also be managed by API keys and service accounts
+	// of that account
+	account := accounts.Group("/:accountId", middleware.AuthRequiredOrAPIKey())
+	{
+		account.POST("/invitations", handlers.InviteMember)
+		account.GET("/invitations", handlers.ListAccountInvitations)
+		account.DELETE("/invitations/:invitationId", handlers.CancelInvitation)
+		account.POST("/invitations/:invitationId/resend", handlers.ResendInvitation)
+
+		account.POST("/join-links", handlers.CreateJoinLink)
+		account.GET("/join-links", handlers.ListJoinLinks)
+		account.DELETE("/join-links/:linkId", handlers.RevokeJoinLink)
+
+		account.POST("/domains", handlers.AddAccountDomain)
+		account.GET("/domains", handlers.ListAccountDomains)
+		account.POST("/domains/:domainId/verify", handlers.VerifyAccountDomain)
+		account.DELETE("/domains/:domainId", handlers.RemoveAccountDomain)
+
+		account.GET("/access-requests", handlers.ListAccessRequests)
+		account.POST("/access-requests/:requestId/approve", handlers.ApproveAccessRequest)
+		account.POST("/access-requests/:requestId/deny", handlers.DenyAccessRequest)
+
+		account.POST("/api-keys", handlers.CreateAPIKey)
+		account.GET("/api-keys", handlers.ListAPIKeys)
+		account.DELETE("/api-keys/:keyId", handlers.RevokeAPIKey)
+
+		account.POST("/service-accounts", handlers.CreateServiceAccount)
+		account.GET("/service-accounts", handlers.ListServiceAccounts)
+		account.PUT("/service-accounts/:serviceAccountId/role", handlers.UpdateServiceAccountRole)
+		account.POST("/service-accounts/:serviceAccountId/rotate-secret", handlers.RotateServiceAccountSecret)
+		account.DELETE("/service-accounts/:serviceAccountId", handlers.DisableServiceAccount)
+
+		account.PUT("/sso/saml", handlers.ConfigureSAML)
+		account.GET("/sso/saml", handlers.GetSAMLConnection)
+		account.DELETE("/sso/saml", handlers.DeleteSAMLConnection)
+
+		account.GET("/audit-log", handlers.ListAuditLog)
+
+		account.POST("/webhooks", handlers.CreateWebhookEndpoint)
+		account.GET("/webhooks", handlers.ListWebhookEndpoints)
+		account.GET("/webhooks/:webhookId", handlers.GetWebhookEndpoint)
+		account.PATCH("/webhooks/:webhookId", handlers.UpdateWebhookEndpoint)
+		account.DELETE("/webhooks/:webhookId", handlers.DeleteWebhookEndpoint)
+		account.POST("/webhooks/:webhookId/rotate-secret", handlers.RotateWebhookSecret)
+		account.GET("/webhooks/:webhookId/deliveries", handlers.ListWebhookDeliveries)
+		account.POST("/webhooks/:webhookId/deliveries/:deliveryId/replay", handlers.ReplayWebhookDelivery)
+	}
+
+	accessRequests := r.Group("/access-requests")
+	accessRequests.Use(middleware.AuthRequired(), middleware.UserRequired())
+	{
+		accessRequests.POST("", handlers.RequestAccess)
+		accessRequests.GET("", handlers.ListMyAccessRequests)
+		accessRequests.DELETE("/:requestId", handlers.WithdrawAccessRequest)
+	}
+
+	join := r.Group("/join")
+	join.Use(middleware.AuthRequired(), middleware.UserRequired())
+	{
+		join.POST("/:code", handlers.JoinWithLink)
 	}
 
 	invitations := r.Group("/invitations")
-	invitations.Use(middleware.AuthRequired())
+	invitations.Use(middleware.AuthRequired(), middleware.UserRequired())
 	{
+		invitations.GET("", handlers.ListInvitations)
+		invitations.POST("/accept", handlers.AcceptInvitationByToken)
 		invitations.POST("/:invitationId/accept", handlers.AcceptInvitation)
 		invitations.POST("/:invitationId/decline", handlers.DeclineInvitation)
 	}
 
-	log.Fatal(r.Run(":8080"))
+	readTimeout, writeTimeout, idleTimeout := config.ServerTimeouts()
+	server := &http.Server{
+		Addr:              config.ListenAddr(),
+		Handler:           r,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		certFile, keyFile := config.TLSFiles()
+		if certFile != "" || keyFile != "" {
+			slog.Info("Serving HTTPS", "addr", server.Addr)
+			serverErr <- server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			slog.Info("Serving HTTP", "addr", server.Addr)
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	select {
+	case err := <-serverErr:
+		log.Fatalf("Server failed: %v", err)
+	case <-stop.Done():
+	}
+
+	// Stop taking new requests and let the ones in flight, the background
+	// workers and event subscribers finish before the process exits
+	slog.Info("Shutting down")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancelShutdown()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Requests were still in flight at shutdown", "error", err)
+	}
+	close(stopWorkers)
+	waitUntilDone(ctx, "background workers", workers.Wait)
+	waitUntilDone(ctx, "event subscribers", events.Default.Wait)
+
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+	if err := config.DB.Close(); err != nil {
+		slog.Error("Failed to close the database", "error", err)
+	}
+	slog.Info("Shut down")
+}
+
+// waitUntilDone calls wait, giving up when ctx ends first
+func waitUntilDone(ctx context.Context, what string, wait func()) {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("Gave up waiting at shutdown", "waiting_for", what)
+	}
 }
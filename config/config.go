@@ -3,6 +3,10 @@ package config
 import (
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"go-backend/models"
 
@@ -12,6 +16,9 @@ import (
 
 var DB *gorm.DB
 
+// Set once every table has been migrated, readiness waits for it
+var migrated bool
+
 func Init() {
 	dbUser := "root"
 	dbPass := "rootpassword"
@@ -26,6 +33,132 @@ func Init() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	DB.AutoMigrate(&models.User{}, &models.Account{}, &models.Membership{}, &models.Invitation{})
-	log.Println("Database connection successful and users table created!")
+	err = DB.AutoMigrate(&models.User{}, &models.Account{}, &models.Membership{}, &models.Invitation{},
+		&models.JoinLink{}, &models.AccountDomain{}, &models.AccessRequest{}, &models.APIKey{}, &models.ServiceAccount{},
+		&models.ContactChange{}, &models.PasswordHistory{}, &models.UserDeletion{},
+		&models.MagicLink{}, &models.Identity{}, &models.OAuthState{},
+		&models.SAMLConnection{}, &models.SAMLRequest{}, &models.SSOTicket{},
+		&models.SCIMUser{}, &models.AuditEvent{}, &models.AuditChain{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{}).Error
+	if err != nil {
+		slog.Error("Failed to migrate the database", "error", err)
+		return
+	}
+	migrated = true
+	slog.Info("Connected to the database and migrated its tables")
+}
+
+// Migrated reports whether Init brought every table up to date
+func Migrated() bool {
+	return migrated
+}
+
+// ListenAddr is the address the server listens on, :8080 when LISTEN_ADDR
+// is not set
+func ListenAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	return DefaultListenAddr
+}
+
+// TLSFiles returns the certificate and key files to serve HTTPS with, both
+// empty to serve plain HTTP
+func TLSFiles() (certFile, keyFile string) {
+	return os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+}
+
+// TrustedProxies lists the addresses and CIDR ranges, from TRUSTED_PROXIES,
+// whose X-Forwarded-For headers are believed. None are trusted by default, as
+// the client IP otherwise comes from a header anyone can set, which would get
+// a leaked API key past its IP allowlist
+func TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// ServerTimeouts returns how long the server waits to read a request, to
+// write a response and for the next request on an idle connection
+func ServerTimeouts() (read, write, idle time.Duration) {
+	return getDurationEnv("SERVER_READ_TIMEOUT", DefaultServerReadTimeout),
+		getDurationEnv("SERVER_WRITE_TIMEOUT", DefaultServerWriteTimeout),
+		getDurationEnv("SERVER_IDLE_TIMEOUT", DefaultServerIdleTimeout)
+}
+
+// ShutdownTimeout is how long in-flight requests and background work get to
+// finish after the server is asked to stop
+func ShutdownTimeout() time.Duration {
+	return getDurationEnv("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout)
+}
+
+// InvitationTTL returns how long new invitations stay valid
+func InvitationTTL() time.Duration {
+	return getDurationEnv("INVITATION_TTL", DefaultInvitationTTL)
+}
+
+// UserDeletionGracePeriod returns how long a deleted user is kept before
+// being purged
+func UserDeletionGracePeriod() time.Duration {
+	return getDurationEnv("USER_DELETION_GRACE_PERIOD", DefaultUserDeletionGracePeriod)
+}
+
+// AuditChainKey is the secret audit log hashes are keyed with, from
+// AUDIT_CHAIN_KEY. It must not be stored in the database the audit log is in.
+func AuditChainKey() []byte {
+	return []byte(os.Getenv("AUDIT_CHAIN_KEY"))
+}
+
+// AuditLogRetention returns how long audit events are kept
+func AuditLogRetention() time.Duration {
+	return getDurationEnv("AUDIT_LOG_RETENTION", DefaultAuditLogRetention)
+}
+
+// WebhookAllowPrivateNetworks lets webhook endpoints resolve to loopback and
+// private addresses, which is only wanted in development
+func WebhookAllowPrivateNetworks() bool {
+	return getBoolEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false)
+}
+
+// APIBaseURL is where this server can be reached from browsers, used for
+// endpoints identity providers call directly
+func APIBaseURL() string {
+	if url := os.Getenv("API_BASE_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return DefaultAPIBaseURL
+}
+
+// LogLevel is the lowest level that is logged: debug, info, warn or error
+func LogLevel() string {
+	return os.Getenv("LOG_LEVEL")
+}
+
+// LogFormat is json, the default, or text for reading logs in a terminal
+func LogFormat() string {
+	return os.Getenv("LOG_FORMAT")
+}
+
+// TraceExporter is where spans are sent: none, the default, otlp for a
+// collector set up with the OTEL_EXPORTER_OTLP_* variables, or stdout
+func TraceExporter() string {
+	return os.Getenv("OTEL_TRACES_EXPORTER")
+}
+
+func getDurationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid setting, using the default", "setting", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
 }
@@ -1,6 +1,144 @@
 package config
 
+import "time"
+
 const (
 	// In production, this should be loaded from environment variables
 	JWTSecretKey = "your-secret-key"
+
+	// How long an emailed invitation stays valid when INVITATION_TTL is not set
+	DefaultInvitationTTL = 7 * 24 * time.Hour
+
+	// How often pending invitations are checked for expiry
+	InvitationSweepInterval = 10 * time.Minute
+
+	// Used to build links in outgoing emails when APP_BASE_URL is not set
+	DefaultAppBaseURL = "http://localhost:3000"
+
+	// Used to build identity provider facing URLs when API_BASE_URL is not set
+	DefaultAPIBaseURL = "http://localhost:8080"
+
+	// DNS label under which account domain verification TXT records are published
+	DomainVerificationLabel = "_go-backend-verification"
+
+	// Visible start of every API key, followed by a random identifier
+	APIKeyPrefix = "gbk_"
+
+	// How often a busy API key's last use is written at most. Use from
+	// another IP is written right away.
+	APIKeyLastUsedInterval = time.Minute
+
+	// Service account client IDs start with this
+	ServiceAccountClientIDPrefix = "sa_"
+
+	// Lifetime of access tokens issued through the client credentials grant
+	ServiceAccountTokenTTL = time.Hour
+
+	// How long the code for an email or phone change can be confirmed
+	ContactChangeCodeTTL = 15 * time.Minute
+
+	// Wrong codes after which a pending email or phone change is dropped
+	ContactChangeMaxAttempts = 5
+
+	// How long the old email address can undo a confirmed change
+	ContactChangeRevertTTL = 7 * 24 * time.Hour
+
+	// How long an emailed login link can be used
+	MagicLinkTTL = 15 * time.Minute
+
+	// Cookie binding a login link to the browser that requested it
+	MagicLinkCookieName = "magic_link_browser"
+
+	// How long a social login may take between leaving for the provider and coming back
+	OAuthStateTTL = 10 * time.Minute
+
+	// Cookie binding a social login to the browser that started it
+	OAuthStateCookieName = "oauth_state"
+
+	// How long a new social login user has to finish registering
+	SocialRegistrationTTL = 30 * time.Minute
+
+	// How long a SAML login may take at the identity provider
+	SAMLRequestTTL = 10 * time.Minute
+
+	// How long the client has to exchange a completed SSO login for a token
+	SSOTicketTTL = time.Minute
+
+	// SCIM list responses page size when the client does not ask for one, and the most it may ask for
+	SCIMDefaultPageSize = 100
+	SCIMMaxPageSize     = 500
+
+	// How long audit events are kept when AUDIT_LOG_RETENTION is not set
+	DefaultAuditLogRetention = 365 * 24 * time.Hour
+
+	// How often audit events past the retention period are deleted
+	AuditRetentionSweepInterval = time.Hour
+
+	// Audit log page size when the client does not ask for one
+	DefaultAuditLogPageSize = 50
+
+	// Chain of audit events that concern neither an account nor a known
+	// user, such as failed logins for unknown emails
+	SystemAuditChainID = "system"
+
+	// How many audit events a user deletion redacts per query
+	AuditRedactionBatchSize = 500
+
+	// How recently a user without a password must have logged in to delete
+	// their user
+	DeletionReauthenticationWindow = 10 * time.Minute
+
+	// How long a deleted user can change their mind when USER_DELETION_GRACE_PERIOD is not set
+	DefaultUserDeletionGracePeriod = 14 * 24 * time.Hour
+
+	// How often scheduled user deletions are checked for being due
+	UserDeletionSweepInterval = time.Hour
+
+	// How often webhook deliveries are checked for being due
+	WebhookDispatchInterval = 5 * time.Second
+
+	// How long an endpoint has to answer a delivery
+	WebhookTimeout = 10 * time.Second
+
+	// How long a dispatcher has a delivery to itself before another may retry it
+	WebhookDeliveryLease = time.Minute
+
+	// Delivery attempts before giving up, waiting twice as long after each failure
+	WebhookMaxAttempts     = 8
+	WebhookRetryBaseDelay  = 30 * time.Second
+	WebhookDeliveryBatch   = 50
+	WebhookResponseBodyMax = 1024
+
+	// Endpoints are disabled after this many deliveries in a row failed every attempt
+	WebhookDisableAfterFailures = 10
+
+	// Webhook signing secrets start with this
+	WebhookSecretPrefix = "whsec_"
+
+	// How many of an endpoint's latest deliveries its delivery log shows
+	WebhookDeliveryListSize = 100
+
+	// Where the server listens when LISTEN_ADDR is not set
+	DefaultListenAddr = ":8080"
+
+	// Server timeouts when SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT and
+	// SERVER_IDLE_TIMEOUT are not set
+	DefaultServerReadTimeout  = 15 * time.Second
+	DefaultServerWriteTimeout = 30 * time.Second
+	DefaultServerIdleTimeout  = 2 * time.Minute
+
+	// How long shutdown waits for requests and workers when SHUTDOWN_TIMEOUT is not set
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// How long readiness waits for the database to answer a ping
+	ReadinessCheckTimeout = 2 * time.Second
+
+	// Replaces the ID of a deleted user wherever other records refer to them
+	DeletedUserID = "00000000-0000-0000-0000-000000000000"
 )
+
+// Free email providers that no account may claim as its domain
+var PublicEmailDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "outlook.com", "hotmail.com",
+	"live.com", "icloud.com", "me.com", "aol.com", "proton.me", "protonmail.com",
+}
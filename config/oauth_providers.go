@@ -0,0 +1,97 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	ProviderTypeOIDC   = "oidc"
+	ProviderTypeGitHub = "github"
+)
+
+// OAuthProvider is an external identity provider users can sign in with.
+// OIDC providers discover their endpoints from the issuer; the URLs only
+// need to be set for plain OAuth2 providers or to override discovery.
+type OAuthProvider struct {
+	Name         string
+	Type         string
+	ClientID     string
+	ClientSecret string
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	Scopes       []string
+}
+
+// LoadOAuthProviders reads the providers named in OAUTH_PROVIDERS, each
+// configured through OAUTH_<NAME>_* variables. "google" and "github" only need
+// a client ID and secret.
+func LoadOAuthProviders() map[string]OAuthProvider {
+	providers := make(map[string]OAuthProvider)
+
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OAUTH_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OAuthProvider{
+			Name:         name,
+			Type:         os.Getenv(prefix + "TYPE"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+			AuthURL:      os.Getenv(prefix + "AUTH_URL"),
+			TokenURL:     os.Getenv(prefix + "TOKEN_URL"),
+			UserInfoURL:  os.Getenv(prefix + "USERINFO_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		switch name {
+		case "google":
+			provider.Type = ProviderTypeOIDC
+			if provider.Issuer == "" {
+				provider.Issuer = "https://accounts.google.com"
+			}
+		case "github":
+			provider.Type = ProviderTypeGitHub
+			if provider.AuthURL == "" {
+				provider.AuthURL = "https://github.com/login/oauth/authorize"
+			}
+			if provider.TokenURL == "" {
+				provider.TokenURL = "https://github.com/login/oauth/access_token"
+			}
+			if provider.UserInfoURL == "" {
+				provider.UserInfoURL = "https://api.github.com"
+			}
+		}
+		if provider.Type == "" {
+			provider.Type = ProviderTypeOIDC
+		}
+
+		if len(provider.Scopes) == 0 {
+			if provider.Type == ProviderTypeGitHub {
+				provider.Scopes = []string{"read:user", "user:email"}
+			} else {
+				provider.Scopes = []string{"openid", "email", "profile"}
+			}
+		}
+
+		if provider.ClientID == "" || (provider.Type == ProviderTypeOIDC && provider.Issuer == "") {
+			slog.Warn("OAuth provider is missing its client ID or issuer, skipping", "provider", name)
+			continue
+		}
+		if provider.Type != ProviderTypeOIDC && provider.Type != ProviderTypeGitHub {
+			slog.Warn("OAuth provider has an unknown type, skipping", "provider", name, "type", provider.Type)
+			continue
+		}
+
+		providers[name] = provider
+	}
+
+	return providers
+}
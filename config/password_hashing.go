@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHashing selects the algorithm and parameters for new password
+// hashes. Hashes made with other settings are upgraded on the next login.
+type PasswordHashing struct {
+	Algorithm         string // "argon2id" or "bcrypt"
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+	Pepper            string // Server side secret mixed into argon2id hashes, optional
+	PepperID          string // Recorded in the hash so the pepper can be rotated
+	// Retired peppers by ID, still accepted for hashes made with them until
+	// their users log in and are rehashed with the current one
+	RetiredPeppers map[string]string
+}
+
+// LoadPasswordHashing reads the hashing settings from the environment,
+// rejecting argon2id and bcrypt parameters it cannot hash with
+func LoadPasswordHashing() (PasswordHashing, error) {
+	algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM")
+	if algorithm == "" {
+		algorithm = "argon2id"
+	}
+
+	pepperID := os.Getenv("PASSWORD_PEPPER_ID")
+	if pepperID == "" {
+		pepperID = "1"
+	}
+
+	// Listed in PASSWORD_RETIRED_PEPPER_IDS, each read from PASSWORD_PEPPER_<ID>
+	retiredPeppers := make(map[string]string)
+	for _, id := range strings.Split(os.Getenv("PASSWORD_RETIRED_PEPPER_IDS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || id == pepperID {
+			continue
+		}
+		if pepper := os.Getenv("PASSWORD_PEPPER_" + strings.ToUpper(id)); pepper != "" {
+			retiredPeppers[id] = pepper
+		}
+	}
+
+	// argon2 panics on zero iterations or parallelism, and parallelism is a
+	// uint8 that larger values would wrap around
+	iterations := getIntEnv("ARGON2_ITERATIONS", 3)
+	if iterations < 1 {
+		return PasswordHashing{}, fmt.Errorf("ARGON2_ITERATIONS must be at least 1, got %d", iterations)
+	}
+	parallelism := getIntEnv("ARGON2_PARALLELISM", 2)
+	if parallelism < 1 || parallelism > 255 {
+		return PasswordHashing{}, fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255, got %d", parallelism)
+	}
+	// argon2 needs 8 KiB per lane, and hashes with less could not be verified
+	memory := getIntEnv("ARGON2_MEMORY_KIB", 64*1024)
+	if memory < 8*parallelism || memory > math.MaxUint32 {
+		return PasswordHashing{}, fmt.Errorf("ARGON2_MEMORY_KIB must be at least 8 per lane (%d) and fit in 32 bits, got %d", 8*parallelism, memory)
+	}
+	// Out of range costs either fail every hash or are silently replaced by
+	// bcrypt's default, which would rehash on every login
+	cost := getIntEnv("BCRYPT_COST", 10)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return PasswordHashing{}, fmt.Errorf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+	}
+
+	return PasswordHashing{
+		Algorithm:         algorithm,
+		Argon2Memory:      uint32(memory),
+		Argon2Iterations:  uint32(iterations),
+		Argon2Parallelism: uint8(parallelism),
+		BcryptCost:        cost,
+		Pepper:            os.Getenv("PASSWORD_PEPPER"),
+		PepperID:          pepperID,
+		RetiredPeppers:    retiredPeppers,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadPasswordHashing(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string // Part of the error, empty when the settings are valid
+	}{
+		{name: "defaults"},
+		{
+			name: "smallest usable argon2id parameters",
+			env:  map[string]string{"ARGON2_ITERATIONS": "1", "ARGON2_PARALLELISM": "1", "ARGON2_MEMORY_KIB": "8"},
+		},
+		{
+			name: "bcrypt cost bounds",
+			env:  map[string]string{"BCRYPT_COST": "31"},
+		},
+		{
+			name: "zero iterations",
+			env:  map[string]string{"ARGON2_ITERATIONS": "0"},
+			want: "ARGON2_ITERATIONS",
+		},
+		{
+			name: "zero parallelism",
+			env:  map[string]string{"ARGON2_PARALLELISM": "0"},
+			want: "ARGON2_PARALLELISM",
+		},
+		{
+			name: "parallelism wrapping a uint8",
+			env:  map[string]string{"ARGON2_PARALLELISM": "256"},
+			want: "ARGON2_PARALLELISM",
+		},
+		{
+			name: "zero memory",
+			env:  map[string]string{"ARGON2_MEMORY_KIB": "0"},
+			want: "ARGON2_MEMORY_KIB",
+		},
+		{
+			name: "memory below 8 KiB per lane",
+			env:  map[string]string{"ARGON2_PARALLELISM": "4", "ARGON2_MEMORY_KIB": "31"},
+			want: "ARGON2_MEMORY_KIB",
+		},
+		{
+			name: "bcrypt cost below the minimum",
+			env:  map[string]string{"BCRYPT_COST": "3"},
+			want: "BCRYPT_COST",
+		},
+		{
+			name: "bcrypt cost above the maximum",
+			env:  map[string]string{"BCRYPT_COST": "32"},
+			want: "BCRYPT_COST",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"ARGON2_ITERATIONS", "ARGON2_PARALLELISM", "ARGON2_MEMORY_KIB", "BCRYPT_COST"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			settings, err := LoadPasswordHashing()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("valid settings rejected: %v", err)
+				}
+				if settings.Argon2Memory < 8*uint32(settings.Argon2Parallelism) {
+					t.Errorf("memory %d is too small for %d lanes", settings.Argon2Memory, settings.Argon2Parallelism)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("settings were accepted")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to name %s", err, tt.want)
+			}
+		})
+	}
+}
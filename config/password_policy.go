@@ -0,0 +1,61 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// PasswordPolicy holds the rules every new password has to satisfy
+type PasswordPolicy struct {
+	MinLength             int
+	RequireUpper          bool
+	RequireLower          bool
+	RequireDigit          bool
+	RequireSymbol         bool
+	ForbidPersonalInfo    bool   // Password may not contain the username or email
+	HistorySize           int    // Number of most recent passwords, the current one included, that cannot be reused
+	BreachedPasswordsFile string // SHA-1 hashes, one per line, optionally followed by ":count"
+}
+
+// LoadPasswordPolicy reads the policy from PASSWORD_* environment variables
+func LoadPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:             getIntEnv("PASSWORD_MIN_LENGTH", 8),
+		RequireUpper:          getBoolEnv("PASSWORD_REQUIRE_UPPER", true),
+		RequireLower:          getBoolEnv("PASSWORD_REQUIRE_LOWER", true),
+		RequireDigit:          getBoolEnv("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol:         getBoolEnv("PASSWORD_REQUIRE_SYMBOL", false),
+		ForbidPersonalInfo:    getBoolEnv("PASSWORD_FORBID_PERSONAL_INFO", true),
+		HistorySize:           getIntEnv("PASSWORD_HISTORY_SIZE", 5),
+		BreachedPasswordsFile: os.Getenv("PASSWORD_BREACHED_LIST_FILE"),
+	}
+}
+
+func getIntEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid setting, using the default", "setting", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return n
+}
+
+func getBoolEnv(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid setting, using the default", "setting", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return b
+}
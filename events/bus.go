@@ -0,0 +1,120 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Event is something that happened in a service. Its name selects the
+// subscribers, every event type has its own.
+type Event interface {
+	Name() string
+}
+
+type subscriber struct {
+	handle func(Event)
+	async  bool
+}
+
+// Bus hands published events to the subscribers of their type. The services
+// publish to it, notifications, webhooks and the like subscribe, so they can
+// be attached without the services knowing about them.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]subscriber
+	running     sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[string][]subscriber)}
+}
+
+// Default is the bus the services publish to
+var Default = NewBus()
+
+// Subscribe calls handler with every event of type E before Publish returns,
+// in the order the handlers subscribed
+func Subscribe[E Event](bus *Bus, handler func(E)) {
+	var event E
+	bus.subscribe(event.Name(), subscriber{handle: func(e Event) { handler(e.(E)) }})
+}
+
+// SubscribeAsync calls handler with every event of type E in a goroutine of
+// its own, for side effects the publisher should not wait for
+func SubscribeAsync[E Event](bus *Bus, handler func(E)) {
+	var event E
+	bus.subscribe(event.Name(), subscriber{handle: func(e Event) { handler(e.(E)) }, async: true})
+}
+
+func (b *Bus) subscribe(name string, sub subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[name] = append(b.subscribers[name], sub)
+}
+
+// Publish hands the event to its subscribers. A failing subscriber is
+// logged, it never fails the publisher or the other subscribers.
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers[event.Name()]
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		if sub.async {
+			b.running.Add(1)
+			go func() {
+				defer b.running.Done()
+				deliver(sub, event)
+			}()
+			continue
+		}
+		deliver(sub, event)
+	}
+}
+
+// Wait blocks until the asynchronous subscribers handled every event
+// published so far
+func (b *Bus) Wait() {
+	b.running.Wait()
+}
+
+// Batch holds events back until the transaction they were raised in
+// commits. Events of a rolled back transaction are dropped with the batch.
+type Batch struct {
+	bus    *Bus
+	mu     sync.Mutex
+	events []Event
+}
+
+func (b *Bus) Batch() *Batch {
+	return &Batch{bus: b}
+}
+
+func (b *Batch) Add(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, event)
+}
+
+// Publish publishes the held events in the order they were added
+func (b *Batch) Publish() {
+	b.mu.Lock()
+	held := b.events
+	b.events = nil
+	b.mu.Unlock()
+
+	for _, event := range held {
+		b.bus.Publish(event)
+	}
+}
+
+// Helper functions
+
+func deliver(sub subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Event subscriber panicked", "event", event.Name(), "panic", r)
+		}
+	}()
+	sub.handle(event)
+}
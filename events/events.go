@@ -0,0 +1,125 @@
+package events
+
+import "go-backend/models"
+
+// UserRegistered is published once a new user is stored, before they
+// verified their phone
+type UserRegistered struct {
+	User   models.User
+	Method string // password or oauth:<provider>
+}
+
+func (UserRegistered) Name() string { return "user.registered" }
+
+type UserLoggedIn struct {
+	UserID string
+	Method string // password, magic_link, saml or oauth:<provider>
+}
+
+func (UserLoggedIn) Name() string { return "user.logged_in" }
+
+type EmailVerified struct {
+	UserID string
+}
+
+func (EmailVerified) Name() string { return "user.email_verified" }
+
+type PhoneVerified struct {
+	UserID string
+}
+
+func (PhoneVerified) Name() string { return "user.phone_verified" }
+
+// PasswordReset is published when a forgotten password was replaced with a
+// reset code
+type PasswordReset struct {
+	UserID string
+}
+
+func (PasswordReset) Name() string { return "user.password_reset" }
+
+type PasswordChanged struct {
+	UserID string
+}
+
+func (PasswordChanged) Name() string { return "user.password_changed" }
+
+type MFAEnabled struct {
+	UserID string
+}
+
+func (MFAEnabled) Name() string { return "user.mfa_enabled" }
+
+type AccountCreated struct {
+	Account models.Account
+}
+
+func (AccountCreated) Name() string { return "account.created" }
+
+type InvitationCreated struct {
+	Invitation  models.Invitation
+	AccountName string
+}
+
+func (InvitationCreated) Name() string { return "invitation.created" }
+
+// InvitationResent is published when an invitation is sent again with a new
+// expiry
+type InvitationResent struct {
+	Invitation  models.Invitation
+	AccountName string
+}
+
+func (InvitationResent) Name() string { return "invitation.resent" }
+
+type InvitationAccepted struct {
+	Invitation models.Invitation
+}
+
+func (InvitationAccepted) Name() string { return "invitation.accepted" }
+
+type InvitationDeclined struct {
+	Invitation models.Invitation
+}
+
+func (InvitationDeclined) Name() string { return "invitation.declined" }
+
+// MembershipCreated is published whenever a user joins an account, however
+// they got in
+type MembershipCreated struct {
+	AccountID string
+	UserID    string
+	Role      models.MembershipRole
+	Via       string // invitation, join_link, domain, access_request or scim
+}
+
+func (MembershipCreated) Name() string { return "membership.created" }
+
+type AccessRequestCreated struct {
+	AccessRequest models.AccessRequest
+}
+
+func (AccessRequestCreated) Name() string { return "access_request.created" }
+
+// AccessRequestReviewed is published when a request was approved or denied
+type AccessRequestReviewed struct {
+	AccessRequest models.AccessRequest
+}
+
+func (AccessRequestReviewed) Name() string { return "access_request.reviewed" }
+
+// ContactChanged is published when a user confirmed a new email address or
+// phone number. NotifyEmail is the address the user had before.
+type ContactChanged struct {
+	Change      models.ContactChange
+	NotifyEmail string
+}
+
+func (ContactChanged) Name() string { return "user.contact_changed" }
+
+type UserDeletionScheduled struct {
+	Deletion models.UserDeletion
+	Email    string
+}
+
+func (UserDeletionScheduled) Name() string { return "user.deletion_scheduled" }
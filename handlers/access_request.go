@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RequestAccess(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.CreateAccessRequestRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	accessRequest, err := accountService.RequestAccess(userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, accessRequest)
+}
+
+func ListMyAccessRequests(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	requests, err := accountService.ListUserAccessRequests(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func WithdrawAccessRequest(c *gin.Context) {
+	requestID := c.Param("requestId")
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.WithdrawAccessRequest(requestID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access request withdrawn"})
+}
+
+func ListAccessRequests(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	status := models.AccessRequestStatus(c.Query("status"))
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	requests, err := accountService.ListAccessRequests(accountID, principal, status)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func ApproveAccessRequest(c *gin.Context) {
+	accountID := c.Param("accountId")
+	requestID := c.Param("requestId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.ApproveAccessRequest(accountID, requestID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access request approved"})
+}
+
+func DenyAccessRequest(c *gin.Context) {
+	accountID := c.Param("accountId")
+	requestID := c.Param("requestId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.DenyAccessRequest(accountID, requestID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access request denied"})
+}
@@ -11,17 +11,16 @@ import (
 
 func CreateAccount(c *gin.Context) {
 	var req models.CreateAccountRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
 	userID := middleware.GetUserID(c)
-	accountService := services.NewAccountService()
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
 
 	account, err := accountService.CreateAccount(userID, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -30,11 +29,11 @@ func CreateAccount(c *gin.Context) {
 
 func ListAccounts(c *gin.Context) {
 	userID := middleware.GetUserID(c)
-	accountService := services.NewAccountService()
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
 
 	accounts, err := accountService.ListUserAccounts(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -43,32 +42,107 @@ func ListAccounts(c *gin.Context) {
 
 func InviteMember(c *gin.Context) {
 	accountID := c.Param("accountId")
-	userID := middleware.GetUserID(c)
+	principal := middleware.GetPrincipal(c)
 
 	var req models.InviteMemberRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	invitation, err := accountService.InviteMember(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent successfully", "invitation": invitation})
+}
+
+func ListAccountInvitations(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+	status := models.InvitationStatus(c.Query("status"))
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	invitations, err := accountService.ListAccountInvitations(accountID, principal, status)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	accountService := services.NewAccountService()
-	err := accountService.InviteMember(accountID, userID, req.Email)
+	c.JSON(http.StatusOK, invitations)
+}
+
+func CancelInvitation(c *gin.Context) {
+	accountID := c.Param("accountId")
+	invitationID := c.Param("invitationId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.CancelInvitation(accountID, invitationID, principal)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Invitation sent successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation cancelled"})
+}
+
+func ResendInvitation(c *gin.Context) {
+	accountID := c.Param("accountId")
+	invitationID := c.Param("invitationId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	invitation, err := accountService.ResendInvitation(accountID, invitationID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation resent", "invitation": invitation})
+}
+
+func ListInvitations(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	invitations, err := accountService.ListUserInvitations(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
 }
 
 func AcceptInvitation(c *gin.Context) {
 	invitationID := c.Param("invitationId")
 	userID := middleware.GetUserID(c)
 
-	accountService := services.NewAccountService()
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
 	err := accountService.AcceptInvitation(invitationID, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation accepted"})
+}
+
+func AcceptInvitationByToken(c *gin.Context) {
+	var req models.AcceptInvitationTokenRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.AcceptInvitationByToken(req.Token, userID)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -79,10 +153,10 @@ func DeclineInvitation(c *gin.Context) {
 	invitationID := c.Param("invitationId")
 	userID := middleware.GetUserID(c)
 
-	accountService := services.NewAccountService()
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
 	err := accountService.DeclineInvitation(invitationID, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateAPIKey(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.CreateAPIKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	apiKey, err := accountService.CreateAPIKey(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, apiKey)
+}
+
+func ListAPIKeys(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	apiKeys, err := accountService.ListAPIKeys(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+func RevokeAPIKey(c *gin.Context) {
+	accountID := c.Param("accountId")
+	keyID := c.Param("keyId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.RevokeAPIKey(accountID, keyID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
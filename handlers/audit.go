@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListAuditLog(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var query models.AuditLogQuery
+	if !bindQuery(c, &query) {
+		return
+	}
+
+	auditService := services.NewAuditService()
+	events, err := auditService.ListAccountEvents(accountID, principal, query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func ListSecurityEvents(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var query models.AuditLogQuery
+	if !bindQuery(c, &query) {
+		return
+	}
+
+	auditService := services.NewAuditService()
+	events, err := auditService.ListUserEvents(userID, query)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
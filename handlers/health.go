@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz tells the orchestrator the process is up. It checks nothing else,
+// so a database outage does not get every instance restarted.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz tells the orchestrator whether to send this instance traffic
+func Readyz(c *gin.Context) {
+	readiness, ready := services.NewHealthService().Readiness(c.Request.Context())
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, readiness)
+		return
+	}
+
+	c.JSON(http.StatusOK, readiness)
+}
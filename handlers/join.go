@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateJoinLink(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.CreateJoinLinkRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	link, err := accountService.CreateJoinLink(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+func ListJoinLinks(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	links, err := accountService.ListJoinLinks(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, links)
+}
+
+func RevokeJoinLink(c *gin.Context) {
+	accountID := c.Param("accountId")
+	linkID := c.Param("linkId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.RevokeJoinLink(accountID, linkID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Join link revoked"})
+}
+
+func JoinWithLink(c *gin.Context) {
+	code := c.Param("code")
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	response, err := accountService.JoinWithLink(code, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func AddAccountDomain(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.AddAccountDomainRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	domain, err := accountService.AddDomain(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, domain)
+}
+
+func ListAccountDomains(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	domains, err := accountService.ListDomains(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, domains)
+}
+
+func VerifyAccountDomain(c *gin.Context) {
+	accountID := c.Param("accountId")
+	domainID := c.Param("domainId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	domain, err := accountService.VerifyDomain(accountID, domainID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, domain)
+}
+
+func RemoveAccountDomain(c *gin.Context) {
+	accountID := c.Param("accountId")
+	domainID := c.Param("domainId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.RemoveDomain(accountID, domainID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain removed"})
+}
+
+func DiscoverAccounts(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	accounts, err := accountService.DiscoverAccounts(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+func JoinAccount(c *gin.Context) {
+	accountID := c.Param("accountId")
+	userID := middleware.GetUserID(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	response, err := accountService.JoinByDomain(accountID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
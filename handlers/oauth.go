@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OAuthToken implements the token endpoint of the OAuth2 client credentials
+// grant (RFC 6749 section 4.4). Clients authenticate with HTTP Basic or with
+// client_id and client_secret form parameters.
+func OAuthToken(c *gin.Context) {
+	// Token responses must never be cached
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	if c.PostForm("grant_type") != "client_credentials" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+	if clientID == "" || clientSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	response, err := accountService.IssueClientCredentialsToken(clientID, clientSecret, c.PostForm("scope"))
+	if err != nil {
+		// Token endpoint errors keep the format of RFC 6749 section 5.2
+		switch {
+		case errors.Is(err, services.ErrInvalidClient):
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		case errors.Is(err, services.ErrInvalidScope):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+		default:
+			c.Error(err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
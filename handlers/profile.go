@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetMe(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	user, err := services.GetUserByID(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func UpdateMe(c *gin.Context) {
+	var req models.UpdateProfileRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	user, err := services.UpdateProfile(userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func GetUser(c *gin.Context) {
+	id := c.Param("id")
+	userID := middleware.GetUserID(c)
+
+	user, err := services.GetVisibleUser(userID, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func ChangeEmail(c *gin.Context) {
+	var req models.ChangeEmailRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	contactChangeService := services.NewContactChangeService().WithClient(clientInfo(c))
+	if err := contactChangeService.RequestEmailChange(userID, req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "A confirmation code was sent to the new email address"})
+}
+
+func ConfirmEmailChange(c *gin.Context) {
+	confirmContactChange(c, models.ContactEmail)
+}
+
+func ChangePhone(c *gin.Context) {
+	var req models.ChangePhoneRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	contactChangeService := services.NewContactChangeService().WithClient(clientInfo(c))
+	if err := contactChangeService.RequestPhoneChange(userID, req); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "A confirmation code was sent to the new phone number"})
+}
+
+func ConfirmPhoneChange(c *gin.Context) {
+	confirmContactChange(c, models.ContactPhone)
+}
+
+func RevertContactChange(c *gin.Context) {
+	var req models.RevertContactChangeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	contactChangeService := services.NewContactChangeService().WithClient(clientInfo(c))
+	if err := contactChangeService.RevertChange(req.Token); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "The change has been reverted"})
+}
+
+func DeleteMe(c *gin.Context) {
+	var req models.DeleteUserRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	userDeletionService := services.NewUserDeletionService()
+	deletion, err := userDeletionService.RequestDeletion(userID, middleware.GetAuthTime(c), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deletion)
+}
+
+func CancelDeleteMe(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	userDeletionService := services.NewUserDeletionService()
+	if err := userDeletionService.CancelDeletion(userID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deletion has been cancelled"})
+}
+
+func ExportMe(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	userDeletionService := services.NewUserDeletionService()
+	export, err := userDeletionService.Export(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="user-export.json"`)
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+func confirmContactChange(c *gin.Context, kind models.ContactKind) {
+	var req models.ConfirmContactChangeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	contactChangeService := services.NewContactChangeService().WithClient(clientInfo(c))
+	user, err := contactChangeService.ConfirmChange(userID, kind, req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SCIMServiceProviderConfig(c *gin.Context) {
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	scimJSON(c, http.StatusOK, scimService.ServiceProviderConfig())
+}
+
+func SCIMListUsers(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+	startIndex, count := scimPagination(c)
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	users, err := scimService.ListUsers(principal, c.Query("filter"), startIndex, count)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, users)
+}
+
+func SCIMGetUser(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	user, err := scimService.GetUser(principal, c.Param("id"))
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, user)
+}
+
+func SCIMCreateUser(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	var req models.SCIMUserResource
+	if !bindSCIM(c, &req) {
+		return
+	}
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	user, err := scimService.CreateUser(principal, req)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	c.Header("Location", user.Meta.Location)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+func SCIMReplaceUser(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	var req models.SCIMUserResource
+	if !bindSCIM(c, &req) {
+		return
+	}
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	user, err := scimService.ReplaceUser(principal, c.Param("id"), req)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, user)
+}
+
+func SCIMPatchUser(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	var req models.SCIMPatchRequest
+	if !bindSCIM(c, &req) {
+		return
+	}
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	user, err := scimService.PatchUser(principal, c.Param("id"), req)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, user)
+}
+
+func SCIMDeleteUser(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	if err := scimService.DeleteUser(principal, c.Param("id")); err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func SCIMListGroups(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+	startIndex, count := scimPagination(c)
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	groups, err := scimService.ListGroups(principal, c.Query("filter"), startIndex, count, scimExcludesMembers(c))
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, groups)
+}
+
+func SCIMGetGroup(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	group, err := scimService.GetGroup(principal, c.Param("id"), scimExcludesMembers(c))
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, group)
+}
+
+func SCIMReplaceGroup(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	var req models.SCIMGroupResource
+	if !bindSCIM(c, &req) {
+		return
+	}
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	group, err := scimService.ReplaceGroup(principal, c.Param("id"), req)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, group)
+}
+
+func SCIMPatchGroup(c *gin.Context) {
+	principal := middleware.GetPrincipal(c)
+
+	var req models.SCIMPatchRequest
+	if !bindSCIM(c, &req) {
+		return
+	}
+
+	scimService := services.NewSCIMService().WithClient(clientInfo(c))
+	group, err := scimService.PatchGroup(principal, c.Param("id"), req)
+	if err != nil {
+		handleSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, group)
+}
+
+// scimJSON writes a response with the SCIM media type
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, body)
+}
+
+func handleSCIMError(c *gin.Context, err error) {
+	var scimErr *services.SCIMError
+	if errors.As(err, &scimErr) {
+		scimJSON(c, scimErr.Status, models.NewSCIMErrorResponse(scimErr.Status, scimErr.ScimType, scimErr.Detail))
+		return
+	}
+
+	appErr := apperrors.From(err)
+	if appErr.Kind == apperrors.KindInternal {
+		slog.Error("SCIM request failed", "request_id", c.GetString(middleware.RequestIDKey), "route", c.FullPath(), "error", err)
+	}
+	scimJSON(c, appErr.Status(), models.NewSCIMErrorResponse(appErr.Status(), "", appErr.Detail))
+}
+
+func bindSCIM(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		scimJSON(c, http.StatusBadRequest, models.NewSCIMErrorResponse(http.StatusBadRequest, "invalidSyntax", "Invalid input"))
+		return false
+	}
+	return true
+}
+
+func scimPagination(c *gin.Context) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		startIndex = 1
+	}
+	count, err = strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(config.SCIMDefaultPageSize)))
+	if err != nil {
+		count = config.SCIMDefaultPageSize
+	}
+	return startIndex, count
+}
+
+func scimExcludesMembers(c *gin.Context) bool {
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateServiceAccount(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.CreateServiceAccountRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	serviceAccount, err := accountService.CreateServiceAccount(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, serviceAccount)
+}
+
+func ListServiceAccounts(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	serviceAccounts, err := accountService.ListServiceAccounts(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceAccounts)
+}
+
+func UpdateServiceAccountRole(c *gin.Context) {
+	accountID := c.Param("accountId")
+	serviceAccountID := c.Param("serviceAccountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.UpdateServiceAccountRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	serviceAccount, err := accountService.UpdateServiceAccountRole(accountID, serviceAccountID, principal, req.Role)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceAccount)
+}
+
+func RotateServiceAccountSecret(c *gin.Context) {
+	accountID := c.Param("accountId")
+	serviceAccountID := c.Param("serviceAccountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	serviceAccount, err := accountService.RotateServiceAccountSecret(accountID, serviceAccountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, serviceAccount)
+}
+
+func DisableServiceAccount(c *gin.Context) {
+	accountID := c.Param("accountId")
+	serviceAccountID := c.Param("serviceAccountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.DisableServiceAccount(accountID, serviceAccountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service account disabled"})
+}
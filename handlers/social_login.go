@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"go-backend/config"
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func StartSocialLogin(c *gin.Context) {
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	authURL, state, err := socialLoginService.StartLogin(c.Param("provider"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	setOAuthStateCookie(c, state, int(config.OAuthStateTTL.Seconds()))
+	c.JSON(http.StatusOK, models.AuthorizationURLResponse{AuthorizationURL: authURL})
+}
+
+func SocialLoginCallback(c *gin.Context) {
+	var req models.OAuthCallbackRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	browserState, _ := c.Cookie(config.OAuthStateCookieName)
+	setOAuthStateCookie(c, "", -1)
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	response, err := socialLoginService.CompleteLogin(c.Param("provider"), req, browserState)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func SocialRegister(c *gin.Context) {
+	var req models.SocialRegisterRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	response, err := socialLoginService.Register(req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func ListIdentities(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	response, err := socialLoginService.ListIdentities(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func StartLinkIdentity(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	authURL, state, err := socialLoginService.StartLink(userID, c.Param("provider"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	setOAuthStateCookie(c, state, int(config.OAuthStateTTL.Seconds()))
+	c.JSON(http.StatusOK, models.AuthorizationURLResponse{AuthorizationURL: authURL})
+}
+
+func LinkIdentityCallback(c *gin.Context) {
+	var req models.OAuthCallbackRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+	browserState, _ := c.Cookie(config.OAuthStateCookieName)
+	setOAuthStateCookie(c, "", -1)
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	identity, err := socialLoginService.CompleteLink(userID, c.Param("provider"), req, browserState)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, identity)
+}
+
+func UnlinkIdentity(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	socialLoginService := services.NewSocialLoginService().WithClient(clientInfo(c))
+	if err := socialLoginService.Unlink(userID, c.Param("provider")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
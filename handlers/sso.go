@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ConfigureSAML(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.ConfigureSAMLRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	connection, err := accountService.ConfigureSAML(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, connection)
+}
+
+func GetSAMLConnection(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	connection, err := accountService.GetSAMLConnection(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, connection)
+}
+
+func DeleteSAMLConnection(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	if err := accountService.DeleteSAMLConnection(accountID, principal); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SAML connection removed"})
+}
+
+func SAMLMetadata(c *gin.Context) {
+	ssoService := services.NewSSOService().WithClient(clientInfo(c))
+	metadata, err := ssoService.Metadata(c.Param("accountId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLogin sends the browser to the account's identity provider
+func SAMLLogin(c *gin.Context) {
+	ssoService := services.NewSSOService().WithClient(clientInfo(c))
+	redirectURL, err := ssoService.StartLogin(c.Param("accountId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLAssertionConsumer receives the identity provider's HTTP-POST response
+// and hands the browser back to the app with a code to exchange for a token
+func SAMLAssertionConsumer(c *gin.Context) {
+	ssoService := services.NewSSOService().WithClient(clientInfo(c))
+	redirectURL, err := ssoService.HandleResponse(c.Param("accountId"), c.PostForm("SAMLResponse"), c.PostForm("RelayState"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, redirectURL)
+}
+
+func SSOExchange(c *gin.Context) {
+	var req models.SSOExchangeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	ssoService := services.NewSSOService().WithClient(clientInfo(c))
+	response, err := ssoService.Exchange(req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"go-backend/config"
 	"go-backend/middleware"
 	"go-backend/models"
 	"go-backend/services"
@@ -11,18 +12,14 @@ import (
 
 func Register(c *gin.Context) {
 	var req models.RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		if handled := handleValidationError(c, err); handled {
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.Register(req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -31,38 +28,69 @@ func Register(c *gin.Context) {
 
 func Login(c *gin.Context) {
 	var req models.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		if handled := handleValidationError(c, err); handled {
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.Login(req)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+func RequestMagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	authService := services.NewAuthService().WithClient(clientInfo(c))
+	browserSecret, err := authService.RequestMagicLink(req.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	setMagicLinkCookie(c, browserSecret, int(config.MagicLinkTTL.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"message": "If the email belongs to a user, a sign-in link has been sent"})
+}
+
+func MagicLinkLogin(c *gin.Context) {
+	var req models.MagicLinkLoginRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	browserSecret, _ := c.Cookie(config.MagicLinkCookieName)
+
+	authService := services.NewAuthService().WithClient(clientInfo(c))
+	response, err := authService.LoginWithMagicLink(req.Token, browserSecret)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	setMagicLinkCookie(c, "", -1)
+	c.JSON(http.StatusOK, response)
+}
+
 func VerifyEmail(c *gin.Context) {
 	var req struct {
 		Email string `json:"email" binding:"required,email"`
 		Code  string `json:"code" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.VerifyEmail(req.Email, req.Code)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -71,21 +99,17 @@ func VerifyEmail(c *gin.Context) {
 
 func VerifyPhone(c *gin.Context) {
 	var req struct {
-		Phone string `json:"phone" binding:"required"`
+		Phone string `json:"phone" binding:"required,phone"`
 		Code  string `json:"code" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		if handled := handleValidationError(c, err); handled {
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.VerifyPhone(req.Phone, req.Code)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -95,10 +119,10 @@ func VerifyPhone(c *gin.Context) {
 func EnableMFA(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.EnableMFA(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -107,17 +131,16 @@ func EnableMFA(c *gin.Context) {
 
 func VerifyMFA(c *gin.Context) {
 	var req models.VerifyMFARequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
 	userID := middleware.GetUserID(c)
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	response, err := authService.VerifyMFA(userID, req.Code)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -126,15 +149,14 @@ func VerifyMFA(c *gin.Context) {
 
 func ForgotPassword(c *gin.Context) {
 	var req models.ForgotPasswordRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	err := authService.ForgotPassword(req.Email)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -143,17 +165,34 @@ func ForgotPassword(c *gin.Context) {
 
 func ResetPassword(c *gin.Context) {
 	var req models.ResetPasswordRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	authService := services.NewAuthService()
+	authService := services.NewAuthService().WithClient(clientInfo(c))
 	err := authService.ResetPassword(req.Code, req.Password)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset successfully"})
 }
+
+func ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	userID := middleware.GetUserID(c)
+
+	authService := services.NewAuthService().WithClient(clientInfo(c))
+	err := authService.ChangePassword(userID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been changed successfully"})
+}
@@ -1,33 +1,40 @@
 package handlers
 
 import (
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/middleware"
+	"go-backend/models"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 )
 
-func handleValidationError(c *gin.Context, err error) bool {
-	if validationErrors, ok := err.(validator.ValidationErrors); ok {
-		errorMessages := make([]string, 0)
-		for _, e := range validationErrors {
-			switch e.Tag() {
-			case "required":
-				errorMessages = append(errorMessages,
-					e.Field()+" is required")
-			case "email":
-				errorMessages = append(errorMessages,
-					"Invalid email format")
-			case "min":
-				errorMessages = append(errorMessages,
-					e.Field()+" must be at least "+e.Param()+" characters long")
-			case "len":
-				errorMessages = append(errorMessages,
-					e.Field()+" must be exactly "+e.Param()+" characters long")
-			}
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"errors": errorMessages})
-		return true
+// setMagicLinkCookie stores the browser secret a login link is bound to. A
+// negative maxAge removes it.
+func setMagicLinkCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(config.MagicLinkCookieName, value, maxAge, "/auth/login/magic-link", "", c.Request.TLS != nil, true)
+}
+
+// setOAuthStateCookie keeps the state of a social login in the browser that
+// started it. A negative maxAge removes it.
+func setOAuthStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(config.OAuthStateCookieName, value, maxAge, "/", "", c.Request.TLS != nil, true)
+}
+
+// clientInfo describes where the request came from, for the audit log
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString(middleware.RequestIDKey),
+		Context:   c.Request.Context(),
 	}
-	return false
+}
+
+// NoRoute answers requests for paths no route matches
+func NoRoute(c *gin.Context) {
+	c.Error(apperrors.NotFound("route_not_found", "no such route"))
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	// E.164: a plus, the country code and at most 15 digits in all
+	phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,31}$`)
+)
+
+const accountNameMaxLength = 100
+
+// RegisterValidators adds the phone, username and account_name tags to the
+// validator gin binds requests with, and makes its errors name fields the
+// way clients send them
+func RegisterValidators() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("gin is not validating with go-playground/validator")
+	}
+
+	v.RegisterTagNameFunc(requestFieldName)
+
+	validators := map[string]validator.Func{
+		"phone":        validatePhone,
+		"username":     validateUsername,
+		"account_name": validateAccountName,
+	}
+	for tag, fn := range validators {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return fmt.Errorf("failed to register the %s validator: %v", tag, err)
+		}
+	}
+	return nil
+}
+
+func validatePhone(fl validator.FieldLevel) bool {
+	return phonePattern.MatchString(fl.Field().String())
+}
+
+func validateUsername(fl validator.FieldLevel) bool {
+	return usernamePattern.MatchString(fl.Field().String())
+}
+
+// Account names are shown to other users, so they may not be blank or carry
+// control characters that could garble where they are shown
+func validateAccountName(fl validator.FieldLevel) bool {
+	name := fl.Field().String()
+	if strings.TrimSpace(name) == "" || utf8.RuneCountInString(name) > accountNameMaxLength {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFieldName names a field after its JSON key, or its query parameter
+// for query structs
+func requestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// bindJSON binds the request body, answering with what is wrong with it when
+// it does not fit obj
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		c.Error(invalidInput(err))
+		return false
+	}
+	return true
+}
+
+// bindQuery binds the query string like bindJSON binds the body
+func bindQuery(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindQuery(obj); err != nil {
+		c.Error(invalidInput(err))
+		return false
+	}
+	return true
+}
+
+// invalidInput describes why a request could not be bound, listing every
+// field that failed validation
+func invalidInput(err error) error {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]apperrors.FieldError, 0, len(validationErrors))
+		for _, e := range validationErrors {
+			field := fieldPath(e)
+			fields = append(fields, apperrors.FieldError{
+				Field:   field,
+				Code:    e.Tag(),
+				Message: validationMessage(field, e),
+			})
+		}
+		return apperrors.InvalidFields(fields)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return apperrors.InvalidFields([]apperrors.FieldError{{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type)),
+		}})
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return apperrors.Validation("malformed_json", "Request body is not valid JSON")
+	}
+	if errors.Is(err, io.EOF) {
+		return apperrors.Validation("empty_body", "Request body is empty")
+	}
+	return apperrors.Validation("invalid_input", "Invalid input")
+}
+
+// fieldPath is the field's namespace without the name of the request struct,
+// such as owned_accounts[0].action
+func fieldPath(e validator.FieldError) string {
+	if _, path, ok := strings.Cut(e.Namespace(), "."); ok {
+		return path
+	}
+	return e.Field()
+}
+
+func validationMessage(field string, e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	case "min":
+		return field + " must be at least " + e.Param() + sizeUnit(e)
+	case "max":
+		return field + " must be at most " + e.Param() + sizeUnit(e)
+	case "len":
+		return field + " must be exactly " + e.Param() + sizeUnit(e)
+	case "alphanum":
+		return field + " may only contain letters and numbers"
+	case "url":
+		return field + " must be a valid URL"
+	case "fqdn":
+		return field + " must be a domain name"
+	case "oneof":
+		return field + " must be one of: " + e.Param()
+	case "timezone":
+		return field + " must be a valid IANA timezone"
+	case "bcp47_language_tag":
+		return field + " must be a valid language tag"
+	case "phone":
+		return field + " must be a phone number in E.164 format, such as +14155550123"
+	case "username":
+		return field + " must be 3 to 32 letters, digits, dots, underscores or hyphens, starting with a letter or digit"
+	case "account_name":
+		return fmt.Sprintf("%s must not be blank, have control characters or be longer than %d characters", field, accountNameMaxLength)
+	default:
+		return field + " is invalid"
+	}
+}
+
+// sizeUnit is what min, max and len count for the kind of field
+func sizeUnit(e validator.FieldError) string {
+	switch e.Kind() {
+	case reflect.String:
+		return " characters long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
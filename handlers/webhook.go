@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"go-backend/middleware"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateWebhookEndpoint(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.CreateWebhookEndpointRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	endpoint, err := accountService.CreateWebhookEndpoint(accountID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, endpoint)
+}
+
+func ListWebhookEndpoints(c *gin.Context) {
+	accountID := c.Param("accountId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	endpoints, err := accountService.ListWebhookEndpoints(accountID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoints)
+}
+
+func GetWebhookEndpoint(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	endpoint, err := accountService.GetWebhookEndpoint(accountID, webhookID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+func UpdateWebhookEndpoint(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	principal := middleware.GetPrincipal(c)
+
+	var req models.UpdateWebhookEndpointRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	endpoint, err := accountService.UpdateWebhookEndpoint(accountID, webhookID, principal, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+func DeleteWebhookEndpoint(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	err := accountService.DeleteWebhookEndpoint(accountID, webhookID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook endpoint deleted"})
+}
+
+func RotateWebhookSecret(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	endpoint, err := accountService.RotateWebhookSecret(accountID, webhookID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+func ListWebhookDeliveries(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	principal := middleware.GetPrincipal(c)
+	status := models.WebhookDeliveryStatus(c.Query("status"))
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	deliveries, err := accountService.ListWebhookDeliveries(accountID, webhookID, principal, status)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func ReplayWebhookDelivery(c *gin.Context) {
+	accountID := c.Param("accountId")
+	webhookID := c.Param("webhookId")
+	deliveryID := c.Param("deliveryId")
+	principal := middleware.GetPrincipal(c)
+
+	accountService := services.NewAccountService().WithClient(clientInfo(c))
+	delivery, err := accountService.ReplayWebhookDelivery(accountID, webhookID, deliveryID, principal)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
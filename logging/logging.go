@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// New builds the logger of the service. Everything it writes passes the
+// redaction layer, whatever the level or format.
+func New(w io.Writer, level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: parseLevel(level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(NewRedactingHandler(handler))
+}
+
+// Helper functions
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
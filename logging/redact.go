@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go-backend/config"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces whatever must not be logged
+const Redacted = "[REDACTED]"
+
+// Attribute keys whose values are always redacted. A key matches when one of
+// its words does, so reset_code and client_secret are covered too.
+var sensitiveWords = map[string]bool{
+	"authorization": true,
+	"code":          true,
+	"cookie":        true,
+	"credential":    true,
+	"credentials":   true,
+	"otp":           true,
+	"passcode":      true,
+	"password":      true,
+	"secret":        true,
+	"signature":     true,
+	"token":         true,
+}
+
+// Secrets that are recognisable on their own, wherever they appear in a
+// message or value
+var sensitivePatterns = []*regexp.Regexp{
+	// JSON web tokens
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(regexp.QuoteMeta(config.APIKeyPrefix) + `[A-Za-z0-9_-]+`),
+	regexp.MustCompile(regexp.QuoteMeta(config.WebhookSecretPrefix) + `[A-Za-z0-9_-]+`),
+}
+
+// key=value and "key": "value" pairs with a sensitive key, as in query
+// strings, form bodies, JSON and messages built with fmt
+var sensitivePairPattern = regexp.MustCompile(
+	`(?i)("?[a-z_]*(?:password|secret|token|code|otp|signature|authorization)"?\s*[:=]\s*"?)([^"&\s,}]+)`)
+
+// RedactingHandler removes codes, passwords, tokens and secrets from records
+// before the wrapped handler writes them
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, RedactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, redactAttr(attr))
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+// RedactString masks the secrets that can be recognised in free text
+func RedactString(value string) string {
+	for _, pattern := range sensitivePatterns {
+		value = pattern.ReplaceAllString(value, Redacted)
+	}
+	return sensitivePairPattern.ReplaceAllString(value, "${1}"+Redacted)
+}
+
+// Helper functions
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		group := value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, member := range group {
+			redacted = append(redacted, redactAttr(member))
+		}
+		return slog.Group(attr.Key, redacted...)
+	}
+
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, RedactString(value.String()))
+	case slog.KindAny:
+		// Errors, structs and the like are logged as text, so that is what
+		// has to be clean
+		return slog.String(attr.Key, RedactString(fmt.Sprintf("%+v", value.Any())))
+	default:
+		return slog.Attr{Key: attr.Key, Value: value}
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || r == ' '
+	})
+	for _, word := range words {
+		if sensitiveWords[word] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Login outcomes. Anything other than success means no token was issued
+// yet, or at all.
+const (
+	LoginSuccess                   = "success"
+	LoginInvalidCredentials        = "invalid_credentials"
+	LoginSSORequired               = "sso_required"
+	LoginPhoneVerificationRequired = "phone_verification_required"
+	LoginMFARequired               = "mfa_required"
+)
+
+// Channels messages are delivered through
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+)
+
+var (
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_logins_total",
+		Help: "Login attempts by method and outcome.",
+	}, []string{"method", "outcome"})
+
+	Registrations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_registrations_total",
+		Help: "Users who registered, by method.",
+	}, []string{"method"})
+
+	MFAVerifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_mfa_verifications_total",
+		Help: "MFA code checks by outcome, success or failure.",
+	}, []string{"outcome"})
+
+	PasswordResets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_password_resets_total",
+		Help: "Password resets by stage, requested or completed.",
+	}, []string{"stage"})
+
+	Invitations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "account_invitations_total",
+		Help: "Invitations by the status they moved to.",
+	}, []string{"status"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limit_rejections_total",
+		Help: "Requests rejected by a rate limiter.",
+	}, []string{"limiter"})
+
+	MessageDeliveryFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "message_delivery_failures_total",
+		Help: "Emails, texts and webhook attempts that could not be delivered.",
+	}, []string{"channel"})
+)
+
+// RegisterDBStats exposes the connection pool of the database
+func RegisterDBStats(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	testAccountID = "11111111-1111-1111-1111-111111111111"
+	testKeySecret = "test-secret"
+)
+
+func TestAPIKeyRequired(t *testing.T) {
+	useTestDB(t)
+	key := createTestAPIKey(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "valid key", authorization: "ApiKey " + key, wantStatus: http.StatusOK},
+		{name: "wrong secret", authorization: "ApiKey gbk_testkey.wrong", wantStatus: http.StatusUnauthorized},
+		{name: "bearer scheme", authorization: "Bearer " + key, wantStatus: http.StatusUnauthorized},
+		{name: "no header", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal models.Principal
+			w := serveTestRequest(tt.authorization, &principal, APIKeyRequired())
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusOK &&
+				(principal.Type != models.PrincipalAPIKey || principal.AccountID != testAccountID) {
+				t.Errorf("principal = %+v, want an API key of the account", principal)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+// useTestDB points the services the middleware calls at an in-memory
+// database for the duration of the test
+func useTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.APIKey{}, &models.ServiceAccount{}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.DB
+	config.DB = db
+	t.Cleanup(func() {
+		config.DB = previous
+		db.Close()
+	})
+	return db
+}
+
+func createTestAPIKey(t *testing.T) string {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(testKeySecret))
+	apiKey := models.APIKey{
+		AccountID:   testAccountID,
+		Name:        "ci",
+		Prefix:      "gbk_testkey",
+		SecretHash:  hex.EncodeToString(sum[:]),
+		Scopes:      string(models.PermAccountRead),
+		CreatedByID: testUserID,
+	}
+	if err := config.DB.Create(&apiKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	return apiKey.Prefix + "." + testKeySecret
+}
+
+// serveTestRequest runs a request through the authentication middleware and
+// captures the principal the handler sees
+func serveTestRequest(authorization string, principal *models.Principal, chain ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Errors(slog.Default()))
+	handlers := append(chain, func(c *gin.Context) {
+		*principal = GetPrincipal(c)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/", handlers...)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
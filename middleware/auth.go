@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
+	"time"
 
+	"go-backend/apperrors"
 	"go-backend/config"
+	"go-backend/models"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
@@ -15,20 +17,23 @@ const (
 	SecretKey = "your-secret-key" // In production, use environment variable
 )
 
+var (
+	errInvalidToken       = apperrors.Unauthorized("invalid_token", "Invalid token")
+	errInvalidTokenClaims = apperrors.Unauthorized("invalid_token", "Invalid token claims")
+)
+
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized("missing_authorization", "Authorization header is required"))
 			return
 		}
 
 		// Check if the header starts with "Bearer "
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
+			abortWithError(c, apperrors.Unauthorized("invalid_authorization_header", "Invalid authorization header format"))
 			return
 		}
 
@@ -44,30 +49,94 @@ func AuthRequired() gin.HandlerFunc {
 		})
 
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			abortWithError(c, errInvalidToken)
 			return
 		}
 
 		// Check if the token is valid
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+			// Service accounts act as a machine principal rather than a user
+			if claims["principal_type"] == string(models.PrincipalServiceAccount) {
+				principal, ok := serviceAccountPrincipal(claims)
+				if !ok {
+					abortWithError(c, errInvalidTokenClaims)
+					return
+				}
+				c.Set("principal", principal)
+				c.Set("principal_type", principal.Type)
+				c.Set("account_id", principal.AccountID)
+				c.Next()
+				return
+			}
+
 			// Add user ID to context
 			userID, ok := claims["user_id"].(string)
 			if !ok {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-				c.Abort()
+				abortWithError(c, errInvalidTokenClaims)
 				return
 			}
 			c.Set("user_id", userID)
+			c.Set("principal_type", models.PrincipalUser)
+			if authTime, ok := claims["auth_time"].(float64); ok {
+				c.Set("auth_time", time.Unix(int64(authTime), 0))
+			}
 			c.Next()
 		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			abortWithError(c, errInvalidToken)
+			return
+		}
+	}
+}
+
+// UserRequired rejects machine principals on routes that only make sense
+// for a human user. It must run after AuthRequired.
+func UserRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetPrincipalType(c) != models.PrincipalUser {
+			abortWithError(c, apperrors.Forbidden("user_required", "This endpoint requires a user"))
 			return
 		}
+		c.Next()
 	}
 }
 
+func serviceAccountPrincipal(claims jwt.MapClaims) (models.Principal, bool) {
+	id, _ := claims["sub"].(string)
+	accountID, _ := claims["account_id"].(string)
+	scope, _ := claims["scope"].(string)
+	if id == "" || accountID == "" {
+		return models.Principal{}, false
+	}
+
+	scopes := make([]models.Permission, 0)
+	for _, s := range strings.Fields(scope) {
+		scopes = append(scopes, models.Permission(s))
+	}
+
+	return models.Principal{
+		Type:      models.PrincipalServiceAccount,
+		ID:        id,
+		AccountID: accountID,
+		Scopes:    scopes,
+	}, true
+}
+
+// GetPrincipalType tells handlers whether a human or a machine is calling
+func GetPrincipalType(c *gin.Context) models.PrincipalType {
+	if principalType, ok := c.Get("principal_type"); ok {
+		return principalType.(models.PrincipalType)
+	}
+	return ""
+}
+
+// GetAuthTime returns when the user logged in to get their token, the zero
+// time for tokens that do not say
+func GetAuthTime(c *gin.Context) time.Time {
+	authTime, _ := c.Get("auth_time")
+	t, _ := authTime.(time.Time)
+	return t
+}
+
 // Helper function to get user ID from context
 func GetUserID(c *gin.Context) string {
 	userID, _ := c.Get("user_id")
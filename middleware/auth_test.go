@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go-backend/config"
+	"go-backend/models"
+	"go-backend/services"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+const testUserID = "22222222-2222-2222-2222-222222222222"
+
+func TestAuthRequiredPrincipals(t *testing.T) {
+	useTestDB(t)
+	apiKey := createTestAPIKey(t)
+	serviceAccountToken := issueTestServiceAccountToken(t)
+
+	userToken := signTestToken(t, jwt.MapClaims{
+		"user_id": testUserID,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	expiredUserToken := signTestToken(t, jwt.MapClaims{
+		"user_id": testUserID,
+		"exp":     time.Now().Add(-time.Minute).Unix(),
+	})
+	serviceAccountWithoutSubject := signTestToken(t, jwt.MapClaims{
+		"principal_type": string(models.PrincipalServiceAccount),
+		"account_id":     testAccountID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name          string
+		middleware    []gin.HandlerFunc
+		authorization string
+		wantStatus    int
+		wantType      models.PrincipalType
+	}{
+		{
+			name:          "user token",
+			middleware:    []gin.HandlerFunc{AuthRequired()},
+			authorization: "Bearer " + userToken,
+			wantStatus:    http.StatusOK,
+			wantType:      models.PrincipalUser,
+		},
+		{
+			name:          "service account token",
+			middleware:    []gin.HandlerFunc{AuthRequired()},
+			authorization: "Bearer " + serviceAccountToken,
+			wantStatus:    http.StatusOK,
+			wantType:      models.PrincipalServiceAccount,
+		},
+		{
+			name:          "API key where only tokens are accepted",
+			middleware:    []gin.HandlerFunc{AuthRequired()},
+			authorization: "ApiKey " + apiKey,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "API key where keys are accepted too",
+			middleware:    []gin.HandlerFunc{AuthRequiredOrAPIKey()},
+			authorization: "ApiKey " + apiKey,
+			wantStatus:    http.StatusOK,
+			wantType:      models.PrincipalAPIKey,
+		},
+		{
+			name:          "user token where keys are accepted too",
+			middleware:    []gin.HandlerFunc{AuthRequiredOrAPIKey()},
+			authorization: "Bearer " + userToken,
+			wantStatus:    http.StatusOK,
+			wantType:      models.PrincipalUser,
+		},
+		{
+			name:          "user token on a user route",
+			middleware:    []gin.HandlerFunc{AuthRequired(), UserRequired()},
+			authorization: "Bearer " + userToken,
+			wantStatus:    http.StatusOK,
+			wantType:      models.PrincipalUser,
+		},
+		{
+			name:          "service account token on a user route",
+			middleware:    []gin.HandlerFunc{AuthRequired(), UserRequired()},
+			authorization: "Bearer " + serviceAccountToken,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "API key on a user route",
+			middleware:    []gin.HandlerFunc{AuthRequiredOrAPIKey(), UserRequired()},
+			authorization: "ApiKey " + apiKey,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "expired user token",
+			middleware:    []gin.HandlerFunc{AuthRequired()},
+			authorization: "Bearer " + expiredUserToken,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "service account token without subject",
+			middleware:    []gin.HandlerFunc{AuthRequired()},
+			authorization: "Bearer " + serviceAccountWithoutSubject,
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal models.Principal
+			w := serveTestRequest(tt.authorization, &principal, tt.middleware...)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if principal.Type != tt.wantType {
+				t.Errorf("principal type = %q, want %q", principal.Type, tt.wantType)
+			}
+			if tt.wantType != models.PrincipalUser && principal.AccountID != testAccountID {
+				t.Errorf("machine principal bound to account %q, want %q", principal.AccountID, testAccountID)
+			}
+			if tt.wantType == models.PrincipalUser && principal.ID != testUserID {
+				t.Errorf("user principal = %q, want %q", principal.ID, testUserID)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+func signTestToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.JWTSecretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// issueTestServiceAccountToken gets a token the way service accounts do,
+// through the client credentials grant
+func issueTestServiceAccountToken(t *testing.T) string {
+	t.Helper()
+
+	const secret = "test-client-secret"
+	sum := sha256.Sum256([]byte(secret))
+	serviceAccount := models.ServiceAccount{
+		AccountID:        testAccountID,
+		Name:             "ci",
+		Role:             models.RoleMember,
+		ClientID:         "sa_test",
+		ClientSecretHash: hex.EncodeToString(sum[:]),
+		CreatedByID:      testUserID,
+	}
+	if err := config.DB.Create(&serviceAccount).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := services.NewAccountService().IssueClientCredentialsToken(serviceAccount.ClientID, secret, "")
+	if err != nil {
+		t.Fatalf("IssueClientCredentialsToken: %v", err)
+	}
+	return response.AccessToken
+}
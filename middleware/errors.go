@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"go-backend/apperrors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Media type of error responses, see RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Errors answers the error a handler recorded with c.Error as a problem
+// document. Internal errors are logged with their request and answered
+// without their details.
+func Errors(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil || c.Writer.Written() {
+			return
+		}
+
+		err := apperrors.From(last.Err)
+		if err.Kind == apperrors.KindInternal {
+			logger.Error("Request failed",
+				"request_id", c.GetString(RequestIDKey),
+				"route", c.FullPath(),
+				"error", err.Err,
+			)
+		}
+		writeProblem(c, err)
+	}
+}
+
+// abortWithError stops the handler chain, and Errors answers with err
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
+
+func writeProblem(c *gin.Context, err *apperrors.Error) {
+	status := err.Status()
+	problem := gin.H{}
+	for key, value := range err.Extensions {
+		problem[key] = value
+	}
+	problem["type"] = "about:blank"
+	problem["title"] = http.StatusText(status)
+	problem["status"] = status
+	problem["detail"] = err.Detail
+	problem["code"] = err.Code
+	if requestID := c.GetString(RequestIDKey); requestID != "" {
+		problem["request_id"] = requestID
+	}
+
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(status, problem)
+}
@@ -1,7 +1,8 @@
 package middleware
 
 import (
-	"net/http"
+	"go-backend/apperrors"
+	"go-backend/metrics"
 	"sync"
 	"time"
 
@@ -10,49 +11,81 @@ import (
 
 type RateLimiter struct {
 	sync.Mutex
+	name     string // Tells the limiters apart in metrics
 	attempts map[string][]time.Time
 	limit    int
 	window   time.Duration
 }
 
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+func NewRateLimiter(name string, limit int, window time.Duration) *RateLimiter {
 	return &RateLimiter{
+		name:     name,
 		attempts: make(map[string][]time.Time),
 		limit:    limit,
 		window:   window,
 	}
 }
 
+// RateLimit limits requests per client IP
 func (rl *RateLimiter) RateLimit() gin.HandlerFunc {
+	return rl.RateLimitBy(func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// RateLimitBy limits requests per key, such as the calling principal
+func (rl *RateLimiter) RateLimitBy(key func(c *gin.Context) string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip := c.ClientIP()
+		if !rl.allow(key(c)) {
+			metrics.RateLimitRejections.WithLabelValues(rl.name).Inc()
+			abortWithError(c, apperrors.RateLimited("rate_limited", "Rate limit exceeded. Please try again later."))
+			return
+		}
+		c.Next()
+	}
+}
 
-		rl.Lock()
-		defer rl.Unlock()
+// allow records an attempt for the key unless it already used up the limit.
+// The lock is only held while counting, not while the request is handled.
+func (rl *RateLimiter) allow(key string) bool {
+	rl.Lock()
+	defer rl.Unlock()
 
-		now := time.Now()
-		windowStart := now.Add(-rl.window)
+	now := time.Now()
+	windowStart := now.Add(-rl.window)
 
-		// Remove old attempts
-		var validAttempts []time.Time
-		for _, attempt := range rl.attempts[ip] {
-			if attempt.After(windowStart) {
-				validAttempts = append(validAttempts, attempt)
-			}
-		}
-		rl.attempts[ip] = validAttempts
-
-		// Check if limit exceeded
-		if len(validAttempts) >= rl.limit {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded. Please try again later.",
-			})
-			c.Abort()
-			return
+	// Remove old attempts
+	var validAttempts []time.Time
+	for _, attempt := range rl.attempts[key] {
+		if attempt.After(windowStart) {
+			validAttempts = append(validAttempts, attempt)
 		}
+	}
 
-		// Add current attempt
-		rl.attempts[ip] = append(rl.attempts[ip], now)
-		c.Next()
+	// Check if limit exceeded
+	if len(validAttempts) >= rl.limit {
+		rl.attempts[key] = validAttempts
+		return false
+	}
+
+	// Add current attempt
+	rl.attempts[key] = append(validAttempts, now)
+	return true
+}
+
+// UserKey keys requests by the authenticated user, so a stolen token cannot
+// get around the limit by changing address. It must run after AuthRequired.
+func UserKey(c *gin.Context) string {
+	return "user:" + GetUserID(c)
+}
+
+// OAuthClientKey keys token requests by the client they authenticate as, so
+// machine clients neither share limits with people nor with each other when
+// they come from the same address
+func OAuthClientKey(c *gin.Context) string {
+	clientID, _, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
 	}
+	return "client:" + clientID
 }
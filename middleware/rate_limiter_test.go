@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitByClientID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter("test", 2, time.Minute)
+
+	r := gin.New()
+	r.Use(Errors(slog.Default()))
+	r.POST("/oauth/token", limiter.RateLimitBy(OAuthClientKey), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(clientID string) int {
+		form := url.Values{"grant_type": {"client_credentials"}, "client_id": {clientID}}
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request("ci-runner"); code != http.StatusOK {
+			t.Fatalf("request %d = %d, want 200", i+1, code)
+		}
+	}
+	if code := request("ci-runner"); code != http.StatusTooManyRequests {
+		t.Errorf("request over the limit = %d, want 429", code)
+	}
+	// Same address, another client
+	if code := request("deploy-bot"); code != http.StatusOK {
+		t.Errorf("other client = %d, want its own limit", code)
+	}
+}
+
+func TestRateLimitDoesNotSerializeRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter("test", 10, time.Minute)
+
+	// The first request is held in its handler until the second got through
+	release := make(chan struct{})
+	var once sync.Once
+	r := gin.New()
+	r.POST("/login", limiter.RateLimit(), func(c *gin.Context) {
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			select {
+			case <-release:
+			case <-time.After(5 * time.Second):
+				t.Error("second request was blocked behind the first")
+			}
+		} else {
+			close(release)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/login", nil))
+		}()
+	}
+	wg.Wait()
+}
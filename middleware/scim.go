@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"go-backend/apperrors"
+	"go-backend/models"
+	"go-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMRequired authenticates identity providers calling the SCIM API. They
+// present an account API key as "Authorization: Bearer <key>", and failures
+// are answered in the SCIM error format.
+func SCIMRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, credentials, ok := parseAuthorization(c)
+		if !ok || scheme != "Bearer" {
+			abortSCIM(c, http.StatusUnauthorized, "Bearer authorization header is required")
+			return
+		}
+
+		accountService := services.NewAccountService()
+		principal, err := accountService.AuthenticateAPIKey(credentials, c.ClientIP())
+		if err != nil {
+			appErr := apperrors.From(err)
+			if appErr.Kind == apperrors.KindInternal {
+				slog.Error("SCIM authentication failed", "request_id", c.GetString(RequestIDKey), "error", err)
+			}
+			abortSCIM(c, appErr.Status(), appErr.Detail)
+			return
+		}
+
+		c.Set("principal", *principal)
+		c.Set("principal_type", principal.Type)
+		c.Set("account_id", principal.AccountID)
+		c.Next()
+	}
+}
+
+func abortSCIM(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, models.NewSCIMErrorResponse(status, "", detail))
+	c.Abort()
+}
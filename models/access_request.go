@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type AccessRequestStatus string
+
+const (
+	AccessRequestPending   AccessRequestStatus = "pending"
+	AccessRequestApproved  AccessRequestStatus = "approved"
+	AccessRequestDenied    AccessRequestStatus = "denied"
+	AccessRequestWithdrawn AccessRequestStatus = "withdrawn"
+)
+
+type AccessRequest struct {
+	ID           string              `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID    string              `json:"account_id" gorm:"type:char(36);not null;index"`
+	UserID       string              `json:"user_id" gorm:"type:char(36);not null;index"`
+	Role         MembershipRole      `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+	Status       AccessRequestStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending'"`
+	Message      string              `json:"message,omitempty" gorm:"type:varchar(500)"`
+	ReviewedByID string              `json:"reviewed_by_id,omitempty" gorm:"type:char(36)"`
+	ReviewedAt   *time.Time          `json:"reviewed_at"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+}
+
+func (r *AccessRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
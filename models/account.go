@@ -10,6 +10,7 @@ import (
 type Account struct {
 	ID          string    `json:"id" gorm:"type:char(36);primary_key"`
 	Name        string    `json:"name" gorm:"not null"`
+	Slug        string    `json:"slug" gorm:"type:varchar(64);index"`
 	OwnerID     string    `json:"owner_id" gorm:"type:char(36);not null"`
 	Description string    `json:"description"`
 	CreatedAt   time.Time `json:"created_at"`
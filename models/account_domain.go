@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type DomainJoinMode string
+
+const (
+	JoinModeAuto    DomainJoinMode = "auto"
+	JoinModeRequest DomainJoinMode = "request"
+)
+
+type AccountDomain struct {
+	ID                string         `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID         string         `json:"account_id" gorm:"type:char(36);not null;index"`
+	Domain            string         `json:"domain" gorm:"not null;index"`
+	JoinMode          DomainJoinMode `json:"join_mode" gorm:"type:varchar(20);not null;default:'request'"`
+	Role              MembershipRole `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+	VerificationToken string         `json:"verification_token"`
+	Verified          bool           `json:"verified" gorm:"default:false"`
+	VerifiedAt        *time.Time     `json:"verified_at"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+}
+
+func (d *AccountDomain) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -5,13 +5,14 @@ import (
 )
 
 type CreateAccountRequest struct {
-	Name        string `json:"name" binding:"required"`
+	Name        string `json:"name" binding:"required,account_name"`
 	Description string `json:"description"`
 }
 
 type AccountResponse struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
 	Description string    `json:"description"`
 	Role        string    `json:"role"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -19,9 +20,151 @@ type AccountResponse struct {
 }
 
 type InviteMemberRequest struct {
-	Email string `json:"email" binding:"required,email"`
+	Email string         `json:"email" binding:"required,email"`
+	Role  MembershipRole `json:"role" binding:"omitempty,oneof=admin member"`
 }
 
 type AccountListResponse struct {
 	Accounts []AccountResponse `json:"accounts"`
 }
+
+type AcceptInvitationTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type InvitationResponse struct {
+	ID          string           `json:"id"`
+	AccountID   string           `json:"account_id"`
+	AccountName string           `json:"account_name"`
+	Email       string           `json:"email"`
+	Role        string           `json:"role"`
+	Status      InvitationStatus `json:"status"`
+	InviterID   string           `json:"inviter_id"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+type InvitationListResponse struct {
+	Invitations []InvitationResponse `json:"invitations"`
+}
+
+type CreateJoinLinkRequest struct {
+	Role           MembershipRole `json:"role" binding:"omitempty,oneof=admin member"`
+	MaxUses        int            `json:"max_uses" binding:"min=0"`
+	ExpiresInHours int            `json:"expires_in_hours" binding:"min=0"`
+}
+
+type JoinLinkResponse struct {
+	JoinLink
+	URL string `json:"url"`
+}
+
+type JoinLinkListResponse struct {
+	JoinLinks []JoinLinkResponse `json:"join_links"`
+}
+
+type AddAccountDomainRequest struct {
+	Domain   string         `json:"domain" binding:"required,fqdn"`
+	JoinMode DomainJoinMode `json:"join_mode" binding:"omitempty,oneof=auto request"`
+	Role     MembershipRole `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type AccountDomainResponse struct {
+	AccountDomain
+	TXTRecordName  string `json:"txt_record_name,omitempty"`
+	TXTRecordValue string `json:"txt_record_value,omitempty"`
+}
+
+type AccountDomainListResponse struct {
+	Domains []AccountDomainResponse `json:"domains"`
+}
+
+type DiscoverableAccount struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Domain   string         `json:"domain"`
+	JoinMode DomainJoinMode `json:"join_mode"`
+}
+
+type DiscoverableAccountListResponse struct {
+	Accounts []DiscoverableAccount `json:"accounts"`
+}
+
+type JoinAccountResponse struct {
+	Status        string         `json:"status"` // "joined" or "requested"
+	AccountID     string         `json:"account_id"`
+	Role          MembershipRole `json:"role,omitempty"`
+	AccessRequest *AccessRequest `json:"access_request,omitempty"`
+}
+
+type CreateAccessRequestRequest struct {
+	Account string `json:"account" binding:"required"` // Account slug or ID
+	Message string `json:"message" binding:"max=500"`
+}
+
+type AccessRequestResponse struct {
+	AccessRequest
+	AccountName string `json:"account_name"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+}
+
+type AccessRequestListResponse struct {
+	AccessRequests []AccessRequestResponse `json:"access_requests"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string       `json:"name" binding:"required,max=100"`
+	Scopes        []Permission `json:"scopes" binding:"required,min=1"`
+	AllowedIPs    []string     `json:"allowed_ips"`
+	ExpiresInDays int          `json:"expires_in_days" binding:"min=0"`
+}
+
+type APIKeyResponse struct {
+	APIKey
+	Scopes     []Permission `json:"scopes"`
+	AllowedIPs []string     `json:"allowed_ips"`
+	Key        string       `json:"key,omitempty"` // Only returned once, when the key is created
+}
+
+type APIKeyListResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+}
+
+type CreateServiceAccountRequest struct {
+	Name string         `json:"name" binding:"required,max=100"`
+	Role MembershipRole `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+type UpdateServiceAccountRoleRequest struct {
+	Role MembershipRole `json:"role" binding:"required,oneof=admin member"`
+}
+
+type ServiceAccountResponse struct {
+	ServiceAccount
+	ClientSecret string `json:"client_secret,omitempty"` // Only returned when created or rotated
+}
+
+type ServiceAccountListResponse struct {
+	ServiceAccounts []ServiceAccount `json:"service_accounts"`
+}
+
+// ConfigureSAMLRequest takes either the identity provider's metadata XML or
+// its entity ID, SSO URL and signing certificate
+type ConfigureSAMLRequest struct {
+	MetadataXML       string         `json:"metadata_xml"`
+	EntityID          string         `json:"entity_id"`
+	SSOURL            string         `json:"sso_url" binding:"omitempty,url"`
+	Certificate       string         `json:"certificate"` // PEM or base64 DER
+	DefaultRole       MembershipRole `json:"default_role" binding:"omitempty,oneof=admin member"`
+	EnforceSSO        bool           `json:"enforce_sso"`
+	AllowIDPInitiated bool           `json:"allow_idp_initiated"`
+}
+
+type SAMLConnectionResponse struct {
+	SAMLConnection
+	SPEntityID    string `json:"sp_entity_id"`
+	SPACSURL      string `json:"sp_acs_url"`
+	SPMetadataURL string `json:"sp_metadata_url"`
+	LoginURL      string `json:"login_url"`
+}
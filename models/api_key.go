@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type APIKey struct {
+	ID          string     `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID   string     `json:"account_id" gorm:"type:char(36);not null;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Prefix      string     `json:"prefix" gorm:"type:varchar(32);not null;unique_index"`
+	SecretHash  string     `json:"-" gorm:"type:char(64);not null"`
+	Scopes      string     `json:"-" gorm:"type:varchar(500)"`  // Comma separated permissions
+	AllowedIPs  string     `json:"-" gorm:"type:varchar(1000)"` // Comma separated IPs or CIDRs, empty allows any
+	CreatedByID string     `json:"created_by_id" gorm:"type:char(36);not null"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (k *APIKey) BeforeCreate(tx *gorm.DB) error {
+	if k.ID == "" {
+		k.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (k *APIKey) ScopeList() []Permission {
+	scopes := make([]Permission, 0)
+	for _, scope := range splitList(k.Scopes) {
+		scopes = append(scopes, Permission(scope))
+	}
+	return scopes
+}
+
+func (k *APIKey) AllowedIPList() []string {
+	return splitList(k.AllowedIPs)
+}
+
+func (k *APIKey) Active() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(time.Now())
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type AuditAction string
+
+const (
+	AuditUserRegistered         AuditAction = "user.registered"
+	AuditLoginSucceeded         AuditAction = "login.succeeded"
+	AuditLoginFailed            AuditAction = "login.failed"
+	AuditMFAEnabled             AuditAction = "mfa.enabled"
+	AuditMFAFailed              AuditAction = "mfa.failed"
+	AuditEmailVerified          AuditAction = "email.verified"
+	AuditPhoneVerified          AuditAction = "phone.verified"
+	AuditEmailChanged           AuditAction = "email.changed"
+	AuditPhoneChanged           AuditAction = "phone.changed"
+	AuditContactChangeReverted  AuditAction = "contact_change.reverted"
+	AuditPasswordResetRequested AuditAction = "password.reset_requested"
+	AuditPasswordReset          AuditAction = "password.reset"
+	AuditPasswordChanged        AuditAction = "password.changed"
+	AuditPasswordChangeFailed   AuditAction = "password.change_failed"
+
+	AuditAccountCreated         AuditAction = "account.created"
+	AuditInvitationCreated      AuditAction = "invitation.created"
+	AuditInvitationResent       AuditAction = "invitation.resent"
+	AuditInvitationCancelled    AuditAction = "invitation.cancelled"
+	AuditInvitationAccepted     AuditAction = "invitation.accepted"
+	AuditInvitationDeclined     AuditAction = "invitation.declined"
+	AuditMemberJoined           AuditAction = "member.joined"
+	AuditMemberRoleChanged      AuditAction = "member.role_changed"
+	AuditMemberRemoved          AuditAction = "member.removed"
+	AuditAccessRequestApproved  AuditAction = "access_request.approved"
+	AuditAccessRequestDenied    AuditAction = "access_request.denied"
+	AuditJoinLinkCreated        AuditAction = "join_link.created"
+	AuditJoinLinkRevoked        AuditAction = "join_link.revoked"
+	AuditDomainAdded            AuditAction = "domain.added"
+	AuditDomainVerified         AuditAction = "domain.verified"
+	AuditDomainRemoved          AuditAction = "domain.removed"
+	AuditAPIKeyCreated          AuditAction = "api_key.created"
+	AuditAPIKeyRevoked          AuditAction = "api_key.revoked"
+	AuditServiceAccountCreated  AuditAction = "service_account.created"
+	AuditServiceAccountUpdated  AuditAction = "service_account.role_changed"
+	AuditServiceAccountRotated  AuditAction = "service_account.secret_rotated"
+	AuditServiceAccountDisabled AuditAction = "service_account.disabled"
+	AuditSSOConfigured          AuditAction = "sso.configured"
+	AuditSSORemoved             AuditAction = "sso.removed"
+	AuditWebhookCreated         AuditAction = "webhook.created"
+	AuditWebhookUpdated         AuditAction = "webhook.updated"
+	AuditWebhookDeleted         AuditAction = "webhook.deleted"
+	AuditWebhookSecretRotated   AuditAction = "webhook.secret_rotated"
+	AuditWebhookDisabled        AuditAction = "webhook.disabled"
+)
+
+// AuditEvent records who did what. Events are only ever appended, and
+// removed once they are older than the retention period. The only change
+// made to them is redacting a deleted user's personal data.
+//
+// The events of an account, and those of a user's own security, form a hash
+// chain: each event's hash covers its content and the hash of the event
+// before it, so editing or removing an event breaks every hash after it.
+// Hashes are keyed with a secret kept outside the database, so whoever can
+// edit the table cannot recompute them.
+//
+// Personal data (the actor, the target and where the request came from) is
+// not hashed directly but through keyed references stored next to it, so it
+// can be redacted without breaking the chain. A redaction is signed with the
+// same key, so nobody else can pass off their edits as one.
+type AuditEvent struct {
+	ID         string        `json:"id" gorm:"type:char(36);primary_key"`
+	ChainID    string        `json:"chain_id" gorm:"type:varchar(36);unique_index:idx_audit_chain_sequence"`
+	Sequence   int64         `json:"sequence" gorm:"unique_index:idx_audit_chain_sequence"`
+	PrevHash   string        `json:"prev_hash" gorm:"type:char(64)"`
+	Hash       string        `json:"hash" gorm:"type:char(64)"`
+	AccountID  string        `json:"account_id,omitempty" gorm:"type:char(36);index"` // Empty for events of a user's own security
+	ActorType  PrincipalType `json:"actor_type,omitempty" gorm:"type:varchar(20)"`    // Empty when nobody was authenticated
+	ActorID    string        `json:"actor_id,omitempty" gorm:"type:char(36);index"`
+	Action     AuditAction   `json:"action" gorm:"type:varchar(64);not null;index"`
+	TargetType string        `json:"target_type,omitempty" gorm:"type:varchar(32)"`
+	TargetID   string        `json:"target_id,omitempty" gorm:"type:varchar(64);index"`
+	IP         string        `json:"ip,omitempty" gorm:"type:varchar(45)"`
+	UserAgent  string        `json:"user_agent,omitempty" gorm:"type:varchar(500)"`
+	Metadata   string        `json:"-" gorm:"type:text"` // JSON object
+	CreatedAt  time.Time     `json:"created_at" gorm:"index"`
+
+	ActorRef     string `json:"actor_ref" gorm:"type:char(64)"`
+	TargetRef    string `json:"target_ref" gorm:"type:char(64)"`
+	ClientRef    string `json:"client_ref" gorm:"type:char(64)"`              // Covers the IP and user agent
+	RedactionMAC string `json:"redaction_mac,omitempty" gorm:"type:char(64)"` // Set once personal data was redacted
+}
+
+func (e *AuditEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (e *AuditEvent) BeforeUpdate() error {
+	return errors.New("audit events cannot be changed")
+}
+
+// ComputeHash hashes what the event records together with its place in the
+// chain. Times are hashed in whole seconds, the precision they are stored at.
+// Personal data is covered through its references, set by SetPersonalRefs.
+func (e *AuditEvent) ComputeHash(key []byte) string {
+	return auditMAC(key, e.ChainID, e.Sequence, e.PrevHash,
+		e.AccountID, e.ActorType, e.ActorRef, e.Action, e.TargetType, e.TargetRef,
+		e.ClientRef, e.Metadata, e.CreatedAt.Unix())
+}
+
+// SetPersonalRefs derives the references of the event's personal data. They
+// include the event ID, so the same user is not recognizable across events.
+func (e *AuditEvent) SetPersonalRefs(key []byte) {
+	e.ActorRef = auditMAC(key, "actor", e.ID, e.ActorID)
+	e.TargetRef = auditMAC(key, "target", e.ID, e.TargetID)
+	e.ClientRef = auditMAC(key, "client", e.ID, e.IP, e.UserAgent)
+}
+
+// ComputeRedactionMAC signs the personal data as it is after a redaction
+func (e *AuditEvent) ComputeRedactionMAC(key []byte) string {
+	return auditMAC(key, "redaction", e.ID, e.ActorID, e.TargetID, e.IP, e.UserAgent)
+}
+
+// PersonalDataIntact reports whether the personal data still matches its
+// references, or was replaced by redactedID and blanks in a signed redaction
+func (e *AuditEvent) PersonalDataIntact(key []byte, redactedID string) bool {
+	original := *e
+	original.SetPersonalRefs(key)
+	redacted := e.RedactionMAC != "" &&
+		hmac.Equal([]byte(e.RedactionMAC), []byte(e.ComputeRedactionMAC(key)))
+
+	actorOK := e.ActorRef == original.ActorRef || redacted && e.ActorID == redactedID
+	targetOK := e.TargetRef == original.TargetRef || redacted && e.TargetID == redactedID
+	clientOK := e.ClientRef == original.ClientRef || redacted && e.IP == "" && e.UserAgent == ""
+	return actorOK && targetOK && clientOK
+}
+
+// AuditChain is the head of a hash chain of audit events. It knows where the
+// chain ends, so removing its latest events is noticed too.
+type AuditChain struct {
+	ID            string    `json:"id" gorm:"type:varchar(36);primary_key"` // Account ID, user ID for a user's own events, "system" for events about nobody known
+	Sequence      int64     `json:"sequence"`                               // Of the latest event
+	Hash          string    `json:"hash" gorm:"type:char(64)"`
+	PurgedThrough int64     `json:"purged_through"`           // Events up to this sequence were removed by retention
+	MAC           string    `json:"mac" gorm:"type:char(64)"` // Keyed like event hashes, so the head cannot be moved either
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ComputeMAC covers where the chain ends and where retention cut it off
+func (c *AuditChain) ComputeMAC(key []byte) string {
+	return auditMAC(key, c.ID, c.Sequence, c.Hash, c.PurgedThrough)
+}
+
+func auditMAC(key []byte, fields ...interface{}) string {
+	content, _ := json.Marshal(fields)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuditChainReport is the outcome of verifying one chain
+type AuditChainReport struct {
+	ChainID       string              `json:"chain_id"`
+	Events        int64               `json:"events"`
+	FirstSequence int64               `json:"first_sequence"`
+	LastSequence  int64               `json:"last_sequence"`
+	Problems      []AuditChainProblem `json:"problems"`
+}
+
+func (r *AuditChainReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+type AuditChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	EventID  string `json:"event_id,omitempty"`
+	Problem  string `json:"problem"`
+}
+
+// AuditExportFormat is the file format audit events are archived in
+type AuditExportFormat string
+
+const (
+	AuditExportJSONLines AuditExportFormat = "jsonl"
+	AuditExportCSV       AuditExportFormat = "csv"
+)
+
+// AuditExportQuery selects the events to archive. The zero value selects
+// every event.
+type AuditExportQuery struct {
+	ChainID string
+	Since   time.Time
+	Until   time.Time
+}
+
+// ClientInfo describes where a request came from, for the audit log and the
+// logs
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	RequestID string          // Only logged, it is not part of audit events
+	Context   context.Context // Carries the request's trace to service and database spans
+}
+
+type AuditEventResponse struct {
+	AuditEvent
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+type AuditEventListResponse struct {
+	Events     []AuditEventResponse `json:"events"`
+	NextCursor string               `json:"next_cursor,omitempty"` // Absent on the last page
+}
+
+// AuditLogQuery filters a page of the audit log. Events come newest first.
+type AuditLogQuery struct {
+	Action     string    `form:"action"` // Exact action, or a prefix ending in ".*" such as "login.*"
+	ActorID    string    `form:"actor_id"`
+	TargetType string    `form:"target_type"`
+	TargetID   string    `form:"target_id"`
+	Since      time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until      time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor     string    `form:"cursor"`
+	Limit      int       `form:"limit" binding:"omitempty,min=1,max=200"`
+}
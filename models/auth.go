@@ -1,10 +1,10 @@
 package models
 
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required"`
+	Username string `json:"username" binding:"required,username"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=8"`
-	Phone    string `json:"phone" binding:"required"`
+	Phone    string `json:"phone" binding:"required,phone"`
 }
 
 type LoginRequest struct {
@@ -13,9 +13,10 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token    string `json:"token,omitempty"`
-	NextFlow string `json:"next,omitempty"`
-	PrevFlow string `json:"prev,omitempty"`
+	Token             string `json:"token,omitempty"`
+	NextFlow          string `json:"next,omitempty"`
+	PrevFlow          string `json:"prev,omitempty"`
+	RegistrationToken string `json:"registration_token,omitempty"` // For the SocialRegistration flow
 }
 
 type MFAResponse struct {
@@ -35,3 +36,51 @@ type ResetPasswordRequest struct {
 	Code     string `json:"code" binding:"required"`
 	Password string `json:"password" binding:"required,min=8"`
 }
+
+// OAuthTokenResponse follows RFC 6749 section 5.1
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// OAuthCallbackRequest carries the query parameters the provider redirected
+// back with
+type OAuthCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type AuthorizationURLResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// SocialRegisterRequest completes the user for a provider identity that is
+// not linked to anyone yet
+type SocialRegisterRequest struct {
+	RegistrationToken string `json:"registration_token" binding:"required"`
+	Username          string `json:"username" binding:"required,username"`
+	Phone             string `json:"phone" binding:"required,phone"`
+}
+
+type IdentityListResponse struct {
+	Identities []Identity `json:"identities"`
+}
+
+type SSOExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type ContactKind string
+
+const (
+	ContactEmail ContactKind = "email"
+	ContactPhone ContactKind = "phone"
+)
+
+// ContactChange tracks a requested email or phone change. The old value
+// stays active until the code sent to the new value is confirmed, and a
+// confirmed change can be reverted from the old email address.
+type ContactChange struct {
+	ID              string      `json:"id" gorm:"type:char(36);primary_key"`
+	UserID          string      `json:"user_id" gorm:"type:char(36);not null;index"`
+	Kind            ContactKind `json:"kind" gorm:"type:varchar(10);not null"`
+	OldValue        string      `json:"old_value"`
+	OldVerified     bool        `json:"-"`
+	NewValue        string      `json:"new_value" gorm:"not null"`
+	Code            string      `json:"-"`
+	FailedAttempts  int         `json:"-" gorm:"not null;default:0"`
+	ExpiresAt       time.Time   `json:"expires_at"`
+	ConfirmedAt     *time.Time  `json:"confirmed_at"`
+	RevertToken     string      `json:"-" gorm:"type:varchar(64);index"`
+	RevertExpiresAt *time.Time  `json:"-"`
+	RevertedAt      *time.Time  `json:"reverted_at"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+func (cc *ContactChange) BeforeCreate(tx *gorm.DB) error {
+	if cc.ID == "" {
+		cc.ID = uuid.New().String()
+	}
+	return nil
+}
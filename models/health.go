@@ -0,0 +1,14 @@
+package models
+
+// Readiness check results
+const (
+	HealthOK            = "ok"
+	HealthNotConfigured = "not configured"
+)
+
+// ReadinessResponse lists each check with ok or what is wrong. Status is
+// ready only when every check is ok.
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
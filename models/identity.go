@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// Identity links a user to their subject ID at an external identity provider
+type Identity struct {
+	ID          string     `json:"id" gorm:"type:char(36);primary_key"`
+	UserID      string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	Provider    string     `json:"provider" gorm:"type:varchar(64);not null;unique_index:idx_identity_provider_subject"`
+	Subject     string     `json:"subject" gorm:"type:varchar(255);not null;unique_index:idx_identity_provider_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (i *Identity) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == "" {
+		i.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// OAuthState remembers an authorization request until the provider redirects
+// back. UserID is set when a signed in user is linking a provider.
+type OAuthState struct {
+	ID           string    `json:"id" gorm:"type:char(36);primary_key"`
+	StateHash    string    `json:"-" gorm:"type:char(64);not null;unique_index"`
+	Provider     string    `json:"provider" gorm:"type:varchar(64);not null"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"`
+	UserID       string    `json:"user_id,omitempty" gorm:"type:char(36)"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (s *OAuthState) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -14,14 +14,18 @@ const (
 	StatusAccepted  InvitationStatus = "accepted"
 	StatusDeclined  InvitationStatus = "declined"
 	StatusCancelled InvitationStatus = "cancelled"
+	StatusExpired   InvitationStatus = "expired"
 )
 
 type Invitation struct {
 	ID        string           `json:"id" gorm:"type:char(36);primary_key"`
 	AccountID string           `json:"account_id" gorm:"type:char(36);not null"`
-	UserID    string           `json:"user_id" gorm:"type:char(36);not null"`
+	Email     string           `json:"email" gorm:"not null;index"`
+	UserID    string           `json:"user_id,omitempty" gorm:"type:char(36);index"` // Empty until the invitee has a user
 	InviterID string           `json:"inviter_id" gorm:"type:char(36);not null"`
+	Role      MembershipRole   `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
 	Status    InvitationStatus `json:"status" gorm:"type:varchar(20);not null;default:'pending'"`
+	ExpiresAt *time.Time       `json:"expires_at"`
 	CreatedAt time.Time        `json:"created_at"`
 	UpdatedAt time.Time        `json:"updated_at"`
 }
@@ -32,3 +36,7 @@ func (i *Invitation) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+func (i *Invitation) IsExpired() bool {
+	return i.ExpiresAt != nil && i.ExpiresAt.Before(time.Now())
+}
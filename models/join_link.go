@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type JoinLink struct {
+	ID          string         `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID   string         `json:"account_id" gorm:"type:char(36);not null;index"`
+	Code        string         `json:"code" gorm:"type:varchar(64);not null;unique_index"`
+	CreatedByID string         `json:"created_by_id" gorm:"type:char(36);not null"`
+	Role        MembershipRole `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+	MaxUses     int            `json:"max_uses"` // 0 means unlimited
+	Uses        int            `json:"uses" gorm:"not null;default:0"`
+	ExpiresAt   *time.Time     `json:"expires_at"`
+	RevokedAt   *time.Time     `json:"revoked_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+func (l *JoinLink) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == "" {
+		l.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// Usable reports whether the link can still be used to join its account
+func (l *JoinLink) Usable() bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+	if l.ExpiresAt != nil && l.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+	return l.MaxUses == 0 || l.Uses < l.MaxUses
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// MagicLink is a single use passwordless login emailed to a user. It only
+// works in the browser that asked for it.
+type MagicLink struct {
+	ID          string     `json:"id" gorm:"type:char(36);primary_key"`
+	UserID      string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	BrowserHash string     `json:"-" gorm:"type:char(64);not null"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (l *MagicLink) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == "" {
+		l.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ type MembershipRole string
 
 const (
 	RoleOwner  MembershipRole = "owner"
+	RoleAdmin  MembershipRole = "admin"
 	RoleMember MembershipRole = "member"
 )
 
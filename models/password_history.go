@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// PasswordHistory keeps hashes of passwords a user had before, so the
+// password policy can prevent reusing them
+type PasswordHistory struct {
+	ID        string    `json:"id" gorm:"type:char(36);primary_key"`
+	UserID    string    `json:"user_id" gorm:"type:char(36);not null;index"`
+	Hash      string    `json:"-" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *PasswordHistory) BeforeCreate(tx *gorm.DB) error {
+	if h.ID == "" {
+		h.ID = uuid.New().String()
+	}
+	return nil
+}
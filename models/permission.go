@@ -0,0 +1,80 @@
+package models
+
+type Permission string
+
+const (
+	PermAccountRead           Permission = "account:read"
+	PermInvitationsRead       Permission = "invitations:read"
+	PermInvitationsWrite      Permission = "invitations:write"
+	PermMembersManage         Permission = "members:manage"
+	PermJoinLinksManage       Permission = "join_links:manage"
+	PermDomainsManage         Permission = "domains:manage"
+	PermAccessRequestsRead    Permission = "access_requests:read"
+	PermAccessRequestsWrite   Permission = "access_requests:write"
+	PermAPIKeysManage         Permission = "api_keys:manage"
+	PermServiceAccountsManage Permission = "service_accounts:manage"
+	PermSSOManage             Permission = "sso:manage"
+	PermSCIMProvision         Permission = "scim:provision"
+	PermAuditLogRead          Permission = "audit_log:read"
+	PermWebhooksManage        Permission = "webhooks:manage"
+)
+
+var AllPermissions = []Permission{
+	PermAccountRead,
+	PermInvitationsRead,
+	PermInvitationsWrite,
+	PermMembersManage,
+	PermJoinLinksManage,
+	PermDomainsManage,
+	PermAccessRequestsRead,
+	PermAccessRequestsWrite,
+	PermAPIKeysManage,
+	PermServiceAccountsManage,
+	PermSSOManage,
+	PermSCIMProvision,
+	PermAuditLogRead,
+	PermWebhooksManage,
+}
+
+// RolePermissions lists what each membership role is allowed to do in its account
+var RolePermissions = map[MembershipRole][]Permission{
+	RoleOwner: AllPermissions,
+	// Only owners decide how members sign in and who provisions them
+	RoleAdmin: {
+		PermAccountRead,
+		PermInvitationsRead,
+		PermInvitationsWrite,
+		PermMembersManage,
+		PermJoinLinksManage,
+		PermDomainsManage,
+		PermAccessRequestsRead,
+		PermAccessRequestsWrite,
+		PermAPIKeysManage,
+		PermServiceAccountsManage,
+		PermAuditLogRead,
+		PermWebhooksManage,
+	},
+	RoleMember: {
+		PermAccountRead,
+		PermInvitationsWrite,
+	},
+}
+
+// Can reports whether the role grants the permission
+func (r MembershipRole) Can(permission Permission) bool {
+	for _, p := range RolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidPermission(permission Permission) bool {
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
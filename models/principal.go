@@ -0,0 +1,36 @@
+package models
+
+type PrincipalType string
+
+const (
+	PrincipalUser           PrincipalType = "user"
+	PrincipalAPIKey         PrincipalType = "api_key"
+	PrincipalServiceAccount PrincipalType = "service_account"
+)
+
+// Principal is whoever an authenticated request acts on behalf of. Users act
+// through their memberships, machine principals are bound to one account and
+// limited to their scopes.
+type Principal struct {
+	Type      PrincipalType
+	ID        string
+	AccountID string
+	Scopes    []Permission
+}
+
+func UserPrincipal(userID string) Principal {
+	return Principal{Type: PrincipalUser, ID: userID}
+}
+
+func (p Principal) IsUser() bool {
+	return p.Type == PrincipalUser
+}
+
+func (p Principal) HasScope(permission Permission) bool {
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// SAMLConnection is an account's SAML identity provider. Users whose email
+// domain the account has verified can sign in through it.
+type SAMLConnection struct {
+	ID                string         `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID         string         `json:"account_id" gorm:"type:char(36);not null;unique_index"`
+	IDPEntityID       string         `json:"idp_entity_id" gorm:"not null"`
+	IDPSSOURL         string         `json:"idp_sso_url" gorm:"type:varchar(1000);not null"`
+	IDPCertificate    string         `json:"idp_certificate" gorm:"type:text;not null"` // Base64 DER, as found in metadata
+	IDPMetadataXML    string         `json:"-" gorm:"type:mediumtext"`
+	DefaultRole       MembershipRole `json:"default_role" gorm:"type:varchar(20);not null;default:'member'"`
+	EnforceSSO        bool           `json:"enforce_sso" gorm:"default:false"`
+	AllowIDPInitiated bool           `json:"allow_idp_initiated" gorm:"default:false"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+}
+
+func (c *SAMLConnection) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// SAMLRequest remembers an authentication request sent to an identity
+// provider, found again through the RelayState it echoes back
+type SAMLRequest struct {
+	ID             string    `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID      string    `json:"account_id" gorm:"type:char(36);not null"`
+	RequestID      string    `json:"request_id" gorm:"type:varchar(64);not null"`
+	RelayStateHash string    `json:"-" gorm:"type:char(64);not null;unique_index"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (r *SAMLRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// SSOTicket hands a completed SSO login from the browser redirect to the
+// client, which exchanges it once for a token
+type SSOTicket struct {
+	ID        string     `json:"id" gorm:"type:char(36);primary_key"`
+	UserID    string     `json:"user_id" gorm:"type:char(36);not null"`
+	CodeHash  string     `json:"-" gorm:"type:char(64);not null;unique_index"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (t *SSOTicket) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// ServiceAccount is a machine identity belonging to an account. It holds a
+// role like a Membership and exchanges its client credentials for
+// short-lived access tokens.
+type ServiceAccount struct {
+	ID               string         `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID        string         `json:"account_id" gorm:"type:char(36);not null;index"`
+	Name             string         `json:"name" gorm:"not null"`
+	Role             MembershipRole `json:"role" gorm:"type:varchar(20);not null;default:'member'"`
+	ClientID         string         `json:"client_id" gorm:"type:varchar(64);not null;unique_index"`
+	ClientSecretHash string         `json:"-" gorm:"type:char(64);not null"`
+	CreatedByID      string         `json:"created_by_id" gorm:"type:char(36);not null"`
+	DisabledAt       *time.Time     `json:"disabled_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+}
+
+func (sa *ServiceAccount) BeforeCreate(tx *gorm.DB) error {
+	if sa.ID == "" {
+		sa.ID = uuid.New().String()
+	}
+	return nil
+}
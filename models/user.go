@@ -12,7 +12,11 @@ type User struct {
 	Username            string     `json:"username" gorm:"unique" binding:"required"`
 	Email               string     `json:"email" gorm:"unique" binding:"required"`
 	Password            string     `json:"-" gorm:"not null" binding:"required,min=8"`
-	Phone               string     `json:"phone" gorm:"unique" binding:"required"`
+	Phone               *string    `json:"phone" gorm:"unique"` // Nil for users provisioned without one
+	DisplayName         string     `json:"display_name"`
+	AvatarURL           string     `json:"avatar_url"`
+	Locale              string     `json:"locale" gorm:"type:varchar(35)"`
+	Timezone            string     `json:"timezone" gorm:"type:varchar(64)"`
 	EmailVerified       bool       `json:"email_verified" gorm:"default:false"`
 	PhoneVerified       bool       `json:"phone_verified" gorm:"default:false"`
 	MFAEnabled          bool       `json:"mfa_enabled" gorm:"default:false"`
@@ -30,3 +34,11 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// PhoneNumber returns the phone number, or an empty string when there is none
+func (u *User) PhoneNumber() string {
+	if u.Phone == nil {
+		return ""
+	}
+	return *u.Phone
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type OwnedAccountAction string
+
+const (
+	OwnedAccountTransfer OwnedAccountAction = "transfer"
+	OwnedAccountDelete   OwnedAccountAction = "delete"
+)
+
+// UserDeletion is a scheduled deletion of a user. Nothing is removed until
+// PurgeAt, so the user can still cancel during the grace period.
+type UserDeletion struct {
+	ID             string     `json:"id" gorm:"type:char(36);primary_key"`
+	UserID         string     `json:"user_id" gorm:"type:char(36);not null;index"`
+	AccountActions string     `json:"-" gorm:"type:text"` // JSON encoded []OwnedAccountDisposition
+	PurgeAt        time.Time  `json:"purge_at" gorm:"index"`
+	CancelledAt    *time.Time `json:"cancelled_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (d *UserDeletion) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// UserExport is everything stored about a user, as returned by the data
+// export. Secrets such as password hashes and codes are never included.
+type UserExport struct {
+	ExportedAt          time.Time            `json:"exported_at"`
+	User                User                 `json:"user"`
+	Memberships         []Membership         `json:"memberships"`
+	OwnedAccounts       []Account            `json:"owned_accounts"`
+	InvitationsReceived []Invitation         `json:"invitations_received"`
+	InvitationsSent     []Invitation         `json:"invitations_sent"`
+	AccessRequests      []AccessRequest      `json:"access_requests"`
+	ContactChanges      []ContactChange      `json:"contact_changes"`
+	APIKeysCreated      []APIKey             `json:"api_keys_created"`
+	Identities          []Identity           `json:"identities"`
+	SecurityEvents      []AuditEventResponse `json:"security_events"`
+	PendingDeletion     *UserDeletion        `json:"pending_deletion"`
+}
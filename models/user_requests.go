@@ -0,0 +1,52 @@
+package models
+
+// UpdateProfileRequest only changes the fields that are present
+type UpdateProfileRequest struct {
+	Username    *string `json:"username" binding:"omitempty,username"`
+	DisplayName *string `json:"display_name" binding:"omitempty,max=100"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitempty,url,max=500"`
+	Locale      *string `json:"locale" binding:"omitempty,bcp47_language_tag"`
+	Timezone    *string `json:"timezone" binding:"omitempty,timezone"`
+}
+
+// PublicUserResponse is what other members of a shared account can see
+type PublicUserResponse struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Locale      string `json:"locale"`
+	Timezone    string `json:"timezone"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePhoneRequest struct {
+	NewPhone string `json:"new_phone" binding:"required,phone"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ConfirmContactChangeRequest struct {
+	Code string `json:"code" binding:"required,len=6"`
+}
+
+type RevertContactChangeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// DeleteUserRequest says what happens to each account the user owns. Every
+// owned account must be listed, otherwise the deletion is refused.
+type DeleteUserRequest struct {
+	Password      string                    `json:"password"` // Not needed right after a login of any kind
+	OwnedAccounts []OwnedAccountDisposition `json:"owned_accounts" binding:"dive"`
+}
+
+type OwnedAccountDisposition struct {
+	AccountID  string             `json:"account_id" binding:"required"`
+	Action     OwnedAccountAction `json:"action" binding:"required,oneof=transfer delete"`
+	NewOwnerID string             `json:"new_owner_id,omitempty"` // Required for transfer
+}
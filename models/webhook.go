@@ -0,0 +1,163 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+type WebhookEventType string
+
+const (
+	WebhookAccountCreated     WebhookEventType = "account.created"
+	WebhookInvitationCreated  WebhookEventType = "invitation.created"
+	WebhookInvitationAccepted WebhookEventType = "invitation.accepted"
+	WebhookInvitationDeclined WebhookEventType = "invitation.declined"
+	WebhookMemberJoined       WebhookEventType = "member.joined"
+)
+
+var AllWebhookEventTypes = []WebhookEventType{
+	WebhookAccountCreated,
+	WebhookInvitationCreated,
+	WebhookInvitationAccepted,
+	WebhookInvitationDeclined,
+	WebhookMemberJoined,
+}
+
+func IsValidWebhookEventType(eventType WebhookEventType) bool {
+	for _, known := range AllWebhookEventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEndpoint receives the account's events it subscribed to
+type WebhookEndpoint struct {
+	ID                  string     `json:"id" gorm:"type:char(36);primary_key"`
+	AccountID           string     `json:"account_id" gorm:"type:char(36);not null;index"`
+	URL                 string     `json:"url" gorm:"type:varchar(2048);not null"`
+	Description         string     `json:"description" gorm:"type:varchar(255)"`
+	EventTypes          string     `json:"-" gorm:"type:varchar(500);not null"` // Comma separated
+	Secret              string     `json:"-" gorm:"type:varchar(64);not null"`  // Signs payloads, so it cannot be hashed
+	ConsecutiveFailures int        `json:"consecutive_failures"`                // Deliveries that failed every attempt since the last success
+	DisabledAt          *time.Time `json:"disabled_at"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedByID         string     `json:"created_by_id" gorm:"type:char(36);not null"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+func (e *WebhookEndpoint) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (e *WebhookEndpoint) EventTypeList() []WebhookEventType {
+	eventTypes := make([]WebhookEventType, 0)
+	for _, eventType := range splitList(e.EventTypes) {
+		eventTypes = append(eventTypes, WebhookEventType(eventType))
+	}
+	return eventTypes
+}
+
+func (e *WebhookEndpoint) Subscribes(eventType WebhookEventType) bool {
+	for _, subscribed := range e.EventTypeList() {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event on its way to one endpoint, kept as the
+// delivery log once it arrived or gave up
+type WebhookDelivery struct {
+	ID             string                `json:"id" gorm:"type:char(36);primary_key"`
+	EndpointID     string                `json:"endpoint_id" gorm:"type:char(36);not null;index"`
+	AccountID      string                `json:"account_id" gorm:"type:char(36);not null;index"`
+	EventID        string                `json:"event_id" gorm:"type:char(36);not null"` // The same for every delivery of an event, including replays
+	EventType      WebhookEventType      `json:"event_type" gorm:"type:varchar(64);not null"`
+	Payload        string                `json:"-" gorm:"type:text;not null"`
+	Status         WebhookDeliveryStatus `json:"status" gorm:"type:varchar(20);not null;index"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at" gorm:"index"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at"`
+	ResponseStatus int                   `json:"response_status,omitempty"`
+	ResponseBody   string                `json:"response_body,omitempty" gorm:"type:text"` // Truncated
+	Error          string                `json:"error,omitempty" gorm:"type:varchar(500)"`
+	ReplayOfID     string                `json:"replay_of_id,omitempty" gorm:"type:char(36)"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// WebhookPayload is the body POSTed to endpoints
+type WebhookPayload struct {
+	ID        string           `json:"id"`
+	Type      WebhookEventType `json:"type"`
+	AccountID string           `json:"account_id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      interface{}      `json:"data"`
+}
+
+// WebhookMemberData is the data of member.joined events
+type WebhookMemberData struct {
+	UserID string         `json:"user_id"`
+	Role   MembershipRole `json:"role"`
+	Via    string         `json:"via"` // invitation, join_link, domain, access_request or scim
+}
+
+type CreateWebhookEndpointRequest struct {
+	URL         string             `json:"url" binding:"required,url,max=2048"`
+	Description string             `json:"description" binding:"max=255"`
+	EventTypes  []WebhookEventType `json:"event_types" binding:"required,min=1"`
+}
+
+// UpdateWebhookEndpointRequest changes the fields that are present. Enabling
+// a disabled endpoint resets its failure count.
+type UpdateWebhookEndpointRequest struct {
+	URL         *string            `json:"url" binding:"omitempty,url,max=2048"`
+	Description *string            `json:"description" binding:"omitempty,max=255"`
+	EventTypes  []WebhookEventType `json:"event_types" binding:"omitempty,min=1"`
+	Enabled     *bool              `json:"enabled"`
+}
+
+type WebhookEndpointResponse struct {
+	WebhookEndpoint
+	EventTypes []WebhookEventType `json:"event_types"`
+	Enabled    bool               `json:"enabled"`
+	Secret     string             `json:"secret,omitempty"` // Only returned when the endpoint is created or its secret rotated
+}
+
+type WebhookEndpointListResponse struct {
+	Endpoints []WebhookEndpointResponse `json:"endpoints"`
+}
+
+type WebhookDeliveryResponse struct {
+	WebhookDelivery
+	Payload json.RawMessage `json:"payload"`
+}
+
+type WebhookDeliveryListResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+}
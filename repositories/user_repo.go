@@ -27,6 +27,14 @@ func GetUserByID(id string) (models.User, error) {
 	return user, nil
 }
 
+func GetUserByUsername(username string) (models.User, error) {
+	var user models.User
+	if err := config.DB.First(&user, "username = ?", username).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
 func UpdateUser(id string, user models.User) (models.User, error) {
 	var existingUser models.User
 	uid, err := uuid.Parse(id)
@@ -38,8 +46,12 @@ func UpdateUser(id string, user models.User) (models.User, error) {
 		return existingUser, err
 	}
 
+	// Email and phone only change through the verified change flows
 	existingUser.Username = user.Username
-	existingUser.Email = user.Email
+	existingUser.DisplayName = user.DisplayName
+	existingUser.AvatarURL = user.AvatarURL
+	existingUser.Locale = user.Locale
+	existingUser.Timezone = user.Timezone
 
 	if err := config.DB.Save(&existingUser).Error; err != nil {
 		return existingUser, err
@@ -58,3 +70,17 @@ func DeleteUser(id string) error {
 	}
 	return nil
 }
+
+// UsersShareAccount reports whether both users are members of at least one
+// common account
+func UsersShareAccount(userID, otherUserID string) (bool, error) {
+	var count int
+	err := config.DB.Table("memberships AS a").
+		Joins("JOIN memberships AS b ON a.account_id = b.account_id").
+		Where("a.user_id = ? AND b.user_id = ?", userID, otherUserID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/events"
+	"go-backend/models"
+	"time"
+)
+
+// RequestAccess files a request to join an account identified by its slug
+// or ID. Owners and admins of the account are notified by email.
+func (s *AccountService) RequestAccess(userID string, req models.CreateAccessRequestRequest) (*models.AccessRequest, error) {
+	defer s.trace("AccountService.RequestAccess")()
+	var account models.Account
+	if err := s.db.Where("slug = ? OR id = ?", req.Account, req.Account).First(&account).Error; err != nil {
+		return nil, ErrAccountNotFound
+	}
+
+	if s.isMember(account.ID, userID) {
+		return nil, ErrAlreadyMember
+	}
+
+	return s.createAccessRequest(account.ID, userID, models.RoleMember, req.Message)
+}
+
+// ListUserAccessRequests returns the access requests filed by the user
+func (s *AccountService) ListUserAccessRequests(userID string) (*models.AccessRequestListResponse, error) {
+	defer s.trace("AccountService.ListUserAccessRequests")()
+	var accessRequests []models.AccessRequest
+	if err := s.db.Where("user_id = ?", userID).Order("created_at desc").Find(&accessRequests).Error; err != nil {
+		return nil, err
+	}
+
+	return s.accessRequestList(accessRequests), nil
+}
+
+// WithdrawAccessRequest lets the requester take back a pending request
+func (s *AccountService) WithdrawAccessRequest(requestID, userID string) error {
+	defer s.trace("AccountService.WithdrawAccessRequest")()
+	var accessRequest models.AccessRequest
+	if err := s.db.Where("id = ? AND user_id = ? AND status = ?",
+		requestID, userID, models.AccessRequestPending).First(&accessRequest).Error; err != nil {
+		return ErrAccessRequestNotFound
+	}
+
+	accessRequest.Status = models.AccessRequestWithdrawn
+	return s.db.Save(&accessRequest).Error
+}
+
+// ListAccessRequests returns the account's access requests, pending ones
+// unless another status is given
+func (s *AccountService) ListAccessRequests(accountID string, principal models.Principal, status models.AccessRequestStatus) (*models.AccessRequestListResponse, error) {
+	defer s.trace("AccountService.ListAccessRequests")()
+	if err := s.authorize(accountID, principal, models.PermAccessRequestsRead); err != nil {
+		return nil, err
+	}
+
+	if status == "" {
+		status = models.AccessRequestPending
+	}
+
+	var accessRequests []models.AccessRequest
+	if err := s.db.Where("account_id = ? AND status = ?", accountID, status).
+		Order("created_at").Find(&accessRequests).Error; err != nil {
+		return nil, err
+	}
+
+	return s.accessRequestList(accessRequests), nil
+}
+
+func (s *AccountService) ApproveAccessRequest(accountID, requestID string, principal models.Principal) error {
+	defer s.trace("AccountService.ApproveAccessRequest")()
+	if err := s.authorize(accountID, principal, models.PermAccessRequestsWrite); err != nil {
+		return err
+	}
+
+	accessRequest, err := s.findPendingAccessRequest(accountID, requestID)
+	if err != nil {
+		return err
+	}
+
+	if s.isMember(accountID, accessRequest.UserID) {
+		return ErrAlreadyMember
+	}
+
+	tx := s.db.Begin()
+
+	now := time.Now()
+	accessRequest.Status = models.AccessRequestApproved
+	accessRequest.ReviewedByID = principal.ID
+	accessRequest.ReviewedAt = &now
+	if err := tx.Save(accessRequest).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := addMember(tx, accountID, accessRequest.UserID, accessRequest.Role); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditAccessRequestApproved, "access_request", accessRequest.ID, nil)
+	s.memberJoined(accountID, principal, accessRequest.UserID, accessRequest.Role, "access_request")
+	publish(s.db, events.AccessRequestReviewed{AccessRequest: *accessRequest})
+	return nil
+}
+
+func (s *AccountService) DenyAccessRequest(accountID, requestID string, principal models.Principal) error {
+	defer s.trace("AccountService.DenyAccessRequest")()
+	if err := s.authorize(accountID, principal, models.PermAccessRequestsWrite); err != nil {
+		return err
+	}
+
+	accessRequest, err := s.findPendingAccessRequest(accountID, requestID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	accessRequest.Status = models.AccessRequestDenied
+	accessRequest.ReviewedByID = principal.ID
+	accessRequest.ReviewedAt = &now
+	if err := s.db.Save(accessRequest).Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditAccessRequestDenied, "access_request", accessRequest.ID, nil)
+	publish(s.db, events.AccessRequestReviewed{AccessRequest: *accessRequest})
+	return nil
+}
+
+func (s *AccountService) createAccessRequest(accountID, userID string, role models.MembershipRole, message string) (*models.AccessRequest, error) {
+	var existingRequest models.AccessRequest
+	if err := s.db.Where("account_id = ? AND user_id = ? AND status = ?",
+		accountID, userID, models.AccessRequestPending).First(&existingRequest).Error; err == nil {
+		return nil, apperrors.Conflict("access_already_requested", "access already requested")
+	}
+
+	accessRequest := models.AccessRequest{
+		AccountID: accountID,
+		UserID:    userID,
+		Role:      role,
+		Status:    models.AccessRequestPending,
+		Message:   message,
+	}
+	if err := s.db.Create(&accessRequest).Error; err != nil {
+		return nil, err
+	}
+
+	publish(s.db, events.AccessRequestCreated{AccessRequest: accessRequest})
+	return &accessRequest, nil
+}
+
+func (s *AccountService) findPendingAccessRequest(accountID, requestID string) (*models.AccessRequest, error) {
+	var accessRequest models.AccessRequest
+	if err := s.db.Where("id = ? AND account_id = ? AND status = ?",
+		requestID, accountID, models.AccessRequestPending).First(&accessRequest).Error; err != nil {
+		return nil, ErrAccessRequestNotFound
+	}
+	return &accessRequest, nil
+}
+
+func (s *AccountService) accessRequestList(accessRequests []models.AccessRequest) *models.AccessRequestListResponse {
+	response := &models.AccessRequestListResponse{AccessRequests: []models.AccessRequestResponse{}}
+
+	for _, accessRequest := range accessRequests {
+		item := models.AccessRequestResponse{AccessRequest: accessRequest}
+
+		var account models.Account
+		if err := s.db.First(&account, "id = ?", accessRequest.AccountID).Error; err == nil {
+			item.AccountName = account.Name
+		}
+
+		var user models.User
+		if err := s.db.First(&user, "id = ?", accessRequest.UserID).Error; err == nil {
+			item.Username = user.Username
+			item.Email = user.Email
+		}
+
+		response.AccessRequests = append(response.AccessRequests, item)
+	}
+
+	return response
+}
+
+// notifyManagers emails the account's owners and admins about a new request
+func (s *AccountService) notifyManagers(accessRequest *models.AccessRequest) {
+	var account models.Account
+	var requester models.User
+	if err := s.db.First(&account, "id = ?", accessRequest.AccountID).Error; err != nil {
+		return
+	}
+	if err := s.db.First(&requester, "id = ?", accessRequest.UserID).Error; err != nil {
+		return
+	}
+
+	var managers []models.User
+	if err := s.db.Table("users").
+		Joins("JOIN memberships ON memberships.user_id = users.id").
+		Where("memberships.account_id = ? AND memberships.role IN (?)",
+			account.ID, []models.MembershipRole{models.RoleOwner, models.RoleAdmin}).
+		Find(&managers).Error; err != nil {
+		s.log.Error("Failed to load account managers", "account_id", accessRequest.AccountID, "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/accounts/%s/access-requests", appBaseURL(), account.ID)
+	body := fmt.Sprintf("%s (%s) requested access to %s.\n\n", requester.Username, requester.Email, account.Name)
+	if accessRequest.Message != "" {
+		body += fmt.Sprintf("Message: %s\n\n", accessRequest.Message)
+	}
+	body += fmt.Sprintf("Review the request here:\n\n%s\n", link)
+
+	for _, manager := range managers {
+		if err := sendNotificationEmail(context.Background(), manager.Email, "New access request for "+account.Name, body); err != nil {
+			s.log.Error("Failed to send access request email", "access_request_id", accessRequest.ID, "error", err)
+		}
+	}
+}
+
+// notifyRequester emails the requester once their request has been reviewed
+func (s *AccountService) notifyRequester(accessRequest *models.AccessRequest) {
+	var account models.Account
+	var requester models.User
+	if err := s.db.First(&account, "id = ?", accessRequest.AccountID).Error; err != nil {
+		return
+	}
+	if err := s.db.First(&requester, "id = ?", accessRequest.UserID).Error; err != nil {
+		return
+	}
+
+	subject := "Your request to join " + account.Name + " was denied"
+	body := fmt.Sprintf("Your request to join %s was denied.\n", account.Name)
+	if accessRequest.Status == models.AccessRequestApproved {
+		subject = "You have joined " + account.Name
+		body = fmt.Sprintf("Your request to join %s was approved. You are now a member.\n", account.Name)
+	}
+
+	if err := sendNotificationEmail(context.Background(), requester.Email, subject, body); err != nil {
+		s.log.Error("Failed to send access request email", "access_request_id", accessRequest.ID, "error", err)
+	}
+}
@@ -1,29 +1,60 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"go-backend/apperrors"
 	"go-backend/config"
+	"go-backend/events"
+	"go-backend/metrics"
 	"go-backend/models"
+	"go-backend/tracing"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AccountService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	client models.ClientInfo
+	log    *slog.Logger
 }
 
 func NewAccountService() *AccountService {
-	return &AccountService{db: config.DB}
+	return &AccountService{db: config.DB, log: slog.Default()}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *AccountService) WithClient(client models.ClientInfo) *AccountService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
 }
 
 func (s *AccountService) CreateAccount(userID string, req models.CreateAccountRequest) (*models.AccountResponse, error) {
+	defer s.trace("AccountService.CreateAccount")()
+	slug, err := s.uniqueSlug(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
 	account := models.Account{
 		Name:        req.Name,
+		Slug:        slug,
 		Description: req.Description,
 		OwnerID:     userID,
 	}
 
-	tx := s.db.Begin()
+	tx := beginTx(s.db)
 
 	if err := tx.Create(&account).Error; err != nil {
 		tx.Rollback()
@@ -42,11 +73,17 @@ func (s *AccountService) CreateAccount(userID string, req models.CreateAccountRe
 		return nil, err
 	}
 
-	tx.Commit()
+	publish(tx, events.AccountCreated{Account: account})
+	if err := commitTx(tx); err != nil {
+		return nil, err
+	}
+
+	s.audit(account.ID, models.UserPrincipal(userID), models.AuditAccountCreated, "account", account.ID, nil)
 
 	return &models.AccountResponse{
 		ID:          account.ID,
 		Name:        account.Name,
+		Slug:        account.Slug,
 		Description: account.Description,
 		Role:        string(models.RoleOwner),
 		CreatedAt:   account.CreatedAt,
@@ -55,6 +92,7 @@ func (s *AccountService) CreateAccount(userID string, req models.CreateAccountRe
 }
 
 func (s *AccountService) ListUserAccounts(userID string) (*models.AccountListResponse, error) {
+	defer s.trace("AccountService.ListUserAccounts")()
 	var memberships []models.Membership
 	if err := s.db.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
 		return nil, err
@@ -70,6 +108,7 @@ func (s *AccountService) ListUserAccounts(userID string) (*models.AccountListRes
 		accounts = append(accounts, models.AccountResponse{
 			ID:          account.ID,
 			Name:        account.Name,
+			Slug:        account.Slug,
 			Description: account.Description,
 			Role:        string(membership.Role),
 			CreatedAt:   account.CreatedAt,
@@ -80,79 +119,522 @@ func (s *AccountService) ListUserAccounts(userID string) (*models.AccountListRes
 	return &models.AccountListResponse{Accounts: accounts}, nil
 }
 
-func (s *AccountService) InviteMember(accountID, inviterID, email string) error {
-	var user models.User
-	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
-		return errors.New("user not found")
+func (s *AccountService) InviteMember(accountID string, principal models.Principal, req models.InviteMemberRequest) (*models.Invitation, error) {
+	defer s.trace("AccountService.InviteMember")()
+	email := normalizeEmail(req.Email)
+	role := req.Role
+	if role == "" {
+		role = models.RoleMember
 	}
 
 	// Check if inviter has permission
-	var membership models.Membership
-	if err := s.db.Where("account_id = ? AND user_id = ?", accountID, inviterID).First(&membership).Error; err != nil {
-		return errors.New("not authorized to invite members")
+	if !s.can(accountID, principal, models.PermInvitationsWrite) {
+		return nil, apperrors.Forbidden("not_authorized", "not authorized to invite members")
+	}
+
+	if role != models.RoleMember && !s.can(accountID, principal, models.PermMembersManage) {
+		return nil, apperrors.Forbidden("not_authorized", "not authorized to invite with this role")
 	}
 
+	var account models.Account
+	if err := s.db.First(&account, "id = ?", accountID).Error; err != nil {
+		return nil, ErrAccountNotFound
+	}
+
+	// The invitee may not have registered or verified their email yet, in
+	// which case the invitation is bound to their user once they have
+	var user models.User
+	userFound := s.db.Where("email = ?", email).First(&user).Error == nil
+
 	// Check if already a member
-	var existingMembership models.Membership
-	if err := s.db.Where("account_id = ? AND user_id = ?", accountID, user.ID).First(&existingMembership).Error; err == nil {
-		return errors.New("user is already a member")
+	if userFound {
+		var existingMembership models.Membership
+		if err := s.db.Where("account_id = ? AND user_id = ?", accountID, user.ID).First(&existingMembership).Error; err == nil {
+			return nil, ErrAlreadyMember
+		}
 	}
 
-	// Check if user is already invited
+	// Check if email is already invited
 	var existingInvitation models.Invitation
-	if err := s.db.Where("account_id = ? AND user_id = ?", accountID, user.ID).First(&existingInvitation).Error; err == nil {
-		if existingInvitation.Status == models.StatusPending {
-			return errors.New("user is already invited")
+	if err := s.db.Where("account_id = ? AND email = ? AND status = ?",
+		accountID, email, models.StatusPending).First(&existingInvitation).Error; err == nil {
+		if !existingInvitation.IsExpired() {
+			return nil, ErrAlreadyInvited
+		}
+
+		existingInvitation.Status = models.StatusExpired
+		if err := s.db.Save(&existingInvitation).Error; err != nil {
+			return nil, err
 		}
 	}
 
+	expiresAt := time.Now().Add(config.InvitationTTL())
 	invitation := models.Invitation{
 		AccountID: accountID,
-		UserID:    user.ID,
-		InviterID: inviterID,
+		Email:     email,
+		InviterID: principal.ID,
+		Role:      role,
 		Status:    models.StatusPending,
+		ExpiresAt: &expiresAt,
+	}
+	if userFound && user.EmailVerified {
+		invitation.UserID = user.ID
+	}
+
+	if err := s.db.Create(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditInvitationCreated, "invitation", invitation.ID, map[string]interface{}{
+		"email": email,
+		"role":  role,
+	})
+	publish(s.db, events.InvitationCreated{Invitation: invitation, AccountName: account.Name})
+	metrics.Invitations.WithLabelValues(string(models.StatusPending)).Inc()
+
+	return &invitation, nil
+}
+
+// ListUserInvitations returns the pending invitations addressed to the user
+func (s *AccountService) ListUserInvitations(userID string) (*models.InvitationListResponse, error) {
+	defer s.trace("AccountService.ListUserInvitations")()
+	var invitations []models.Invitation
+	if err := s.db.Where("user_id = ? AND status = ? AND (expires_at IS NULL OR expires_at > ?)",
+		userID, models.StatusPending, time.Now()).Order("created_at desc").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+
+	return s.invitationList(invitations), nil
+}
+
+// ListAccountInvitations returns the invitations of an account, optionally
+// filtered by status
+func (s *AccountService) ListAccountInvitations(accountID string, principal models.Principal, status models.InvitationStatus) (*models.InvitationListResponse, error) {
+	defer s.trace("AccountService.ListAccountInvitations")()
+	if err := s.authorize(accountID, principal, models.PermInvitationsRead); err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("account_id = ?", accountID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invitations []models.Invitation
+	if err := query.Order("created_at desc").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+
+	return s.invitationList(invitations), nil
+}
+
+// CancelInvitation withdraws a pending invitation. Principals allowed to
+// manage members can cancel any invitation, others only the ones they sent.
+func (s *AccountService) CancelInvitation(accountID, invitationID string, principal models.Principal) error {
+	defer s.trace("AccountService.CancelInvitation")()
+	invitation, err := s.findManageableInvitation(accountID, invitationID, principal)
+	if err != nil {
+		return err
+	}
+
+	if invitation.Status != models.StatusPending {
+		return ErrInvitationNotFound
+	}
+
+	invitation.Status = models.StatusCancelled
+	if err := s.db.Save(invitation).Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditInvitationCancelled, "invitation", invitation.ID, nil)
+	metrics.Invitations.WithLabelValues(string(models.StatusCancelled)).Inc()
+	return nil
+}
+
+// ResendInvitation extends the expiry of a pending or expired invitation
+// and emails a fresh link
+func (s *AccountService) ResendInvitation(accountID, invitationID string, principal models.Principal) (*models.Invitation, error) {
+	defer s.trace("AccountService.ResendInvitation")()
+	invitation, err := s.findManageableInvitation(accountID, invitationID, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.Status != models.StatusPending && invitation.Status != models.StatusExpired {
+		return nil, ErrInvitationNotFound
+	}
+
+	if invitation.Status == models.StatusExpired {
+		var otherInvitation models.Invitation
+		if err := s.db.Where("account_id = ? AND email = ? AND status = ? AND id <> ?",
+			accountID, invitation.Email, models.StatusPending, invitation.ID).First(&otherInvitation).Error; err == nil {
+			return nil, ErrAlreadyInvited
+		}
 	}
 
-	return s.db.Create(&invitation).Error
+	var account models.Account
+	if err := s.db.First(&account, "id = ?", accountID).Error; err != nil {
+		return nil, ErrAccountNotFound
+	}
+
+	expiresAt := time.Now().Add(config.InvitationTTL())
+	invitation.ExpiresAt = &expiresAt
+	invitation.Status = models.StatusPending
+	if err := s.db.Save(invitation).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditInvitationResent, "invitation", invitation.ID, nil)
+	publish(s.db, events.InvitationResent{Invitation: *invitation, AccountName: account.Name})
+
+	return invitation, nil
+}
+
+// ExpireInvitations marks pending invitations past their expiry as expired
+func (s *AccountService) ExpireInvitations() (int64, error) {
+	result := s.db.Model(&models.Invitation{}).
+		Where("status = ? AND expires_at IS NOT NULL AND expires_at <= ?", models.StatusPending, time.Now()).
+		Update("status", models.StatusExpired)
+	metrics.Invitations.WithLabelValues(string(models.StatusExpired)).Add(float64(result.RowsAffected))
+	return result.RowsAffected, result.Error
+}
+
+// BindPendingInvitations attaches invitations sent to the user's email
+// address before they had a user. Until the address is verified anyone could
+// have registered it, so those invitations can only be accepted by token
+func (s *AccountService) BindPendingInvitations(user models.User) error {
+	defer s.trace("AccountService.BindPendingInvitations")()
+	if !user.EmailVerified {
+		return nil
+	}
+	return s.db.Model(&models.Invitation{}).
+		Where("email = ? AND (user_id = '' OR user_id IS NULL) AND status = ?",
+			normalizeEmail(user.Email), models.StatusPending).
+		Update("user_id", user.ID).Error
 }
 
 func (s *AccountService) AcceptInvitation(invitationID, userID string) error {
+	defer s.trace("AccountService.AcceptInvitation")()
+	var invitation models.Invitation
+	if err := s.db.Where("id = ? AND user_id = ? AND status = ?",
+		invitationID, userID, models.StatusPending).First(&invitation).Error; err != nil {
+		return ErrInvitationNotFound
+	}
+
+	return s.acceptInvitation(&invitation, userID)
+}
+
+func (s *AccountService) AcceptInvitationByToken(token, userID string) error {
+	defer s.trace("AccountService.AcceptInvitationByToken")()
+	invitationID, email, err := parseInvitationToken(token)
+	if err != nil {
+		return apperrors.Validation("invalid_invitation_token", "invalid or expired invitation token")
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	// The token only proves access to the invited mailbox
+	if normalizeEmail(user.Email) != email {
+		return apperrors.Forbidden("invitation_email_mismatch", "invitation was sent to a different email")
+	}
+
+	var invitation models.Invitation
+	if err := s.db.Where("id = ? AND email = ? AND status = ?",
+		invitationID, email, models.StatusPending).First(&invitation).Error; err != nil {
+		return ErrInvitationNotFound
+	}
+
+	if invitation.UserID != "" && invitation.UserID != userID {
+		return ErrInvitationNotFound
+	}
+
+	return s.acceptInvitation(&invitation, userID)
+}
+
+func (s *AccountService) DeclineInvitation(invitationID, userID string) error {
+	defer s.trace("AccountService.DeclineInvitation")()
 	var invitation models.Invitation
 	if err := s.db.Where("id = ? AND user_id = ? AND status = ?",
 		invitationID, userID, models.StatusPending).First(&invitation).Error; err != nil {
-		return errors.New("invitation not found or already processed")
+		return ErrInvitationNotFound
 	}
 
-	tx := s.db.Begin()
+	invitation.Status = models.StatusDeclined
+	if err := s.db.Save(&invitation).Error; err != nil {
+		return err
+	}
 
+	s.audit(invitation.AccountID, models.UserPrincipal(userID), models.AuditInvitationDeclined, "invitation", invitation.ID, nil)
+	publish(s.db, events.InvitationDeclined{Invitation: invitation})
+	metrics.Invitations.WithLabelValues(string(models.StatusDeclined)).Inc()
+	return nil
+}
+
+func (s *AccountService) acceptInvitation(invitation *models.Invitation, userID string) error {
+	if invitation.IsExpired() {
+		return apperrors.Conflict("invitation_expired", "invitation has expired")
+	}
+
+	if s.isMember(invitation.AccountID, userID) {
+		return ErrAlreadyMember
+	}
+
+	tx := beginTx(s.db)
+
+	invitation.UserID = userID
 	invitation.Status = models.StatusAccepted
-	if err := tx.Save(&invitation).Error; err != nil {
+	if err := tx.Save(invitation).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	membership := models.Membership{
-		AccountID: invitation.AccountID,
-		UserID:    userID,
-		Role:      models.RoleMember,
+	if err := addMember(tx, invitation.AccountID, userID, invitation.Role); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	if err := tx.Create(&membership).Error; err != nil {
-		tx.Rollback()
+	publish(tx, events.InvitationAccepted{Invitation: *invitation})
+	if err := commitTx(tx); err != nil {
 		return err
 	}
 
-	tx.Commit()
+	s.audit(invitation.AccountID, models.UserPrincipal(userID), models.AuditInvitationAccepted, "invitation", invitation.ID, nil)
+	metrics.Invitations.WithLabelValues(string(models.StatusAccepted)).Inc()
+	s.memberJoined(invitation.AccountID, models.UserPrincipal(userID), userID, invitation.Role, "invitation")
 	return nil
 }
 
-func (s *AccountService) DeclineInvitation(invitationID, userID string) error {
+// uniqueSlug derives a URL friendly identifier from the account name
+func (s *AccountService) uniqueSlug(name string) (string, error) {
+	base := slugify(name)
+	if base == "" {
+		base = "account"
+	}
+
+	slug := base
+	for i := 0; i < 5; i++ {
+		var count int
+		if err := s.db.Model(&models.Account{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+		slug = base + "-" + generateSecureHex(3)
+	}
+
+	return "", errors.New("failed to generate a unique account slug")
+}
+
+// can reports whether the principal holds the permission in the account.
+// Users are checked against their membership role, machine principals
+// against the scopes granted to them in their own account.
+func (s *AccountService) can(accountID string, principal models.Principal, permission models.Permission) bool {
+	switch principal.Type {
+	case models.PrincipalAPIKey:
+		return principal.AccountID == accountID && principal.HasScope(permission)
+	case models.PrincipalServiceAccount:
+		return s.serviceAccountCan(accountID, principal, permission)
+	}
+
+	var membership models.Membership
+	if err := s.db.Where("account_id = ? AND user_id = ?", accountID, principal.ID).First(&membership).Error; err != nil {
+		return false
+	}
+	return membership.Role.Can(permission)
+}
+
+func (s *AccountService) authorize(accountID string, principal models.Principal, permission models.Permission) error {
+	if !s.can(accountID, principal, permission) {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// audit records an event of the account performed by the principal
+func (s *AccountService) audit(accountID string, principal models.Principal, action models.AuditAction, targetType, targetID string, metadata map[string]interface{}) {
+	recordAudit(s.db, s.client, models.AuditEvent{
+		AccountID:  accountID,
+		ActorType:  principal.Type,
+		ActorID:    principal.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}, metadata)
+}
+
+// memberJoined records a new member in the audit log and publishes it
+func (s *AccountService) memberJoined(accountID string, principal models.Principal, userID string, role models.MembershipRole, via string) {
+	s.audit(accountID, principal, models.AuditMemberJoined, "user", userID, map[string]interface{}{
+		"role": role,
+		"via":  via,
+	})
+	publish(s.db, events.MembershipCreated{AccountID: accountID, UserID: userID, Role: role, Via: via})
+}
+
+func (s *AccountService) findManageableInvitation(accountID, invitationID string, principal models.Principal) (*models.Invitation, error) {
+	if err := s.authorize(accountID, principal, models.PermInvitationsWrite); err != nil {
+		return nil, err
+	}
+
 	var invitation models.Invitation
-	if err := s.db.Where("id = ? AND user_id = ? AND status = ?",
-		invitationID, userID, models.StatusPending).First(&invitation).Error; err != nil {
-		return errors.New("invitation not found or already processed")
+	if err := s.db.Where("id = ? AND account_id = ?", invitationID, accountID).First(&invitation).Error; err != nil {
+		return nil, apperrors.NotFound("invitation_not_found", "invitation not found")
 	}
 
-	invitation.Status = models.StatusDeclined
-	return s.db.Save(&invitation).Error
+	if invitation.InviterID != principal.ID && !s.can(accountID, principal, models.PermMembersManage) {
+		return nil, ErrNotAuthorized
+	}
+
+	return &invitation, nil
+}
+
+func (s *AccountService) invitationList(invitations []models.Invitation) *models.InvitationListResponse {
+	accountNames := make(map[string]string)
+	response := &models.InvitationListResponse{Invitations: []models.InvitationResponse{}}
+
+	for _, invitation := range invitations {
+		name, ok := accountNames[invitation.AccountID]
+		if !ok {
+			var account models.Account
+			if err := s.db.First(&account, "id = ?", invitation.AccountID).Error; err == nil {
+				name = account.Name
+			}
+			accountNames[invitation.AccountID] = name
+		}
+
+		response.Invitations = append(response.Invitations, models.InvitationResponse{
+			ID:          invitation.ID,
+			AccountID:   invitation.AccountID,
+			AccountName: name,
+			Email:       invitation.Email,
+			Role:        string(invitation.Role),
+			Status:      invitation.Status,
+			InviterID:   invitation.InviterID,
+			ExpiresAt:   invitation.ExpiresAt,
+			CreatedAt:   invitation.CreatedAt,
+		})
+	}
+
+	return response
+}
+
+// Helper functions
+
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	slug := strings.Trim(b.String(), "-")
+	if len(slug) > 48 {
+		slug = strings.Trim(slug[:48], "-")
+	}
+	return slug
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func generateInvitationToken(invitation models.Invitation) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["purpose"] = "invitation"
+	claims["invitation_id"] = invitation.ID
+	claims["email"] = invitation.Email
+	claims["exp"] = invitation.ExpiresAt.Unix()
+
+	tokenString, err := token.SignedString([]byte(config.JWTSecretKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign invitation token: %v", err)
+	}
+
+	return tokenString, nil
+}
+
+func parseInvitationToken(tokenString string) (invitationID, email string, err error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(config.JWTSecretKey), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != "invitation" {
+		return "", "", errors.New("invalid invitation token")
+	}
+
+	invitationID, _ = claims["invitation_id"].(string)
+	email, _ = claims["email"].(string)
+	if invitationID == "" || email == "" {
+		return "", "", errors.New("invalid invitation token")
+	}
+
+	return invitationID, email, nil
+}
+
+func appBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return config.DefaultAppBaseURL
+}
+
+// deliverInvitation emails the invitee the link to accept
+func deliverInvitation(invitation models.Invitation, accountName string) {
+	token, err := generateInvitationToken(invitation)
+	if err != nil {
+		slog.Error("Failed to generate invitation token", "invitation_id", invitation.ID, "error", err)
+		return
+	}
+
+	if err := sendInvitationEmail(context.Background(), invitation.Email, accountName, token); err != nil {
+		slog.Error("Failed to send invitation email", "invitation_id", invitation.ID, "error", err)
+	}
+}
+
+func sendInvitationEmail(ctx context.Context, email, accountName, token string) error {
+	link := fmt.Sprintf("%s/invitations/accept?token=%s", appBaseURL(), token)
+
+	body := fmt.Sprintf("You have been invited to join %s.\n\n"+
+		"Sign in or create an account with this email address, then open the link below to accept:\n\n%s\n", accountName, link)
+	return sendNotificationEmail(ctx, email, "You're invited to join "+accountName, body)
+}
+
+// sendNotificationEmail delivers a plain text email. Without an SMTP server
+// only the subject is logged, bodies carry codes and links that must not end
+// up in the logs.
+func sendNotificationEmail(ctx context.Context, to, subject, body string) (err error) {
+	emailService := NewEmailService()
+	if !emailService.Configured() {
+		slog.Warn("Email not sent, no SMTP server is configured", "subject", subject)
+		return nil
+	}
+
+	_, span := tracer.Start(ctx, "email.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("email.subject", subject)))
+	defer func() { endSpan(span, err) }()
+
+	if err := emailService.SendEmail(to, subject, body); err != nil {
+		metrics.MessageDeliveryFailures.WithLabelValues(metrics.ChannelEmail).Inc()
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/models"
+	"net"
+	"strings"
+	"time"
+)
+
+// CreateAPIKey issues a key for the account. The full key is only part of
+// this response, afterwards only its prefix can be seen.
+func (s *AccountService) CreateAPIKey(accountID string, principal models.Principal, req models.CreateAPIKeyRequest) (*models.APIKeyResponse, error) {
+	defer s.trace("AccountService.CreateAPIKey")()
+	// Keys cannot mint other keys
+	if !principal.IsUser() {
+		return nil, ErrNotAuthorized
+	}
+
+	if err := s.authorize(accountID, principal, models.PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !models.IsValidPermission(scope) {
+			return nil, apperrors.Validation("unknown_scope", fmt.Sprintf("unknown scope: %s", scope))
+		}
+		// A key never gets more than its creator holds
+		if !s.can(accountID, principal, scope) {
+			return nil, apperrors.Forbidden("not_authorized", fmt.Sprintf("not authorized to grant scope: %s", scope))
+		}
+		scopes = append(scopes, string(scope))
+	}
+
+	allowedIPs := make([]string, 0, len(req.AllowedIPs))
+	for _, entry := range req.AllowedIPs {
+		entry = strings.TrimSpace(entry)
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, apperrors.Validation("invalid_allowed_ip", fmt.Sprintf("invalid IP or CIDR: %s", entry))
+			}
+		}
+		allowedIPs = append(allowedIPs, entry)
+	}
+
+	prefix := config.APIKeyPrefix + generateSecureHex(6)
+	secret := generateSecureToken(32)
+
+	apiKey := models.APIKey{
+		AccountID:   accountID,
+		Name:        req.Name,
+		Prefix:      prefix,
+		SecretHash:  hashSecret(secret),
+		Scopes:      strings.Join(scopes, ","),
+		AllowedIPs:  strings.Join(allowedIPs, ","),
+		CreatedByID: principal.ID,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.Create(&apiKey).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditAPIKeyCreated, "api_key", apiKey.ID, map[string]interface{}{
+		"name":   apiKey.Name,
+		"prefix": prefix,
+		"scopes": scopes,
+	})
+
+	response := apiKeyResponse(apiKey)
+	response.Key = prefix + "." + secret
+	return response, nil
+}
+
+func (s *AccountService) ListAPIKeys(accountID string, principal models.Principal) (*models.APIKeyListResponse, error) {
+	defer s.trace("AccountService.ListAPIKeys")()
+	if err := s.authorize(accountID, principal, models.PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+
+	var apiKeys []models.APIKey
+	if err := s.db.Where("account_id = ?", accountID).Order("created_at desc").Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.APIKeyListResponse{APIKeys: []models.APIKeyResponse{}}
+	for _, apiKey := range apiKeys {
+		response.APIKeys = append(response.APIKeys, *apiKeyResponse(apiKey))
+	}
+	return response, nil
+}
+
+func (s *AccountService) RevokeAPIKey(accountID, keyID string, principal models.Principal) error {
+	defer s.trace("AccountService.RevokeAPIKey")()
+	if err := s.authorize(accountID, principal, models.PermAPIKeysManage); err != nil {
+		return err
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.Where("id = ? AND account_id = ?", keyID, accountID).First(&apiKey).Error; err != nil {
+		return apperrors.NotFound("api_key_not_found", "API key not found")
+	}
+
+	if apiKey.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	apiKey.RevokedAt = &now
+	if err := s.db.Save(&apiKey).Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditAPIKeyRevoked, "api_key", apiKey.ID, nil)
+	return nil
+}
+
+// AuthenticateAPIKey resolves a presented key to the account principal it
+// acts as, enforcing expiry, revocation and the IP allowlist
+func (s *AccountService) AuthenticateAPIKey(key, clientIP string) (*models.Principal, error) {
+	defer s.trace("AccountService.AuthenticateAPIKey")()
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], config.APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.Where("prefix = ?", parts[0]).First(&apiKey).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(hashSecret(parts[1]))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if !apiKey.Active() {
+		return nil, apperrors.Unauthorized("api_key_inactive", "API key is revoked or expired")
+	}
+
+	if !ipAllowed(clientIP, apiKey.AllowedIPList()) {
+		return nil, apperrors.Unauthorized("api_key_ip_not_allowed", "API key is not allowed from this IP")
+	}
+
+	// Busy keys would otherwise write to the database on every request
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= config.APIKeyLastUsedInterval || apiKey.LastUsedIP != clientIP {
+		if err := s.db.Model(&apiKey).UpdateColumns(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": clientIP,
+		}).Error; err != nil {
+			s.log.Warn("Failed to record API key use", "api_key_id", apiKey.ID, "error", err)
+		}
+	}
+
+	return &models.Principal{
+		Type:      models.PrincipalAPIKey,
+		ID:        apiKey.ID,
+		AccountID: apiKey.AccountID,
+		Scopes:    apiKey.ScopeList(),
+	}, nil
+}
+
+// Helper functions
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func ipAllowed(clientIP string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	for _, entry := range allowed {
+		if allowedIP := net.ParseIP(entry); allowedIP != nil {
+			if allowedIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyResponse(apiKey models.APIKey) *models.APIKeyResponse {
+	return &models.APIKeyResponse{
+		APIKey:     apiKey,
+		Scopes:     apiKey.ScopeList(),
+		AllowedIPs: apiKey.AllowedIPList(),
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/models"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+
+	create := func(req models.CreateAPIKeyRequest) string {
+		t.Helper()
+		req.Name = "ci"
+		if len(req.Scopes) == 0 {
+			req.Scopes = []models.Permission{models.PermAccountRead}
+		}
+		response, err := s.CreateAPIKey(accountID, owner, req)
+		if err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+		return response.Key
+	}
+
+	key := create(models.CreateAPIKeyRequest{})
+	prefix, secret, _ := strings.Cut(key, ".")
+	otherKey := create(models.CreateAPIKeyRequest{})
+	_, otherSecret, _ := strings.Cut(otherKey, ".")
+
+	expired := create(models.CreateAPIKeyRequest{ExpiresInDays: 1})
+	expiredPrefix, _, _ := strings.Cut(expired, ".")
+	if err := db.Model(&models.APIKey{}).Where("prefix = ?", expiredPrefix).
+		UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	revoked := create(models.CreateAPIKeyRequest{})
+	revokedPrefix, _, _ := strings.Cut(revoked, ".")
+	var revokedKey models.APIKey
+	if err := db.Where("prefix = ?", revokedPrefix).First(&revokedKey).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeAPIKey(accountID, revokedKey.ID, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	allowlisted := create(models.CreateAPIKeyRequest{AllowedIPs: []string{"203.0.113.7", "198.51.100.0/24"}})
+
+	tests := []struct {
+		name     string
+		key      string
+		clientIP string
+		want     string // Error code, empty when the key is accepted
+	}{
+		{name: "valid key", key: key, clientIP: "192.0.2.1"},
+		{name: "secret of another key", key: prefix + "." + otherSecret, clientIP: "192.0.2.1", want: "invalid_api_key"},
+		{name: "truncated secret", key: prefix + "." + secret[:len(secret)-1], clientIP: "192.0.2.1", want: "invalid_api_key"},
+		{name: "unknown prefix", key: "gbk_000000000000." + secret, clientIP: "192.0.2.1", want: "invalid_api_key"},
+		{name: "foreign prefix", key: "sk_" + strings.TrimPrefix(key, "gbk_"), clientIP: "192.0.2.1", want: "invalid_api_key"},
+		{name: "no secret", key: prefix, clientIP: "192.0.2.1", want: "invalid_api_key"},
+		{name: "expired", key: expired, clientIP: "192.0.2.1", want: "api_key_inactive"},
+		{name: "revoked", key: revoked, clientIP: "192.0.2.1", want: "api_key_inactive"},
+		{name: "allowlisted IP", key: allowlisted, clientIP: "203.0.113.7"},
+		{name: "allowlisted network", key: allowlisted, clientIP: "198.51.100.42"},
+		{name: "IP outside the allowlist", key: allowlisted, clientIP: "203.0.113.8", want: "api_key_ip_not_allowed"},
+		{name: "unparsable IP", key: allowlisted, clientIP: "", want: "api_key_ip_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := s.AuthenticateAPIKey(tt.key, tt.clientIP)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("key rejected: %v", err)
+				}
+				if principal.Type != models.PrincipalAPIKey || principal.AccountID != accountID {
+					t.Errorf("principal = %+v, want an API key of account %s", principal, accountID)
+				}
+				return
+			}
+
+			var appErr *apperrors.Error
+			if !errors.As(err, &appErr) || appErr.Code != tt.want {
+				t.Fatalf("error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+	otherAccountID, _ := createTestAccount(t, db, models.RoleOwner)
+
+	response, err := s.CreateAPIKey(accountID, owner, models.CreateAPIKeyRequest{
+		Name:   "reader",
+		Scopes: []models.Permission{models.PermAccountRead, models.PermInvitationsRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := s.AuthenticateAPIKey(response.Key, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		accountID  string
+		permission models.Permission
+		want       bool
+	}{
+		{accountID, models.PermAccountRead, true},
+		{accountID, models.PermInvitationsRead, true},
+		{accountID, models.PermInvitationsWrite, false},
+		{accountID, models.PermAPIKeysManage, false},
+		{otherAccountID, models.PermAccountRead, false},
+	} {
+		if got := s.can(tt.accountID, *principal, tt.permission); got != tt.want {
+			t.Errorf("can(%s in %s) = %v, want %v", tt.permission, tt.accountID, got, tt.want)
+		}
+	}
+
+	// Keys cannot mint keys, and never get more than their creator holds
+	if _, err := s.CreateAPIKey(accountID, *principal, models.CreateAPIKeyRequest{
+		Name: "minted", Scopes: []models.Permission{models.PermAccountRead},
+	}); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("key minted a key: %v", err)
+	}
+	_, admin := createTestMember(t, db, accountID, models.RoleAdmin)
+	if _, err := s.CreateAPIKey(accountID, admin, models.CreateAPIKeyRequest{
+		Name: "sso", Scopes: []models.Permission{models.PermSSOManage},
+	}); err == nil {
+		t.Error("admin granted a scope only owners hold")
+	}
+}
+
+func TestAuthenticateAPIKeyThrottlesLastUsed(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+
+	response, err := s.CreateAPIKey(accountID, owner, models.CreateAPIKeyRequest{
+		Name: "busy", Scopes: []models.Permission{models.PermAccountRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := func() models.APIKey {
+		t.Helper()
+		var apiKey models.APIKey
+		if err := db.First(&apiKey, "id = ?", response.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return apiKey
+	}
+	authenticate := func(clientIP string) {
+		t.Helper()
+		if _, err := s.AuthenticateAPIKey(response.Key, clientIP); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticate("192.0.2.1")
+	first := lastUsed()
+	if first.LastUsedAt == nil || first.LastUsedIP != "192.0.2.1" {
+		t.Fatalf("first use not recorded: %+v", first)
+	}
+
+	// Within the interval from the same IP nothing is written
+	stale := first.LastUsedAt.Add(-time.Second)
+	if err := db.Model(&models.APIKey{}).Where("id = ?", response.ID).UpdateColumn("last_used_at", stale).Error; err != nil {
+		t.Fatal(err)
+	}
+	authenticate("192.0.2.1")
+	if got := lastUsed().LastUsedAt; !got.Equal(stale) {
+		t.Errorf("last use rewritten within the interval: %v, want %v", got, stale)
+	}
+
+	// Another IP is recorded right away
+	authenticate("192.0.2.2")
+	if got := lastUsed(); got.LastUsedIP != "192.0.2.2" || got.LastUsedAt.Equal(stale) {
+		t.Errorf("use from another IP not recorded: %+v", got)
+	}
+
+	// And so is the next use once the interval passed
+	old := time.Now().Add(-2 * time.Minute)
+	if err := db.Model(&models.APIKey{}).Where("id = ?", response.ID).UpdateColumn("last_used_at", old).Error; err != nil {
+		t.Fatal(err)
+	}
+	authenticate("192.0.2.2")
+	if got := lastUsed().LastUsedAt; !got.After(old.Add(time.Minute)) {
+		t.Errorf("last use not refreshed after the interval: %v", got)
+	}
+}
+
+// Helper functions
+
+// createTestAccount creates an account with a user of the role in it
+func createTestAccount(t *testing.T, db *gorm.DB, role models.MembershipRole) (string, models.Principal) {
+	t.Helper()
+
+	account := models.Account{Name: "Acme", Slug: "acme", OwnerID: "pending"}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, principal := createTestMember(t, db, account.ID, role)
+	if role == models.RoleOwner {
+		if err := db.Model(&account).UpdateColumn("owner_id", principal.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return account.ID, principal
+}
+
+func createTestMember(t *testing.T, db *gorm.DB, accountID string, role models.MembershipRole) (models.User, models.Principal) {
+	t.Helper()
+
+	var count int
+	db.Model(&models.User{}).Count(&count)
+	user := models.User{
+		Username:      fmt.Sprintf("user%d", count),
+		Email:         fmt.Sprintf("user%d@example.com", count),
+		EmailVerified: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&models.Membership{AccountID: accountID, UserID: user.ID, Role: role}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user, models.UserPrincipal(user.ID)
+}
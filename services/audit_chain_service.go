@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"go-backend/config"
+	"go-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// VerifyChains walks every audit chain and reports events that were
+// modified, removed or inserted out of order
+func (s *AuditService) VerifyChains() ([]models.AuditChainReport, error) {
+	var chains []models.AuditChain
+	if err := s.db.Order("id").Find(&chains).Error; err != nil {
+		return nil, err
+	}
+
+	reports := make([]models.AuditChainReport, 0, len(chains))
+	for _, chain := range chains {
+		report, err := s.verifyChain(chain)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+
+	// Events can only be appended through their chain's head
+	var orphans []struct {
+		ChainID string
+		Events  int64
+	}
+	if err := s.db.Model(&models.AuditEvent{}).Select("chain_id, COUNT(*) AS events").
+		Where("chain_id NOT IN (?)", s.db.Model(&models.AuditChain{}).Select("id").QueryExpr()).
+		Group("chain_id").Scan(&orphans).Error; err != nil {
+		return nil, err
+	}
+	for _, orphan := range orphans {
+		reports = append(reports, models.AuditChainReport{
+			ChainID: orphan.ChainID,
+			Events:  orphan.Events,
+			Problems: []models.AuditChainProblem{{
+				Problem: "events belong to a chain that does not exist",
+			}},
+		})
+	}
+
+	return reports, nil
+}
+
+// VerifyChain walks a single chain, identified by its account or user ID, or
+// config.SystemAuditChainID
+func (s *AuditService) VerifyChain(chainID string) (*models.AuditChainReport, error) {
+	var chain models.AuditChain
+	if err := s.db.Where("id = ?", chainID).First(&chain).Error; err != nil {
+		return nil, fmt.Errorf("audit chain %q not found", chainID)
+	}
+	return s.verifyChain(chain)
+}
+
+func (s *AuditService) verifyChain(chain models.AuditChain) (*models.AuditChainReport, error) {
+	report := &models.AuditChainReport{ChainID: chain.ID, Problems: []models.AuditChainProblem{}}
+	problem := func(sequence int64, eventID, format string, args ...interface{}) {
+		report.Problems = append(report.Problems, models.AuditChainProblem{
+			Sequence: sequence,
+			EventID:  eventID,
+			Problem:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Events are streamed, a chain can be far larger than memory
+	rows, err := s.db.Model(&models.AuditEvent{}).Where("chain_id = ?", chain.ID).Order("sequence").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	key := config.AuditChainKey()
+	if chain.MAC != chain.ComputeMAC(key) {
+		problem(chain.Sequence, "", "chain head was modified")
+	}
+
+	// Retention removes the start of the chain, after which the hash the
+	// first remaining event links to cannot be checked
+	expected := chain.PurgedThrough + 1
+	prevHash, prevKnown := "", chain.PurgedThrough == 0
+	var last models.AuditEvent
+
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := s.db.ScanRows(rows, &event); err != nil {
+			return nil, err
+		}
+
+		if report.Events == 0 {
+			report.FirstSequence = event.Sequence
+		}
+		report.Events++
+
+		if event.Sequence > expected {
+			problem(expected, "", "events %d to %d are missing", expected, event.Sequence-1)
+			prevKnown = false
+		}
+		if event.Hash != event.ComputeHash(key) {
+			problem(event.Sequence, event.ID, "event was modified")
+		} else if !event.PersonalDataIntact(key, config.DeletedUserID) {
+			problem(event.Sequence, event.ID, "personal data was modified")
+		}
+		if prevKnown && event.PrevHash != prevHash {
+			problem(event.Sequence, event.ID, "event does not follow the event before it")
+		}
+
+		prevHash, prevKnown = event.Hash, true
+		expected = event.Sequence + 1
+		last = event
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	report.LastSequence = last.Sequence
+
+	switch {
+	case chain.Sequence >= expected:
+		problem(expected, "", "events %d to %d are missing", expected, chain.Sequence)
+	case last.Sequence > chain.Sequence:
+		problem(last.Sequence, last.ID, "events after %d were added outside the chain", chain.Sequence)
+	case report.Events > 0 && last.Hash != chain.Hash:
+		problem(last.Sequence, last.ID, "latest event does not match the chain head")
+	}
+
+	return report, nil
+}
+
+// Helper functions
+
+// appendAuditEvent links the event to the end of its chain and stores it.
+// Within a transaction the event is part of it, so it is only kept if the
+// change it records is.
+func appendAuditEvent(db *gorm.DB, event *models.AuditEvent) error {
+	if _, inTransaction := db.CommonDB().(*sql.Tx); inTransaction {
+		return chainAuditEvent(db, event)
+	}
+
+	tx := db.Begin()
+	if err := chainAuditEvent(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func chainAuditEvent(tx *gorm.DB, event *models.AuditEvent) error {
+	// An account's events form one chain, a user's own security events
+	// another, and events about nobody known the system chain
+	event.ChainID = event.AccountID
+	if event.ChainID == "" {
+		event.ChainID = event.TargetID
+	}
+	if event.ChainID == "" {
+		event.ChainID = config.SystemAuditChainID
+	}
+
+	insert := "INSERT IGNORE"
+	if tx.Dialect().GetName() == "sqlite3" {
+		insert = "INSERT OR IGNORE"
+	}
+	if err := tx.Exec(insert+" INTO audit_chains (id, sequence, hash, purged_through, updated_at) VALUES (?, 0, '', 0, ?)",
+		event.ChainID, time.Now()).Error; err != nil {
+		return err
+	}
+
+	// Locking the head makes concurrent events of the chain queue up
+	var chain models.AuditChain
+	if err := lockAuditChainHead(tx, event.ChainID, &chain); err != nil {
+		return err
+	}
+
+	// The personal data references include the ID
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+
+	key := config.AuditChainKey()
+	event.Sequence = chain.Sequence + 1
+	event.PrevHash = chain.Hash
+	event.CreatedAt = time.Now().Truncate(time.Second)
+	event.SetPersonalRefs(key)
+	event.Hash = event.ComputeHash(key)
+
+	if err := tx.Create(event).Error; err != nil {
+		return err
+	}
+
+	chain.Sequence = event.Sequence
+	chain.Hash = event.Hash
+	return updateAuditChainHead(tx, &chain)
+}
+
+// lockAuditChainHead reads the chain's head and keeps it locked until the
+// transaction ends. SQLite has no row locks, its writers are serialized
+// anyway.
+func lockAuditChainHead(tx *gorm.DB, chainID string, chain *models.AuditChain) error {
+	if tx.Dialect().GetName() != "sqlite3" {
+		tx = tx.Set("gorm:query_option", "FOR UPDATE")
+	}
+	return tx.Where("id = ?", chainID).First(chain).Error
+}
+
+// updateAuditChainHead stores the chain's end and retention point with a MAC
+// over both
+func updateAuditChainHead(tx *gorm.DB, chain *models.AuditChain) error {
+	return tx.Model(&models.AuditChain{}).Where("id = ?", chain.ID).UpdateColumns(map[string]interface{}{
+		"sequence":       chain.Sequence,
+		"hash":           chain.Hash,
+		"purged_through": chain.PurgedThrough,
+		"mac":            chain.ComputeMAC(config.AuditChainKey()),
+		"updated_at":     time.Now(),
+	}).Error
+}
+
+// redactAuditEvents replaces the user with config.DeletedUserID wherever
+// they acted or were acted on, and blanks where their own requests came
+// from. The events' hashes cover references to this data, so the chains
+// stay intact.
+func redactAuditEvents(tx *gorm.DB, userID string) error {
+	key := config.AuditChainKey()
+	for {
+		// Redacted events no longer match, so each batch picks up where the
+		// last one ended
+		var events []models.AuditEvent
+		if err := tx.Where("actor_id = ? OR (target_type = ? AND target_id = ?)", userID, "user", userID).
+			Limit(config.AuditRedactionBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			if event.ActorID == userID {
+				event.ActorID = config.DeletedUserID
+				event.IP = ""
+				event.UserAgent = ""
+			}
+			if event.TargetType == "user" && event.TargetID == userID {
+				event.TargetID = config.DeletedUserID
+			}
+
+			if err := tx.Model(&models.AuditEvent{}).Where("id = ?", event.ID).UpdateColumns(map[string]interface{}{
+				"actor_id":      event.ActorID,
+				"target_id":     event.TargetID,
+				"ip":            event.IP,
+				"user_agent":    event.UserAgent,
+				"redaction_mac": event.ComputeRedactionMAC(key),
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	testAccountID = "11111111-1111-1111-1111-111111111111"
+	testUserID    = "22222222-2222-2222-2222-222222222222"
+	testAdminID   = "33333333-3333-3333-3333-333333333333"
+)
+
+func TestRedactAuditEventsKeepsChainsValid(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AuditService{db: db, log: slog.Default()}
+
+	acted := appendTestAuditEvent(t, db, models.AuditEvent{
+		AccountID: testAccountID, ActorType: models.PrincipalUser, ActorID: testUserID,
+		Action: models.AuditInvitationCreated, TargetType: "invitation", TargetID: "invitation-1",
+		IP: "203.0.113.7", UserAgent: "browser",
+	})
+	actedOn := appendTestAuditEvent(t, db, models.AuditEvent{
+		AccountID: testAccountID, ActorType: models.PrincipalUser, ActorID: testAdminID,
+		Action: models.AuditMemberRemoved, TargetType: "user", TargetID: testUserID,
+		IP: "198.51.100.1", UserAgent: "admin browser",
+	})
+
+	if err := redactAuditEvents(db, testUserID); err != nil {
+		t.Fatalf("redactAuditEvents: %v", err)
+	}
+
+	report, err := s.VerifyChain(testAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("redaction broke the chain: %+v", report.Problems)
+	}
+
+	acted = reloadAuditEvent(t, db, acted.ID)
+	if acted.ActorID != config.DeletedUserID || acted.IP != "" || acted.UserAgent != "" {
+		t.Errorf("actor not redacted: actor_id=%q ip=%q user_agent=%q", acted.ActorID, acted.IP, acted.UserAgent)
+	}
+	actedOn = reloadAuditEvent(t, db, actedOn.ID)
+	if actedOn.TargetID != config.DeletedUserID {
+		t.Errorf("target not redacted: target_id=%q", actedOn.TargetID)
+	}
+	if actedOn.ActorID != testAdminID || actedOn.IP != "198.51.100.1" {
+		t.Errorf("the admin who acted was redacted too: actor_id=%q ip=%q", actedOn.ActorID, actedOn.IP)
+	}
+}
+
+func TestVerifyChainRejectsForgedRedactions(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AuditService{db: db, log: slog.Default()}
+
+	event := appendTestAuditEvent(t, db, models.AuditEvent{
+		AccountID: testAccountID, ActorType: models.PrincipalUser, ActorID: testUserID,
+		Action: models.AuditMemberRoleChanged, TargetType: "user", TargetID: testAdminID,
+		IP: "203.0.113.7", UserAgent: "browser",
+	})
+
+	// Without the key, a redaction cannot be signed
+	if err := db.Model(&models.AuditEvent{}).Where("id = ?", event.ID).UpdateColumns(map[string]interface{}{
+		"actor_id":      config.DeletedUserID,
+		"ip":            "",
+		"user_agent":    "",
+		"redaction_mac": strings.Repeat("0", 64),
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.VerifyChain(testAccountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAuditProblem(t, report, event.Sequence, event.ID, "personal data was modified")
+}
+
+func TestVerifyChainsCoversSystemChain(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AuditService{db: db, log: slog.Default()}
+
+	// Failed logins for unknown emails concern nobody known
+	var events []models.AuditEvent
+	for _, email := range []string{"nobody@example.com", "ghost@example.com"} {
+		events = append(events, appendTestAuditEvent(t, db, models.AuditEvent{
+			Action:   models.AuditLoginFailed,
+			IP:       "203.0.113.7",
+			Metadata: `{"email":"` + email + `","reason":"unknown_user"}`,
+		}))
+	}
+	for _, event := range events {
+		if event.ChainID != config.SystemAuditChainID {
+			t.Fatalf("event in chain %q, want %q", event.ChainID, config.SystemAuditChainID)
+		}
+	}
+
+	reports, err := s.VerifyChains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].ChainID != config.SystemAuditChainID || reports[0].Events != 2 || !reports[0].OK() {
+		t.Fatalf("reports = %+v, want the system chain with 2 valid events", reports)
+	}
+
+	tamper(t, db, events[1].ID, "metadata", `{"email":"someone@example.com","reason":"unknown_user"}`)
+
+	report, err := s.VerifyChain(config.SystemAuditChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAuditProblem(t, report, events[1].Sequence, events[1].ID, "event was modified")
+}
+
+func TestVerifyChainDetectsTampering(t *testing.T) {
+	const forgedEventID = "44444444-4444-4444-4444-444444444444"
+
+	tests := []struct {
+		name     string
+		tamper   func(t *testing.T, db *gorm.DB, events []models.AuditEvent)
+		want     func(events []models.AuditEvent) models.AuditChainProblem // The first problem reported
+		problems int
+	}{
+		{
+			name: "modified event",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				tamper(t, db, events[1].ID, "action", models.AuditInvitationCancelled)
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 2, EventID: events[1].ID, Problem: "event was modified"}
+			},
+			problems: 1,
+		},
+		{
+			name: "modified metadata",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				tamper(t, db, events[2].ID, "metadata", `{"role":"owner"}`)
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 3, EventID: events[2].ID, Problem: "event was modified"}
+			},
+			problems: 1,
+		},
+		{
+			name: "deleted event",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				deleteAuditEvent(t, db, events[1].ID)
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 2, Problem: "events 2 to 2 are missing"}
+			},
+			problems: 1,
+		},
+		{
+			name: "deleted latest event",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				deleteAuditEvent(t, db, events[3].ID)
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 4, Problem: "events 4 to 4 are missing"}
+			},
+			problems: 1,
+		},
+		{
+			name: "reordered events",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				tamper(t, db, events[1].ID, "sequence", -1)
+				tamper(t, db, events[2].ID, "sequence", 2)
+				tamper(t, db, events[1].ID, "sequence", 3)
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 2, EventID: events[2].ID, Problem: "event was modified"}
+			},
+			problems: 5,
+		},
+		{
+			name: "event added outside the chain",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				// Correctly hashed, but the chain head was never moved on
+				forged := events[3]
+				forged.ID = forgedEventID
+				forged.Sequence = 5
+				forged.PrevHash = events[3].Hash
+				forged.SetPersonalRefs(config.AuditChainKey())
+				forged.Hash = forged.ComputeHash(config.AuditChainKey())
+				if err := db.Create(&forged).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 5, EventID: forgedEventID, Problem: "events after 4 were added outside the chain"}
+			},
+			problems: 1,
+		},
+		{
+			name: "chain head moved back over deleted events",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				deleteAuditEvent(t, db, events[3].ID)
+				if err := db.Model(&models.AuditChain{}).Where("id = ?", testAccountID).
+					UpdateColumns(map[string]interface{}{"sequence": 3, "hash": events[2].Hash}).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 3, Problem: "chain head was modified"}
+			},
+			problems: 1,
+		},
+		{
+			name: "verified with another key",
+			tamper: func(t *testing.T, db *gorm.DB, events []models.AuditEvent) {
+				t.Setenv("AUDIT_CHAIN_KEY", "another-key")
+			},
+			want: func(events []models.AuditEvent) models.AuditChainProblem {
+				return models.AuditChainProblem{Sequence: 4, Problem: "chain head was modified"}
+			},
+			problems: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+			db := newTestDB(t)
+			s := &AuditService{db: db, log: slog.Default()}
+
+			var events []models.AuditEvent
+			for _, action := range []models.AuditAction{
+				models.AuditAccountCreated, models.AuditInvitationCreated,
+				models.AuditMemberRoleChanged, models.AuditAPIKeyCreated,
+			} {
+				events = append(events, appendTestAuditEvent(t, db, models.AuditEvent{
+					AccountID: testAccountID, ActorType: models.PrincipalUser, ActorID: testUserID,
+					Action: action, IP: "203.0.113.7", Metadata: `{"role":"member"}`,
+				}))
+			}
+
+			report, err := s.VerifyChain(testAccountID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() {
+				t.Fatalf("untouched chain reported: %+v", report.Problems)
+			}
+
+			tt.tamper(t, db, events)
+
+			report, err = s.VerifyChain(testAccountID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.OK() {
+				t.Fatal("tampering was not detected")
+			}
+
+			if want := tt.want(events); report.Problems[0] != want {
+				t.Errorf("first problem = %+v, want %+v", report.Problems[0], want)
+			}
+			if len(report.Problems) != tt.problems {
+				t.Errorf("got %d problems, want %d: %+v", len(report.Problems), tt.problems, report.Problems)
+			}
+		})
+	}
+}
+
+// Helper functions
+
+func deleteAuditEvent(t *testing.T, db *gorm.DB, eventID string) {
+	t.Helper()
+
+	if err := db.Where("id = ?", eventID).Delete(&models.AuditEvent{}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tamper edits a stored event behind the chain's back
+func tamper(t *testing.T, db *gorm.DB, eventID, column string, value interface{}) {
+	t.Helper()
+
+	if err := db.Model(&models.AuditEvent{}).Where("id = ?", eventID).UpdateColumn(column, value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendTestAuditEvent(t *testing.T, db *gorm.DB, event models.AuditEvent) models.AuditEvent {
+	t.Helper()
+
+	if err := appendAuditEvent(db, &event); err != nil {
+		t.Fatalf("appendAuditEvent: %v", err)
+	}
+	return event
+}
+
+func reloadAuditEvent(t *testing.T, db *gorm.DB, id string) models.AuditEvent {
+	t.Helper()
+
+	var event models.AuditEvent
+	if err := db.First(&event, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// assertAuditProblem checks that the report holds exactly the one problem
+func assertAuditProblem(t *testing.T, report *models.AuditChainReport, sequence int64, eventID, problem string) {
+	t.Helper()
+
+	if len(report.Problems) != 1 {
+		t.Fatalf("problems = %+v, want only %q", report.Problems, problem)
+	}
+	got := report.Problems[0]
+	if got.Sequence != sequence || got.EventID != eventID || got.Problem != problem {
+		t.Errorf("problem = %+v, want {Sequence:%d EventID:%s Problem:%s}", got, sequence, eventID, problem)
+	}
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidCursor is returned for a cursor that did not come from a previous page
+var ErrInvalidCursor = apperrors.Validation("invalid_cursor", "invalid cursor")
+
+type AuditService struct {
+	db  *gorm.DB
+	log *slog.Logger
+}
+
+func NewAuditService() *AuditService {
+	return &AuditService{db: config.DB, log: slog.Default()}
+}
+
+// ListAccountEvents returns a page of the account's audit log
+func (s *AuditService) ListAccountEvents(accountID string, principal models.Principal, query models.AuditLogQuery) (*models.AuditEventListResponse, error) {
+	accountService := &AccountService{db: s.db, log: s.log}
+	if err := accountService.authorize(accountID, principal, models.PermAuditLogRead); err != nil {
+		return nil, err
+	}
+
+	return s.list(s.db.Where("account_id = ?", accountID), query)
+}
+
+// ListUserEvents returns a page of the events of the user's own security,
+// such as logins and password changes
+func (s *AuditService) ListUserEvents(userID string, query models.AuditLogQuery) (*models.AuditEventListResponse, error) {
+	return s.list(s.db.Where("account_id = '' AND target_type = ? AND target_id = ?", "user", userID), query)
+}
+
+// PurgeExpired deletes events older than the retention period. Each chain
+// remembers how far it was purged, so expired events are not mistaken for
+// removed ones.
+func (s *AuditService) PurgeExpired() (int64, error) {
+	cutoff := time.Now().Add(-config.AuditLogRetention())
+
+	// Events of a chain are appended in time order, so the expired ones are
+	// a prefix of the chain
+	var expired []struct {
+		ChainID  string
+		Sequence int64
+	}
+	if err := s.db.Model(&models.AuditEvent{}).Select("chain_id, MAX(sequence) AS sequence").
+		Where("created_at < ?", cutoff).Group("chain_id").Scan(&expired).Error; err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, chain := range expired {
+		tx := s.db.Begin()
+
+		// The head's MAC is recomputed from it, so it must not change meanwhile
+		var head models.AuditChain
+		headErr := lockAuditChainHead(tx, chain.ChainID, &head)
+		if headErr != nil && !gorm.IsRecordNotFoundError(headErr) {
+			tx.Rollback()
+			return purged, headErr
+		}
+
+		result := tx.Where("chain_id = ? AND sequence <= ?", chain.ChainID, chain.Sequence).Delete(&models.AuditEvent{})
+		if result.Error != nil {
+			tx.Rollback()
+			return purged, result.Error
+		}
+		if headErr == nil {
+			head.PurgedThrough = chain.Sequence
+			if err := updateAuditChainHead(tx, &head); err != nil {
+				tx.Rollback()
+				return purged, err
+			}
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return purged, err
+		}
+		purged += result.RowsAffected
+	}
+	return purged, nil
+}
+
+func (s *AuditService) list(db *gorm.DB, query models.AuditLogQuery) (*models.AuditEventListResponse, error) {
+	if query.Action != "" {
+		if strings.HasSuffix(query.Action, ".*") {
+			db = db.Where("action LIKE ?", escapeLike(strings.TrimSuffix(query.Action, "*"))+"%")
+		} else {
+			db = db.Where("action = ?", query.Action)
+		}
+	}
+	if query.ActorID != "" {
+		db = db.Where("actor_id = ?", query.ActorID)
+	}
+	if query.TargetType != "" {
+		db = db.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetID != "" {
+		db = db.Where("target_id = ?", query.TargetID)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("created_at < ?", query.Until)
+	}
+
+	if query.Cursor != "" {
+		createdAt, id, err := parseAuditCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where("created_at < ? OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = config.DefaultAuditLogPageSize
+	}
+
+	// One more than asked for tells whether there is a next page
+	var events []models.AuditEvent
+	if err := db.Order("created_at desc, id desc").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.AuditEventListResponse{Events: []models.AuditEventResponse{}}
+	if len(events) > limit {
+		events = events[:limit]
+		response.NextCursor = auditCursor(events[limit-1])
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, auditEventResponse(event))
+	}
+	return response, nil
+}
+
+// Helper functions
+
+// recordAudit appends an event to the audit log. Failing to record is logged
+// and never fails the action being recorded.
+func recordAudit(db *gorm.DB, client models.ClientInfo, event models.AuditEvent, metadata map[string]interface{}) {
+	event.IP = client.IP
+	event.UserAgent = client.UserAgent
+	if len(event.UserAgent) > 500 {
+		event.UserAgent = strings.ToValidUTF8(event.UserAgent[:500], "")
+	}
+
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			requestLogger(client).Error("Failed to encode audit metadata", "action", event.Action, "error", err)
+		} else {
+			event.Metadata = string(encoded)
+		}
+	}
+
+	if err := appendAuditEvent(db, &event); err != nil {
+		requestLogger(client).Error("Failed to record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// auditCursor points just past the event in newest first order
+func auditCursor(event models.AuditEvent) string {
+	value := event.CreatedAt.Format(time.RFC3339Nano) + "|" + event.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func parseAuditCursor(cursor string) (time.Time, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(decoded), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, parts[1], nil
+}
+
+func auditEventResponse(event models.AuditEvent) models.AuditEventResponse {
+	response := models.AuditEventResponse{AuditEvent: event}
+	if event.Metadata != "" {
+		response.Metadata = json.RawMessage(event.Metadata)
+	}
+	return response
+}
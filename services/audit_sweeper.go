@@ -0,0 +1,33 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StartAuditRetentionSweeper periodically deletes audit events older than
+// the retention period until stop is closed, then marks done once a run in
+// progress finished
+func StartAuditRetentionSweeper(interval time.Duration, stop <-chan struct{}, done *sync.WaitGroup) {
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				purged, err := NewAuditService().PurgeExpired()
+				if err != nil {
+					slog.Error("Failed to purge audit events", "error", err)
+				} else if purged > 0 {
+					slog.Info("Purged audit events", "count", purged)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
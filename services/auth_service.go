@@ -1,37 +1,63 @@
 package services
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"go-backend/apperrors"
 	"go-backend/config"
+	"go-backend/events"
+	"go-backend/metrics"
 	"go-backend/models"
-	"log"
-	"math/rand"
+	"go-backend/tracing"
+	"log/slog"
+	"math/big"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AuthService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	client models.ClientInfo
+	log    *slog.Logger
 }
 
 func NewAuthService() *AuthService {
-	return &AuthService{db: config.DB}
+	return &AuthService{db: config.DB, log: slog.Default()}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *AuthService) WithClient(client models.ClientInfo) *AuthService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
 }
 
 func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.Register")()
 	// Check if user already exists
 	var existingUser models.User
 	if err := s.db.Where("email = ? OR username = ?", req.Email, req.Username).First(&existingUser).Error; err == nil {
-		return nil, errors.New("user with this email or username already exists")
+		return nil, apperrors.Conflict("user_exists", "user with this email or username already exists")
+	}
+
+	if err := GetPasswordPolicy().Validate(PasswordCandidate{
+		Password: req.Password,
+		Username: req.Username,
+		Email:    req.Email,
+	}); err != nil {
+		return nil, err
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := GetPasswordHasher().Hash(req.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %v", err)
 	}
@@ -42,8 +68,8 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse
 	user := models.User{
 		Username:         req.Username,
 		Email:            req.Email,
-		Password:         string(hashedPassword),
-		Phone:            req.Phone,
+		Password:         hashedPassword,
+		Phone:            &req.Phone,
 		VerificationCode: verificationCode,
 		EmailVerified:    false,
 		MFAEnabled:       false,
@@ -53,10 +79,9 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
-	// Send verification code
-	if err := sendVerificationPhone(user.Phone, verificationCode); err != nil {
-		log.Printf("Failed to send verification code: %v", err)
-	}
+	s.audit(models.AuditUserRegistered, user.ID, user.ID, nil)
+	publish(s.db, events.UserRegistered{User: user, Method: "password"})
+	metrics.Registrations.WithLabelValues("password").Inc()
 
 	return &models.AuthResponse{
 		NextFlow: "PhoneVerification",
@@ -64,18 +89,51 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse
 }
 
 func (s *AuthService) Login(req models.LoginRequest) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.Login")()
+	if err := ssoRequired(s.db, req.Email); err != nil {
+		metrics.Logins.WithLabelValues("password", metrics.LoginSSORequired).Inc()
+		return nil, err
+	}
+
 	var user models.User
 	if err := s.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("invalid credentials")
+			s.audit(models.AuditLoginFailed, "", "", map[string]interface{}{
+				"method": "password",
+				"email":  normalizeEmail(req.Email),
+				"reason": "unknown_user",
+			})
+			metrics.Logins.WithLabelValues("password", metrics.LoginInvalidCredentials).Inc()
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
+	hasher := GetPasswordHasher()
+	if ok, _ := hasher.Verify(req.Password, user.Password); !ok {
+		s.audit(models.AuditLoginFailed, user.ID, "", map[string]interface{}{
+			"method": "password",
+			"reason": "invalid_password",
+		})
+		metrics.Logins.WithLabelValues("password", metrics.LoginInvalidCredentials).Inc()
+		return nil, ErrInvalidCredentials
 	}
 
+	// The plaintext is only available now, so outdated hashes are upgraded here
+	if hasher.NeedsRehash(user.Password) {
+		s.rehashPassword(&user, req.Password)
+	}
+
+	s.bindPendingInvitations(user)
+	s.audit(models.AuditLoginSucceeded, user.ID, user.ID, map[string]interface{}{"method": "password"})
+	publish(s.db, events.UserLoggedIn{UserID: user.ID, Method: "password"})
+
+	return s.completeLogin(user, "password")
+}
+
+// completeLogin applies the checks every login method shares once the user
+// has proven who they are, and issues a token when none remain
+func (s *AuthService) completeLogin(user models.User, method string) (*models.AuthResponse, error) {
 	if !user.PhoneVerified {
 		// Regenerate verification code if needed
 		newCode := generateRandomCode()
@@ -85,16 +143,18 @@ func (s *AuthService) Login(req models.LoginRequest) (*models.AuthResponse, erro
 		}
 
 		// Resend verification code
-		if err := sendVerificationPhone(user.Phone, newCode); err != nil {
-			log.Printf("Failed to resend verification phone: %v", err)
+		if err := sendVerificationPhone(requestContext(s.client), user.PhoneNumber(), newCode); err != nil {
+			s.log.Error("Failed to resend verification code", "user_id", user.ID, "error", err)
 		}
 
+		metrics.Logins.WithLabelValues(method, metrics.LoginPhoneVerificationRequired).Inc()
 		return &models.AuthResponse{
 			NextFlow: "PhoneVerification",
 		}, nil
 	}
 
 	if user.MFAEnabled {
+		metrics.Logins.WithLabelValues(method, metrics.LoginMFARequired).Inc()
 		return &models.AuthResponse{
 			NextFlow: "TwoFactorGoogle",
 			PrevFlow: "Login",
@@ -107,16 +167,18 @@ func (s *AuthService) Login(req models.LoginRequest) (*models.AuthResponse, erro
 		return nil, fmt.Errorf("failed to generate token: %v", err)
 	}
 
+	metrics.Logins.WithLabelValues(method, metrics.LoginSuccess).Inc()
 	return &models.AuthResponse{
 		Token: token,
 	}, nil
 }
 
 func (s *AuthService) EnableMFA(userID string) (*models.MFAResponse, error) {
+	defer s.trace("AuthService.EnableMFA")()
 	var user models.User
 	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
@@ -135,6 +197,9 @@ func (s *AuthService) EnableMFA(userID string) (*models.MFAResponse, error) {
 		return nil, fmt.Errorf("failed to save MFA secret: %v", err)
 	}
 
+	s.audit(models.AuditMFAEnabled, user.ID, user.ID, nil)
+	publish(s.db, events.MFAEnabled{UserID: user.ID})
+
 	return &models.MFAResponse{
 		Secret:    key.Secret(),
 		QRCodeURL: key.URL(),
@@ -142,27 +207,31 @@ func (s *AuthService) EnableMFA(userID string) (*models.MFAResponse, error) {
 }
 
 func (s *AuthService) VerifyMFA(userID string, code string) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.VerifyMFA")()
 	var user models.User
 	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
 	if !user.MFAEnabled {
-		return nil, errors.New("MFA is not enabled for this user")
+		return nil, apperrors.Conflict("mfa_not_enabled", "MFA is not enabled for this user")
 	}
 
 	valid := totp.Validate(code, user.MFASecret)
 	if !valid {
-		return nil, errors.New("invalid MFA code")
+		s.audit(models.AuditMFAFailed, user.ID, user.ID, nil)
+		metrics.MFAVerifications.WithLabelValues("failure").Inc()
+		return nil, apperrors.Unauthorized("invalid_mfa_code", "invalid MFA code")
 	}
 
 	token, err := generateJWT(user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %v", err)
 	}
+	metrics.MFAVerifications.WithLabelValues("success").Inc()
 
 	return &models.AuthResponse{
 		Token:    token,
@@ -171,20 +240,21 @@ func (s *AuthService) VerifyMFA(userID string, code string) (*models.AuthRespons
 }
 
 func (s *AuthService) VerifyEmail(email, code string) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.VerifyEmail")()
 	var user models.User
 	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
 	if user.EmailVerified {
-		return nil, errors.New("email already verified")
+		return nil, apperrors.Conflict("email_already_verified", "email already verified")
 	}
 
 	if user.VerificationCode != code {
-		return nil, errors.New("invalid verification code")
+		return nil, ErrInvalidVerificationCode
 	}
 
 	// Update user's email verification status
@@ -194,6 +264,10 @@ func (s *AuthService) VerifyEmail(email, code string) (*models.AuthResponse, err
 		return nil, fmt.Errorf("failed to update user: %v", err)
 	}
 
+	s.bindPendingInvitations(user)
+	s.audit(models.AuditEmailVerified, user.ID, user.ID, nil)
+	publish(s.db, events.EmailVerified{UserID: user.ID})
+
 	// If MFA is enabled, return that as next flow
 	if user.MFAEnabled {
 		return &models.AuthResponse{
@@ -215,20 +289,21 @@ func (s *AuthService) VerifyEmail(email, code string) (*models.AuthResponse, err
 }
 
 func (s *AuthService) VerifyPhone(phone, code string) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.VerifyPhone")()
 	var user models.User
 	if err := s.db.Where("phone = ?", phone).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("database error: %v", err)
 	}
 
 	if user.PhoneVerified {
-		return nil, errors.New("phone already verified")
+		return nil, apperrors.Conflict("phone_already_verified", "phone already verified")
 	}
 
 	if user.VerificationCode != code {
-		return nil, errors.New("invalid verification code")
+		return nil, ErrInvalidVerificationCode
 	}
 
 	user.PhoneVerified = true
@@ -238,6 +313,9 @@ func (s *AuthService) VerifyPhone(phone, code string) (*models.AuthResponse, err
 		return nil, fmt.Errorf("failed to update user: %v", err)
 	}
 
+	s.audit(models.AuditPhoneVerified, user.ID, user.ID, nil)
+	publish(s.db, events.PhoneVerified{UserID: user.ID})
+
 	if user.MFAEnabled {
 		return &models.AuthResponse{
 			NextFlow: "TwoFactorGoogle",
@@ -257,6 +335,7 @@ func (s *AuthService) VerifyPhone(phone, code string) (*models.AuthResponse, err
 }
 
 func (s *AuthService) ForgotPassword(email string) error {
+	defer s.trace("AuthService.ForgotPassword")()
 	var user models.User
 	if err := s.db.Where("email = ?", email).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -278,9 +357,13 @@ func (s *AuthService) ForgotPassword(email string) error {
 		return fmt.Errorf("failed to save reset code: %v", err)
 	}
 
+	// Anyone can ask for a reset, so there is no actor
+	s.audit(models.AuditPasswordResetRequested, user.ID, "", nil)
+	metrics.PasswordResets.WithLabelValues("requested").Inc()
+
 	// Send password reset email
-	if err := sendPasswordResetEmail(user.Email, resetCode); err != nil {
-		log.Printf("Failed to send password reset email: %v", err)
+	if err := sendPasswordResetEmail(requestContext(s.client), user.Email, resetCode); err != nil {
+		s.log.Error("Failed to send password reset email", "user_id", user.ID, "error", err)
 		return errors.New("failed to send password reset email")
 	}
 
@@ -288,46 +371,191 @@ func (s *AuthService) ForgotPassword(email string) error {
 }
 
 func (s *AuthService) ResetPassword(code, newPassword string) error {
+	defer s.trace("AuthService.ResetPassword")()
 	var user models.User
 	if err := s.db.Where("reset_password_code = ?", code).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return errors.New("invalid reset code")
+			return apperrors.Validation("invalid_reset_code", "invalid reset code")
 		}
 		return fmt.Errorf("database error: %v", err)
 	}
 
 	// Check if reset code has expired
 	if user.ResetPasswordExpiry != nil && user.ResetPasswordExpiry.Before(time.Now()) {
-		return errors.New("reset code has expired")
+		return apperrors.Validation("reset_code_expired", "reset code has expired")
+	}
+
+	// Clear reset code and its expiry along with the new password
+	user.ResetPasswordCode = ""
+	user.ResetPasswordExpiry = nil
+
+	if err := s.setPassword(&user, newPassword); err != nil {
+		return err
+	}
+
+	s.audit(models.AuditPasswordReset, user.ID, user.ID, nil)
+	publish(s.db, events.PasswordReset{UserID: user.ID})
+	metrics.PasswordResets.WithLabelValues("completed").Inc()
+	return nil
+}
+
+func (s *AuthService) ChangePassword(userID, currentPassword, newPassword string) error {
+	defer s.trace("AuthService.ChangePassword")()
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("database error: %v", err)
+	}
+
+	// Whoever holds the token may not know the password, so failures are
+	// recorded like failed logins
+	if ok, _ := GetPasswordHasher().Verify(currentPassword, user.Password); !ok {
+		s.audit(models.AuditPasswordChangeFailed, user.ID, user.ID, map[string]interface{}{
+			"reason": "invalid_password",
+		})
+		return apperrors.Validation("current_password_incorrect", "current password is incorrect")
+	}
+
+	if err := s.setPassword(&user, newPassword); err != nil {
+		return err
+	}
+
+	s.audit(models.AuditPasswordChanged, user.ID, user.ID, nil)
+	publish(s.db, events.PasswordChanged{UserID: user.ID})
+	return nil
+}
+
+// Helper functions
+
+// setPassword checks the new password against the policy, keeps the old hash
+// in the password history and saves the user
+func (s *AuthService) setPassword(user *models.User, newPassword string) error {
+	policy := GetPasswordPolicy()
+
+	// The current password is the most recent of the last HistorySize, the
+	// history holds the ones before it
+	keep := policy.HistorySize - 1
+	if keep < 0 {
+		keep = 0
+	}
+
+	var previousHashes []string
+	if policy.HistorySize > 0 {
+		previousHashes = append(previousHashes, user.Password)
+	}
+	if keep > 0 {
+		var history []models.PasswordHistory
+		if err := s.db.Where("user_id = ?", user.ID).Order("created_at desc").
+			Limit(keep).Find(&history).Error; err != nil {
+			return fmt.Errorf("database error: %v", err)
+		}
+		for _, h := range history {
+			previousHashes = append(previousHashes, h.Hash)
+		}
 	}
 
-	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err := policy.Validate(PasswordCandidate{
+		Password:       newPassword,
+		Username:       user.Username,
+		Email:          user.Email,
+		PreviousHashes: previousHashes,
+	}); err != nil {
+		return err
+	}
+
+	hashedPassword, err := GetPasswordHasher().Hash(newPassword)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %v", err)
 	}
 
-	// Update user's password and clear reset code
-	user.Password = string(hashedPassword)
-	user.ResetPasswordCode = ""
-	user.ResetPasswordExpiry = nil // Clear the expiry
+	tx := s.db.Begin()
 
-	if err := s.db.Save(&user).Error; err != nil {
+	if keep > 0 {
+		if err := tx.Create(&models.PasswordHistory{UserID: user.ID, Hash: user.Password}).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record password history: %v", err)
+		}
+	}
+	if err := prunePasswordHistory(tx, user.ID, keep); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prune password history: %v", err)
+	}
+
+	user.Password = hashedPassword
+	if err := tx.Save(user).Error; err != nil {
+		tx.Rollback()
 		return fmt.Errorf("failed to update password: %v", err)
 	}
 
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
 	return nil
 }
 
-// Helper functions
+// prunePasswordHistory deletes all but the user's keep most recent previous
+// passwords
+func prunePasswordHistory(db *gorm.DB, userID string, keep int) error {
+	var ids []string
+	if err := db.Model(&models.PasswordHistory{}).Where("user_id = ?", userID).
+		Order("created_at desc").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) <= keep {
+		return nil
+	}
+	return db.Where("id IN (?)", ids[keep:]).Delete(&models.PasswordHistory{}).Error
+}
+
+// rehashPassword replaces the stored hash with one made by the current
+// settings. Failing to do so does not fail the login.
+func (s *AuthService) rehashPassword(user *models.User, password string) {
+	hashedPassword, err := GetPasswordHasher().Hash(password)
+	if err != nil {
+		s.log.Error("Failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	if err := s.db.Model(user).UpdateColumn("password", hashedPassword).Error; err != nil {
+		s.log.Error("Failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+	user.Password = hashedPassword
+}
+
+// audit records an event of the user's own security. actorID is empty when
+// whoever caused it has not proven to be the user, userID when the user is
+// not known either.
+func (s *AuthService) audit(action models.AuditAction, userID, actorID string, metadata map[string]interface{}) {
+	event := models.AuditEvent{Action: action}
+	if userID != "" {
+		event.TargetType = "user"
+		event.TargetID = userID
+	}
+	if actorID != "" {
+		event.ActorType = models.PrincipalUser
+		event.ActorID = actorID
+	}
+	recordAudit(s.db, s.client, event, metadata)
+}
 
+func (s *AuthService) bindPendingInvitations(user models.User) {
+	accountService := &AccountService{db: s.db, log: s.log}
+	if err := accountService.BindPendingInvitations(user); err != nil {
+		s.log.Error("Failed to bind pending invitations", "user_id", user.ID, "error", err)
+	}
+}
+
+// generateRandomCode returns a 6-digit code. Codes stand in for a password
+// until they expire, so they come from crypto/rand
 func generateRandomCode() string {
-	// Generate a 6-digit random code
-	code := make([]byte, 6)
-	for i := range code {
-		code[i] = byte(rand.Intn(10) + '0')
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
 	}
-	return string(code)
+	return fmt.Sprintf("%06d", n.Int64())
 }
 
 func generateJWT(user models.User) (string, error) {
@@ -335,6 +563,7 @@ func generateJWT(user models.User) (string, error) {
 	claims := token.Claims.(jwt.MapClaims)
 	claims["user_id"] = user.ID
 	claims["email"] = user.Email
+	claims["auth_time"] = time.Now().Unix()               // Tokens are only issued at login
 	claims["exp"] = time.Now().Add(time.Hour * 24).Unix() // Token expires in 24 hours
 
 	tokenString, err := token.SignedString([]byte(config.JWTSecretKey))
@@ -345,16 +574,27 @@ func generateJWT(user models.User) (string, error) {
 	return tokenString, nil
 }
 
-func sendVerificationPhone(phone, code string) error {
-	// smsService := NewSMSService()
-	// return smsService.SendVerificationCode(phone, code, "Your verification code is: %s")
-	log.Printf("Verification code for %s: %s", phone, code)
+// sendVerificationPhone texts the code. Codes are never logged, without an
+// SMS provider the text is dropped.
+func sendVerificationPhone(ctx context.Context, phone, code string) (err error) {
+	smsService := NewSMSService()
+	if !smsService.Configured() {
+		slog.Warn("Verification code not sent, no SMS provider is configured")
+		return nil
+	}
+
+	_, span := tracer.Start(ctx, "sms.send", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
+
+	if err := smsService.SendVerificationCode(phone, code, "Your verification code is: %s"); err != nil {
+		metrics.MessageDeliveryFailures.WithLabelValues(metrics.ChannelSMS).Inc()
+		return err
+	}
 	return nil
 }
 
-func sendPasswordResetEmail(email, code string) error {
-	// TODO: Implement email sending logic
-	// For now, just log the code
-	log.Printf("Password reset code for %s: %s", email, code)
-	return nil
+func sendPasswordResetEmail(ctx context.Context, email, code string) error {
+	body := fmt.Sprintf("Use this code to reset your password:\n\n%s\n\n"+
+		"If you did not ask for a password reset, you can ignore this email.\n", code)
+	return sendNotificationEmail(ctx, email, "Reset your password", body)
 }
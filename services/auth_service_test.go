@@ -0,0 +1,34 @@
+package services
+
+import (
+	"go-backend/models"
+	"log/slog"
+	"testing"
+)
+
+func TestChangePasswordRecordsWrongCurrentPassword(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AuthService{db: db, log: slog.Default()}
+
+	hash, err := GetPasswordHasher().Hash("Correct-horse-battery-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: "jane", Email: "jane@example.com", Password: hash}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ChangePassword(user.ID, "guess", "Another-horse-battery-2"); err == nil {
+		t.Fatal("password changed with a wrong current password")
+	}
+
+	var event models.AuditEvent
+	if err := db.Where("action = ?", models.AuditPasswordChangeFailed).First(&event).Error; err != nil {
+		t.Fatalf("failed attempt was not audited: %v", err)
+	}
+	if event.TargetID != user.ID || event.ChainID != user.ID {
+		t.Errorf("event target = %q in chain %q, want the user's own chain", event.TargetID, event.ChainID)
+	}
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/models"
+	"go-backend/tracing"
+	"log/slog"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type ContactChangeService struct {
+	db     *gorm.DB
+	client models.ClientInfo
+	log    *slog.Logger
+}
+
+func NewContactChangeService() *ContactChangeService {
+	return &ContactChangeService{db: config.DB, log: slog.Default()}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *ContactChangeService) WithClient(client models.ClientInfo) *ContactChangeService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
+}
+
+// RequestEmailChange sends a confirmation code to the new address. The
+// current address stays active until the code is confirmed.
+func (s *ContactChangeService) RequestEmailChange(userID string, req models.ChangeEmailRequest) error {
+	user, err := s.reauthenticate(userID, req.Password)
+	if err != nil {
+		return err
+	}
+
+	newEmail := normalizeEmail(req.NewEmail)
+	if newEmail == normalizeEmail(user.Email) {
+		return apperrors.Validation("email_unchanged", "new email is the same as the current one")
+	}
+
+	var existingUser models.User
+	if err := s.db.Where("email = ?", newEmail).First(&existingUser).Error; err == nil {
+		return apperrors.Conflict("email_in_use", "email is already in use")
+	}
+
+	change, err := s.createChange(user, models.ContactEmail, user.Email, user.EmailVerified, newEmail)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Your code to confirm this email address is: %s\n\nIt expires in %d minutes.\n",
+		change.Code, int(config.ContactChangeCodeTTL.Minutes()))
+	if err := sendNotificationEmail(requestContext(s.client), newEmail, "Confirm your new email address", body); err != nil {
+		s.log.Error("Failed to send email change code", "user_id", userID, "error", err)
+		return errors.New("failed to send confirmation code")
+	}
+
+	return nil
+}
+
+// RequestPhoneChange texts a confirmation code to the new number. The
+// current number stays active until the code is confirmed.
+func (s *ContactChangeService) RequestPhoneChange(userID string, req models.ChangePhoneRequest) error {
+	user, err := s.reauthenticate(userID, req.Password)
+	if err != nil {
+		return err
+	}
+
+	if req.NewPhone == user.PhoneNumber() {
+		return apperrors.Validation("phone_unchanged", "new phone is the same as the current one")
+	}
+
+	var existingUser models.User
+	if err := s.db.Where("phone = ?", req.NewPhone).First(&existingUser).Error; err == nil {
+		return apperrors.Conflict("phone_in_use", "phone is already in use")
+	}
+
+	change, err := s.createChange(user, models.ContactPhone, user.PhoneNumber(), user.PhoneVerified, req.NewPhone)
+	if err != nil {
+		return err
+	}
+
+	if err := sendVerificationPhone(requestContext(s.client), req.NewPhone, change.Code); err != nil {
+		s.log.Error("Failed to send phone change code", "user_id", userID, "error", err)
+		return errors.New("failed to send confirmation code")
+	}
+
+	return nil
+}
+
+// ConfirmChange applies the pending change once the code sent to the new
+// value is presented, and offers the old email address a way to undo it
+func (s *ContactChangeService) ConfirmChange(userID string, kind models.ContactKind, code string) (*models.User, error) {
+	var change models.ContactChange
+	if err := s.db.Where("user_id = ? AND kind = ? AND confirmed_at IS NULL AND reverted_at IS NULL",
+		userID, kind).Order("created_at desc").First(&change).Error; err != nil {
+		return nil, apperrors.NotFound("no_pending_change", "no pending change")
+	}
+
+	if change.ExpiresAt.Before(time.Now()) {
+		return nil, apperrors.Validation("confirmation_code_expired", "confirmation code has expired")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(change.Code), []byte(code)) != 1 {
+		return nil, s.failedAttempt(&change)
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	// The new value may have been taken since the change was requested
+	var existingUser models.User
+	if err := s.db.Where(string(kind)+" = ? AND id <> ?", change.NewValue, userID).First(&existingUser).Error; err == nil {
+		return nil, apperrors.Conflict(string(kind)+"_in_use", fmt.Sprintf("%s is already in use", kind))
+	}
+
+	// Notify the email address the user had before this change
+	notifyEmail := user.Email
+
+	now := time.Now()
+	revertExpiresAt := now.Add(config.ContactChangeRevertTTL)
+	change.Code = ""
+	change.ConfirmedAt = &now
+	change.RevertToken = generateSecureToken(32)
+	change.RevertExpiresAt = &revertExpiresAt
+
+	// Confirming the code proves control of the new value
+	if kind == models.ContactEmail {
+		user.Email = change.NewValue
+		user.EmailVerified = true
+	} else {
+		newPhone := change.NewValue
+		user.Phone = &newPhone
+		user.PhoneVerified = true
+	}
+
+	tx := s.db.Begin()
+	if err := tx.Save(&user).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update user: %v", err)
+	}
+	if err := tx.Save(&change).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	action := models.AuditEmailChanged
+	if kind == models.ContactPhone {
+		action = models.AuditPhoneChanged
+	}
+	authService := &AuthService{db: s.db, client: s.client, log: s.log}
+	if kind == models.ContactEmail {
+		authService.bindPendingInvitations(user)
+	}
+	authService.audit(action, user.ID, user.ID, map[string]interface{}{
+		"old_value": change.OldValue,
+		"new_value": change.NewValue,
+	})
+
+	publish(s.db, events.ContactChanged{Change: change, NotifyEmail: notifyEmail})
+
+	return &user, nil
+}
+
+// RevertChange restores the previous value of a confirmed change using the
+// token emailed to the old address
+func (s *ContactChangeService) RevertChange(token string) error {
+	var change models.ContactChange
+	if err := s.db.Where("revert_token = ? AND confirmed_at IS NOT NULL AND reverted_at IS NULL", token).
+		First(&change).Error; err != nil {
+		return ErrInvalidRevertLink
+	}
+
+	if change.RevertExpiresAt == nil || change.RevertExpiresAt.Before(time.Now()) {
+		return ErrInvalidRevertLink
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", change.UserID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	var existingUser models.User
+	if err := s.db.Where(string(change.Kind)+" = ? AND id <> ?", change.OldValue, user.ID).First(&existingUser).Error; err == nil {
+		return apperrors.Conflict(string(change.Kind)+"_in_use", fmt.Sprintf("previous %s is now used by another user", change.Kind))
+	}
+
+	if change.Kind == models.ContactEmail {
+		user.Email = change.OldValue
+		user.EmailVerified = change.OldVerified
+	} else {
+		user.Phone = nil
+		if change.OldValue != "" {
+			oldPhone := change.OldValue
+			user.Phone = &oldPhone
+		}
+		user.PhoneVerified = change.OldVerified
+	}
+
+	now := time.Now()
+	change.RevertedAt = &now
+	change.RevertToken = ""
+
+	tx := s.db.Begin()
+	if err := tx.Save(&user).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+	if err := tx.Save(&change).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	// Only the old email address received the link, not necessarily the user
+	authService := &AuthService{db: s.db, client: s.client, log: s.log}
+	authService.audit(models.AuditContactChangeReverted, user.ID, "", map[string]interface{}{
+		"kind":     change.Kind,
+		"restored": change.OldValue,
+	})
+
+	return nil
+}
+
+func (s *ContactChangeService) reauthenticate(userID, password string) (*models.User, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if ok, _ := GetPasswordHasher().Verify(password, user.Password); !ok {
+		return nil, ErrInvalidPassword
+	}
+
+	return &user, nil
+}
+
+// createChange replaces any unconfirmed change of the same kind
+func (s *ContactChangeService) createChange(user *models.User, kind models.ContactKind, oldValue string, oldVerified bool, newValue string) (*models.ContactChange, error) {
+	if err := s.db.Where("user_id = ? AND kind = ? AND confirmed_at IS NULL", user.ID, kind).
+		Delete(&models.ContactChange{}).Error; err != nil {
+		return nil, err
+	}
+
+	change := models.ContactChange{
+		UserID:      user.ID,
+		Kind:        kind,
+		OldValue:    oldValue,
+		OldVerified: oldVerified,
+		NewValue:    newValue,
+		Code:        generateRandomCode(),
+		ExpiresAt:   time.Now().Add(config.ContactChangeCodeTTL),
+	}
+	if err := s.db.Create(&change).Error; err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+// failedAttempt counts a wrong code, dropping the change once there have been
+// too many so the code cannot be guessed
+func (s *ContactChangeService) failedAttempt(change *models.ContactChange) error {
+	if err := s.db.Model(change).UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + 1")).Error; err != nil {
+		return err
+	}
+
+	var attempts int
+	if err := s.db.Model(&models.ContactChange{}).Where("id = ?", change.ID).
+		Select("failed_attempts").Row().Scan(&attempts); err != nil {
+		return err
+	}
+
+	if attempts >= config.ContactChangeMaxAttempts {
+		if err := s.db.Delete(change).Error; err != nil {
+			return err
+		}
+		return apperrors.Validation("too_many_attempts", "too many invalid codes, request a new change")
+	}
+	return apperrors.Validation("invalid_confirmation_code", "invalid confirmation code")
+}
+
+// notifyContactChange emails the address the user had before the change a
+// link to undo it
+func notifyContactChange(email string, change *models.ContactChange) {
+	link := fmt.Sprintf("%s/contact-changes/revert?token=%s", appBaseURL(), change.RevertToken)
+	body := fmt.Sprintf("The %s on your account was changed to %s.\n\n"+
+		"If you did not make this change, open the link below to undo it:\n\n%s\n",
+		change.Kind, change.NewValue, link)
+
+	if err := sendNotificationEmail(context.Background(), email, "Your "+string(change.Kind)+" was changed", body); err != nil {
+		slog.Error("Failed to send change notification", "user_id", change.UserID, "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+type EmailService struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewEmailService() *EmailService {
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	return &EmailService{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     port,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// Configured reports whether an SMTP server has been set up
+func (s *EmailService) Configured() bool {
+	return s.host != "" && s.from != ""
+}
+
+func (s *EmailService) SendEmail(to, subject, body string) error {
+	if !s.Configured() {
+		return errors.New("email service is not configured")
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + headerValue(to),
+		"Subject: " + mime.QEncoding.Encode("utf-8", headerValue(subject)),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+
+	return nil
+}
+
+// headerValue keeps user supplied text, such as account names in subjects,
+// from ending a header line and injecting headers of its own
+func headerValue(value string) string {
+	return strings.Join(strings.FieldsFunc(value, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	}), " ")
+}
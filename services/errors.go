@@ -0,0 +1,34 @@
+package services
+
+import "go-backend/apperrors"
+
+// Errors returned in more than one place, the others are created where
+// they are returned
+var (
+	ErrNotAuthorized           = apperrors.Forbidden("not_authorized", "not authorized")
+	ErrUserNotFound            = apperrors.NotFound("user_not_found", "user not found")
+	ErrAccountNotFound         = apperrors.NotFound("account_not_found", "account not found")
+	ErrAlreadyMember           = apperrors.Conflict("already_member", "user is already a member")
+	ErrAlreadyInvited          = apperrors.Conflict("already_invited", "user is already invited")
+	ErrInvitationNotFound      = apperrors.NotFound("invitation_not_found", "invitation not found or already processed")
+	ErrAccessRequestNotFound   = apperrors.NotFound("access_request_not_found", "access request not found or already processed")
+	ErrJoinLinkNotFound        = apperrors.NotFound("join_link_not_found", "join link not found")
+	ErrJoinLinkInvalid         = apperrors.Conflict("join_link_invalid", "join link is no longer valid")
+	ErrDomainNotFound          = apperrors.NotFound("domain_not_found", "domain not found")
+	ErrInvalidCredentials      = apperrors.Unauthorized("invalid_credentials", "invalid credentials")
+	ErrInvalidPassword         = apperrors.Unauthorized("invalid_password", "invalid password")
+	ErrReauthenticationNeeded  = apperrors.Forbidden("reauthentication_required", "enter your password, or sign in again with any method and retry within 10 minutes")
+	ErrInvalidVerificationCode = apperrors.Validation("invalid_verification_code", "invalid verification code")
+	ErrInvalidLoginLink        = apperrors.Unauthorized("invalid_login_link", "invalid or expired login link")
+	ErrInvalidLoginAttempt     = apperrors.Unauthorized("invalid_login_attempt", "invalid or expired login attempt")
+	ErrInvalidAPIKey           = apperrors.Unauthorized("invalid_api_key", "invalid API key")
+	ErrInvalidSSOCode          = apperrors.Unauthorized("invalid_code", "invalid or expired code")
+	ErrInvalidSAMLResponse     = apperrors.Unauthorized("invalid_saml_response", "invalid SAML response")
+	ErrInvalidRevertLink       = apperrors.Validation("invalid_revert_link", "invalid or expired revert link")
+	ErrProviderSignInFailed    = apperrors.Unauthorized("provider_sign_in_failed", "sign in with the provider failed")
+	ErrUnknownProvider         = apperrors.NotFound("unknown_provider", "unknown provider")
+
+	// OAuth2 token endpoint errors, their codes are the ones of RFC 6749
+	ErrInvalidClient = apperrors.Unauthorized("invalid_client", "invalid_client")
+	ErrInvalidScope  = apperrors.Validation("invalid_scope", "invalid_scope")
+)
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"go-backend/events"
+	"go-backend/models"
+	"log/slog"
+
+	"github.com/jinzhu/gorm"
+)
+
+const pendingEventsKey = "events:pending"
+
+// RegisterSubscribers attaches the side effects of the services' events to
+// the bus: notifications and webhooks.
+//
+// Audit events are not among them. They are written in the transaction of
+// the change they record, so one is never kept without the other, while
+// subscribers only run once the transaction committed. Emails that carry a
+// code or link the caller is waiting for, such as magic links and password
+// resets, are part of the request that asked for them and are sent there.
+func RegisterSubscribers(bus *events.Bus) {
+	// Texting the code can take seconds, registration does not wait for it
+	events.SubscribeAsync(bus, func(event events.UserRegistered) {
+		if err := sendVerificationPhone(context.Background(), event.User.PhoneNumber(), event.User.VerificationCode); err != nil {
+			slog.Error("Failed to send verification code", "user_id", event.User.ID, "error", err)
+		}
+	})
+
+	// Notifications, sending them can take seconds
+	events.SubscribeAsync(bus, func(event events.InvitationCreated) {
+		deliverInvitation(event.Invitation, event.AccountName)
+	})
+	events.SubscribeAsync(bus, func(event events.InvitationResent) {
+		deliverInvitation(event.Invitation, event.AccountName)
+	})
+	events.SubscribeAsync(bus, func(event events.AccessRequestCreated) {
+		NewAccountService().notifyManagers(&event.AccessRequest)
+	})
+	events.SubscribeAsync(bus, func(event events.AccessRequestReviewed) {
+		NewAccountService().notifyRequester(&event.AccessRequest)
+	})
+	events.SubscribeAsync(bus, func(event events.ContactChanged) {
+		notifyContactChange(event.NotifyEmail, &event.Change)
+	})
+	events.SubscribeAsync(bus, func(event events.UserDeletionScheduled) {
+		notifyDeletionScheduled(event.Email, &event.Deletion)
+	})
+
+	events.Subscribe(bus, func(event events.AccountCreated) {
+		NewAccountService().emitWebhook(event.Account.ID, models.WebhookAccountCreated, event.Account)
+	})
+	events.Subscribe(bus, func(event events.InvitationCreated) {
+		NewAccountService().emitWebhook(event.Invitation.AccountID, models.WebhookInvitationCreated, event.Invitation)
+	})
+	events.Subscribe(bus, func(event events.InvitationAccepted) {
+		NewAccountService().emitWebhook(event.Invitation.AccountID, models.WebhookInvitationAccepted, event.Invitation)
+	})
+	events.Subscribe(bus, func(event events.InvitationDeclined) {
+		NewAccountService().emitWebhook(event.Invitation.AccountID, models.WebhookInvitationDeclined, event.Invitation)
+	})
+	events.Subscribe(bus, func(event events.MembershipCreated) {
+		NewAccountService().emitWebhook(event.AccountID, models.WebhookMemberJoined, models.WebhookMemberData{
+			UserID: event.UserID,
+			Role:   event.Role,
+			Via:    event.Via,
+		})
+	})
+}
+
+// Helper functions
+
+// publish hands the event to the subscribers. Within a transaction started
+// with beginTx it is held back until the transaction commits, so subscribers
+// never see changes that were rolled back.
+func publish(db *gorm.DB, event events.Event) {
+	if pending, ok := db.Get(pendingEventsKey); ok {
+		pending.(*events.Batch).Add(event)
+		return
+	}
+	events.Default.Publish(event)
+}
+
+// beginTx starts a transaction that publishes its events in commitTx
+func beginTx(db *gorm.DB) *gorm.DB {
+	return db.Begin().Set(pendingEventsKey, events.Default.Batch())
+}
+
+func commitTx(tx *gorm.DB) error {
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if pending, ok := tx.Get(pendingEventsKey); ok {
+		pending.(*events.Batch).Publish()
+	}
+	return nil
+}
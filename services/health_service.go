@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+
+	"github.com/jinzhu/gorm"
+)
+
+type HealthService struct {
+	db  *gorm.DB
+	log *slog.Logger
+}
+
+func NewHealthService() *HealthService {
+	return &HealthService{db: config.DB, log: slog.Default()}
+}
+
+// Readiness checks that the database answers and has been migrated, and
+// that email and SMS can be sent, so the server can handle any request
+func (s *HealthService) Readiness(ctx context.Context) (*models.ReadinessResponse, bool) {
+	checks := map[string]string{
+		"database":   models.HealthOK,
+		"migrations": models.HealthOK,
+		"email":      models.HealthOK,
+		"sms":        models.HealthOK,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.ReadinessCheckTimeout)
+	defer cancel()
+	if err := s.db.DB().PingContext(ctx); err != nil {
+		s.log.Warn("Database ping failed", "error", err)
+		checks["database"] = "unreachable"
+	}
+
+	if !config.Migrated() {
+		checks["migrations"] = "not applied"
+	}
+	if !NewEmailService().Configured() {
+		checks["email"] = models.HealthNotConfigured
+	}
+	if !NewSMSService().Configured() {
+		checks["sms"] = models.HealthNotConfigured
+	}
+
+	ready := true
+	for _, result := range checks {
+		if result != models.HealthOK {
+			ready = false
+		}
+	}
+
+	response := &models.ReadinessResponse{Status: "ready", Checks: checks}
+	if !ready {
+		response.Status = "not ready"
+	}
+	return response, ready
+}
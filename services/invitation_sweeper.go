@@ -0,0 +1,32 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StartInvitationSweeper periodically marks overdue invitations as expired
+// until stop is closed, then marks done once a run in progress finished
+func StartInvitationSweeper(interval time.Duration, stop <-chan struct{}, done *sync.WaitGroup) {
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				expired, err := NewAccountService().ExpireInvitations()
+				if err != nil {
+					slog.Error("Failed to expire invitations", "error", err)
+				} else if expired > 0 {
+					slog.Info("Expired invitations", "count", expired)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,439 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/models"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Also returned when the lookup fails, an unknown name is the usual reason
+var errVerificationRecordNotFound = apperrors.Conflict("verification_record_not_found", "verification record not found")
+
+func (s *AccountService) CreateJoinLink(accountID string, principal models.Principal, req models.CreateJoinLinkRequest) (*models.JoinLinkResponse, error) {
+	defer s.trace("AccountService.CreateJoinLink")()
+	if err := s.authorize(accountID, principal, models.PermJoinLinksManage); err != nil {
+		return nil, err
+	}
+
+	role := req.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	link := models.JoinLink{
+		AccountID:   accountID,
+		Code:        generateSecureToken(24),
+		CreatedByID: principal.ID,
+		Role:        role,
+		MaxUses:     req.MaxUses,
+	}
+	if req.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		link.ExpiresAt = &expiresAt
+	}
+
+	if err := s.db.Create(&link).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditJoinLinkCreated, "join_link", link.ID, map[string]interface{}{
+		"role":     role,
+		"max_uses": link.MaxUses,
+	})
+
+	return joinLinkResponse(link), nil
+}
+
+func (s *AccountService) ListJoinLinks(accountID string, principal models.Principal) (*models.JoinLinkListResponse, error) {
+	defer s.trace("AccountService.ListJoinLinks")()
+	if err := s.authorize(accountID, principal, models.PermJoinLinksManage); err != nil {
+		return nil, err
+	}
+
+	var links []models.JoinLink
+	if err := s.db.Where("account_id = ?", accountID).Order("created_at desc").Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.JoinLinkListResponse{JoinLinks: []models.JoinLinkResponse{}}
+	for _, link := range links {
+		response.JoinLinks = append(response.JoinLinks, *joinLinkResponse(link))
+	}
+	return response, nil
+}
+
+func (s *AccountService) RevokeJoinLink(accountID, linkID string, principal models.Principal) error {
+	defer s.trace("AccountService.RevokeJoinLink")()
+	if err := s.authorize(accountID, principal, models.PermJoinLinksManage); err != nil {
+		return err
+	}
+
+	var link models.JoinLink
+	if err := s.db.Where("id = ? AND account_id = ?", linkID, accountID).First(&link).Error; err != nil {
+		return ErrJoinLinkNotFound
+	}
+
+	if link.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	link.RevokedAt = &now
+	if err := s.db.Save(&link).Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditJoinLinkRevoked, "join_link", link.ID, nil)
+	return nil
+}
+
+// JoinWithLink adds the user to the account a join link belongs to
+func (s *AccountService) JoinWithLink(code, userID string) (*models.JoinAccountResponse, error) {
+	defer s.trace("AccountService.JoinWithLink")()
+	var link models.JoinLink
+	if err := s.db.Where("code = ?", code).First(&link).Error; err != nil {
+		return nil, ErrJoinLinkNotFound
+	}
+
+	if !link.Usable() {
+		return nil, ErrJoinLinkInvalid
+	}
+
+	if s.isMember(link.AccountID, userID) {
+		return nil, ErrAlreadyMember
+	}
+
+	tx := s.db.Begin()
+
+	// Guard the use count against concurrent joins
+	query := tx.Model(&models.JoinLink{}).Where("id = ?", link.ID)
+	if link.MaxUses > 0 {
+		query = query.Where("uses < max_uses")
+	}
+	result := query.UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ErrJoinLinkInvalid
+	}
+
+	if err := addMember(tx, link.AccountID, userID, link.Role); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	s.memberJoined(link.AccountID, models.UserPrincipal(userID), userID, link.Role, "join_link")
+
+	return &models.JoinAccountResponse{
+		Status:    "joined",
+		AccountID: link.AccountID,
+		Role:      link.Role,
+	}, nil
+}
+
+func (s *AccountService) AddDomain(accountID string, principal models.Principal, req models.AddAccountDomainRequest) (*models.AccountDomainResponse, error) {
+	defer s.trace("AccountService.AddDomain")()
+	if err := s.authorize(accountID, principal, models.PermDomainsManage); err != nil {
+		return nil, err
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), "."))
+	for _, public := range config.PublicEmailDomains {
+		if domain == public {
+			return nil, apperrors.Validation("public_email_domain", "public email domains cannot be claimed")
+		}
+	}
+
+	var existing models.AccountDomain
+	if err := s.db.Where("account_id = ? AND domain = ?", accountID, domain).First(&existing).Error; err == nil {
+		return nil, apperrors.Conflict("domain_exists", "domain already added to this account")
+	}
+
+	joinMode := req.JoinMode
+	if joinMode == "" {
+		joinMode = models.JoinModeRequest
+	}
+	role := req.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	accountDomain := models.AccountDomain{
+		AccountID:         accountID,
+		Domain:            domain,
+		JoinMode:          joinMode,
+		Role:              role,
+		VerificationToken: generateSecureToken(24),
+	}
+
+	if err := s.db.Create(&accountDomain).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditDomainAdded, "domain", accountDomain.ID, map[string]interface{}{
+		"domain":    domain,
+		"join_mode": joinMode,
+		"role":      role,
+	})
+
+	return accountDomainResponse(accountDomain), nil
+}
+
+func (s *AccountService) ListDomains(accountID string, principal models.Principal) (*models.AccountDomainListResponse, error) {
+	defer s.trace("AccountService.ListDomains")()
+	if err := s.authorize(accountID, principal, models.PermDomainsManage); err != nil {
+		return nil, err
+	}
+
+	var domains []models.AccountDomain
+	if err := s.db.Where("account_id = ?", accountID).Order("domain").Find(&domains).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.AccountDomainListResponse{Domains: []models.AccountDomainResponse{}}
+	for _, domain := range domains {
+		response.Domains = append(response.Domains, *accountDomainResponse(domain))
+	}
+	return response, nil
+}
+
+// VerifyDomain checks the domain's DNS for the verification TXT record
+func (s *AccountService) VerifyDomain(accountID, domainID string, principal models.Principal) (*models.AccountDomainResponse, error) {
+	defer s.trace("AccountService.VerifyDomain")()
+	if err := s.authorize(accountID, principal, models.PermDomainsManage); err != nil {
+		return nil, err
+	}
+
+	var accountDomain models.AccountDomain
+	if err := s.db.Where("id = ? AND account_id = ?", domainID, accountID).First(&accountDomain).Error; err != nil {
+		return nil, ErrDomainNotFound
+	}
+
+	if accountDomain.Verified {
+		return accountDomainResponse(accountDomain), nil
+	}
+
+	// A domain can only be claimed by one account at a time
+	var claimed models.AccountDomain
+	if err := s.db.Where("domain = ? AND verified = ? AND id <> ?", accountDomain.Domain, true, accountDomain.ID).First(&claimed).Error; err == nil {
+		return nil, apperrors.Conflict("domain_claimed", "domain is already claimed by another account")
+	}
+
+	records, err := net.LookupTXT(domainVerificationRecord(accountDomain.Domain))
+	if err != nil {
+		return nil, errVerificationRecordNotFound.Wrap(err)
+	}
+
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == accountDomain.VerificationToken {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errVerificationRecordNotFound
+	}
+
+	now := time.Now()
+	accountDomain.Verified = true
+	accountDomain.VerifiedAt = &now
+	if err := s.db.Save(&accountDomain).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditDomainVerified, "domain", accountDomain.ID, map[string]interface{}{
+		"domain": accountDomain.Domain,
+	})
+
+	return accountDomainResponse(accountDomain), nil
+}
+
+func (s *AccountService) RemoveDomain(accountID, domainID string, principal models.Principal) error {
+	defer s.trace("AccountService.RemoveDomain")()
+	if err := s.authorize(accountID, principal, models.PermDomainsManage); err != nil {
+		return err
+	}
+
+	result := s.db.Where("id = ? AND account_id = ?", domainID, accountID).Delete(&models.AccountDomain{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDomainNotFound
+	}
+
+	s.audit(accountID, principal, models.AuditDomainRemoved, "domain", domainID, nil)
+	return nil
+}
+
+// DiscoverAccounts lists the accounts the user can join through the
+// verified domain of their email address
+func (s *AccountService) DiscoverAccounts(userID string) (*models.DiscoverableAccountListResponse, error) {
+	defer s.trace("AccountService.DiscoverAccounts")()
+	response := &models.DiscoverableAccountListResponse{Accounts: []models.DiscoverableAccount{}}
+
+	user, domain, err := s.verifiedEmailDomain(userID)
+	if err != nil {
+		return response, nil
+	}
+
+	var domains []models.AccountDomain
+	if err := s.db.Where("domain = ? AND verified = ?", domain, true).Find(&domains).Error; err != nil {
+		return nil, err
+	}
+
+	for _, accountDomain := range domains {
+		if s.isMember(accountDomain.AccountID, user.ID) {
+			continue
+		}
+
+		var account models.Account
+		if err := s.db.First(&account, "id = ?", accountDomain.AccountID).Error; err != nil {
+			continue
+		}
+
+		response.Accounts = append(response.Accounts, models.DiscoverableAccount{
+			ID:       account.ID,
+			Name:     account.Name,
+			Domain:   accountDomain.Domain,
+			JoinMode: accountDomain.JoinMode,
+		})
+	}
+
+	return response, nil
+}
+
+// JoinByDomain joins the account directly or files an access request,
+// depending on how the account configured the user's email domain
+func (s *AccountService) JoinByDomain(accountID, userID string) (*models.JoinAccountResponse, error) {
+	defer s.trace("AccountService.JoinByDomain")()
+	user, domain, err := s.verifiedEmailDomain(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountDomain models.AccountDomain
+	if err := s.db.Where("account_id = ? AND domain = ? AND verified = ?", accountID, domain, true).First(&accountDomain).Error; err != nil {
+		return nil, apperrors.Forbidden("email_domain_not_accepted", "account does not accept members from this email domain")
+	}
+
+	if s.isMember(accountID, user.ID) {
+		return nil, ErrAlreadyMember
+	}
+
+	if accountDomain.JoinMode == models.JoinModeAuto {
+		if err := addMember(s.db, accountID, user.ID, accountDomain.Role); err != nil {
+			return nil, err
+		}
+
+		s.memberJoined(accountID, models.UserPrincipal(user.ID), user.ID, accountDomain.Role, "domain")
+
+		return &models.JoinAccountResponse{
+			Status:    "joined",
+			AccountID: accountID,
+			Role:      accountDomain.Role,
+		}, nil
+	}
+
+	accessRequest, err := s.createAccessRequest(accountID, user.ID, accountDomain.Role, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.JoinAccountResponse{
+		Status:        "requested",
+		AccountID:     accountID,
+		AccessRequest: accessRequest,
+	}, nil
+}
+
+func (s *AccountService) verifiedEmailDomain(userID string) (*models.User, string, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	if !user.EmailVerified {
+		return nil, "", apperrors.Forbidden("email_not_verified", "email address is not verified")
+	}
+
+	at := strings.LastIndex(user.Email, "@")
+	if at < 0 {
+		return nil, "", apperrors.Validation("invalid_email", "invalid email address")
+	}
+
+	return &user, strings.ToLower(user.Email[at+1:]), nil
+}
+
+func (s *AccountService) isMember(accountID, userID string) bool {
+	var membership models.Membership
+	return s.db.Where("account_id = ? AND user_id = ?", accountID, userID).First(&membership).Error == nil
+}
+
+// Helper functions
+
+func addMember(db *gorm.DB, accountID, userID string, role models.MembershipRole) error {
+	if role == "" {
+		role = models.RoleMember
+	}
+
+	membership := models.Membership{
+		AccountID: accountID,
+		UserID:    userID,
+		Role:      role,
+	}
+	return db.Create(&membership).Error
+}
+
+func generateSecureToken(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func generateSecureHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func domainVerificationRecord(domain string) string {
+	return config.DomainVerificationLabel + "." + domain
+}
+
+func joinLinkResponse(link models.JoinLink) *models.JoinLinkResponse {
+	return &models.JoinLinkResponse{
+		JoinLink: link,
+		URL:      fmt.Sprintf("%s/join/%s", appBaseURL(), link.Code),
+	}
+}
+
+func accountDomainResponse(accountDomain models.AccountDomain) *models.AccountDomainResponse {
+	response := &models.AccountDomainResponse{AccountDomain: accountDomain}
+	if !accountDomain.Verified {
+		response.TXTRecordName = domainVerificationRecord(accountDomain.Domain)
+		response.TXTRecordValue = accountDomain.VerificationToken
+	}
+	return response
+}
@@ -0,0 +1,15 @@
+package services
+
+import (
+	"go-backend/models"
+	"log/slog"
+)
+
+// requestLogger tags the log lines written while handling a request with its
+// ID, so they can be found next to its access log line
+func requestLogger(client models.ClientInfo) *slog.Logger {
+	if client.RequestID == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("request_id", client.RequestID)
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/models"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// RequestMagicLink emails a login link to the user and returns the browser
+// secret the link is bound to. A secret is returned even for unknown emails,
+// and when the link cannot be sent, so responses do not reveal which emails
+// have users.
+func (s *AuthService) RequestMagicLink(email string) (string, error) {
+	defer s.trace("AuthService.RequestMagicLink")()
+	if err := ssoRequired(s.db, email); err != nil {
+		return "", err
+	}
+
+	browserSecret := generateSecureToken(32)
+
+	var user models.User
+	if err := s.db.Where("email = ?", normalizeEmail(email)).First(&user).Error; err != nil {
+		return browserSecret, nil
+	}
+
+	link := models.MagicLink{
+		UserID:      user.ID,
+		BrowserHash: hashSecret(browserSecret),
+		ExpiresAt:   time.Now().Add(config.MagicLinkTTL),
+	}
+	if err := s.db.Create(&link).Error; err != nil {
+		s.log.Error("Failed to create login link", "user_id", user.ID, "error", err)
+		return browserSecret, nil
+	}
+
+	token, err := generateMagicLinkToken(link)
+	if err != nil {
+		s.log.Error("Failed to create login link", "user_id", user.ID, "error", err)
+		return browserSecret, nil
+	}
+
+	url := fmt.Sprintf("%s/auth/magic-link?token=%s", appBaseURL(), token)
+	body := fmt.Sprintf("Open the link below to sign in:\n\n%s\n\n"+
+		"It works once, for %d minutes, in the browser where you asked for it.\n"+
+		"If you did not ask to sign in, you can ignore this email.\n",
+		url, int(config.MagicLinkTTL.Minutes()))
+	if err := sendNotificationEmail(requestContext(s.client), user.Email, "Your sign-in link", body); err != nil {
+		s.log.Error("Failed to send login link", "user_id", user.ID, "error", err)
+	}
+
+	return browserSecret, nil
+}
+
+// LoginWithMagicLink consumes the link and continues with the same checks a
+// password login goes through
+func (s *AuthService) LoginWithMagicLink(tokenString, browserSecret string) (*models.AuthResponse, error) {
+	defer s.trace("AuthService.LoginWithMagicLink")()
+	linkID, err := parseMagicLinkToken(tokenString)
+	if err != nil {
+		return nil, ErrInvalidLoginLink
+	}
+
+	var link models.MagicLink
+	if err := s.db.First(&link, "id = ?", linkID).Error; err != nil {
+		return nil, ErrInvalidLoginLink
+	}
+
+	if link.UsedAt != nil || link.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidLoginLink
+	}
+
+	// A forwarded link is useless without the cookie set in the requesting browser
+	if browserSecret == "" || subtle.ConstantTimeCompare([]byte(link.BrowserHash), []byte(hashSecret(browserSecret))) != 1 {
+		return nil, apperrors.Unauthorized("login_link_browser_mismatch", "login link must be opened in the browser that requested it")
+	}
+
+	// Only one concurrent use can mark the link as used
+	now := time.Now()
+	result := s.db.Model(&models.MagicLink{}).Where("id = ? AND used_at IS NULL", link.ID).UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("database error: %v", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrInvalidLoginLink
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", link.UserID).Error; err != nil {
+		return nil, ErrInvalidLoginLink
+	}
+
+	s.bindPendingInvitations(user)
+	s.audit(models.AuditLoginSucceeded, user.ID, user.ID, map[string]interface{}{"method": "magic_link"})
+	publish(s.db, events.UserLoggedIn{UserID: user.ID, Method: "magic_link"})
+
+	return s.completeLogin(user, "magic_link")
+}
+
+func generateMagicLinkToken(link models.MagicLink) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["purpose"] = "magic_link"
+	claims["link_id"] = link.ID
+	claims["exp"] = link.ExpiresAt.Unix()
+
+	tokenString, err := token.SignedString([]byte(config.JWTSecretKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign login link: %v", err)
+	}
+
+	return tokenString, nil
+}
+
+func parseMagicLinkToken(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(config.JWTSecretKey), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != "magic_link" {
+		return "", errors.New("invalid login link")
+	}
+
+	linkID, _ := claims["link_id"].(string)
+	if linkID == "" {
+		return "", errors.New("invalid login link")
+	}
+
+	return linkID, nil
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-backend/config"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// externalIdentity is what a provider tells us about the user who signed in
+type externalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+var (
+	oauthHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	discoveryCache sync.Map // issuer -> *oidcDiscovery
+
+	jwksMu    sync.Mutex
+	jwksCache = make(map[string]map[string]*rsa.PublicKey) // jwks_uri -> kid -> key
+)
+
+// authorizationURL builds the provider URL the browser is sent to, using
+// PKCE so an intercepted code cannot be redeemed by anyone else
+func authorizationURL(provider config.OAuthProvider, redirectURI, state, nonce, codeVerifier string) (string, error) {
+	authURL := provider.AuthURL
+	if provider.Type == config.ProviderTypeOIDC && authURL == "" {
+		discovery, err := discover(provider.Issuer)
+		if err != nil {
+			return "", err
+		}
+		authURL = discovery.AuthorizationEndpoint
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	if provider.Type == config.ProviderTypeOIDC {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(authURL, "?") {
+		separator = "&"
+	}
+	return authURL + separator + params.Encode(), nil
+}
+
+// fetchExternalIdentity redeems the authorization code and reads the
+// identity it was issued for
+func fetchExternalIdentity(provider config.OAuthProvider, code, redirectURI, codeVerifier, nonce string) (*externalIdentity, error) {
+	if provider.Type == config.ProviderTypeGitHub {
+		tokens, err := exchangeCode(provider, provider.TokenURL, code, redirectURI, codeVerifier)
+		if err != nil {
+			return nil, err
+		}
+		return githubIdentity(provider, tokens.AccessToken)
+	}
+
+	discovery, err := discover(provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenURL := provider.TokenURL
+	if tokenURL == "" {
+		tokenURL = discovery.TokenEndpoint
+	}
+
+	tokens, err := exchangeCode(provider, tokenURL, code, redirectURI, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyIDToken(provider, discovery, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &externalIdentity{
+		Subject:       claimString(claims, "sub"),
+		Email:         normalizeEmail(claimString(claims, "email")),
+		EmailVerified: claimBool(claims, "email_verified"),
+		Username:      claimString(claims, "preferred_username"),
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return identity, nil
+}
+
+func discover(issuer string) (*oidcDiscovery, error) {
+	if cached, ok := discoveryCache.Load(issuer); ok {
+		return cached.(*oidcDiscovery), nil
+	}
+
+	var discovery oidcDiscovery
+	if err := getJSON(issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %v", err)
+	}
+
+	// The document must describe the issuer it was fetched from
+	if strings.TrimRight(discovery.Issuer, "/") != issuer {
+		return nil, errors.New("provider issuer does not match its configuration")
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("provider configuration is incomplete")
+	}
+
+	discoveryCache.Store(issuer, &discovery)
+	return &discovery, nil
+}
+
+func exchangeCode(provider config.OAuthProvider, tokenURL, code, redirectURI, codeVerifier string) (*oauthTokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {provider.ClientID},
+		"client_secret": {provider.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens oauthTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("provider rejected the authorization code: %s", tokens.Error)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.AccessToken == "" {
+		return nil, fmt.Errorf("provider rejected the authorization code: status %d", resp.StatusCode)
+	}
+	if provider.Type == config.ProviderTypeOIDC && tokens.IDToken == "" {
+		return nil, errors.New("provider returned no ID token")
+	}
+
+	return &tokens, nil
+}
+
+// verifyIDToken checks the signature against the provider's published keys
+// along with the issuer, audience, expiry and nonce
+func verifyIDToken(provider config.OAuthProvider, discovery *oidcDiscovery, rawToken, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return jwksKey(discovery.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+
+	if claimString(claims, "iss") != discovery.Issuer {
+		return nil, errors.New("ID token was issued by someone else")
+	}
+	if !audienceContains(claims["aud"], provider.ClientID) {
+		return nil, errors.New("ID token is meant for another client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// jwksKey returns the signing key with the given ID, refetching the key set
+// once when the ID is unknown so rotated keys are picked up
+func jwksKey(jwksURI, kid string) (*rsa.PublicKey, error) {
+	jwksMu.Lock()
+	defer jwksMu.Unlock()
+
+	if key := lookupJWK(jwksCache[jwksURI], kid); key != nil {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(jwksURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	jwksCache[jwksURI] = keys
+
+	if key := lookupJWK(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+func lookupJWK(keys map[string]*rsa.PublicKey, kid string) *rsa.PublicKey {
+	if key, ok := keys[kid]; ok {
+		return key
+	}
+	// Without a key ID the token can only be checked against a single key
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// githubIdentity reads the user and their primary verified email from the
+// GitHub API, which does not speak OIDC
+func githubIdentity(provider config.OAuthProvider, accessToken string) (*externalIdentity, error) {
+	apiURL := strings.TrimRight(provider.UserInfoURL, "/")
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := getJSON(apiURL+"/user", accessToken, &user); err != nil {
+		return nil, fmt.Errorf("failed to read GitHub user: %v", err)
+	}
+	if user.ID == 0 {
+		return nil, errors.New("GitHub returned no user")
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(apiURL+"/user/emails", accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("failed to read GitHub emails: %v", err)
+	}
+
+	identity := &externalIdentity{
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Username: user.Login,
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = normalizeEmail(email.Email)
+			identity.EmailVerified = email.Verified
+			break
+		}
+	}
+	return identity, nil
+}
+
+// Helper functions
+
+func getJSON(target, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := oauthHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimBool accepts "true" as well, which some providers send
+func claimBool(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == clientID
+	case []interface{}:
+		for _, entry := range value {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	stubClientID = "stub-client"
+	stubKeyID    = "stub-key"
+	stubCode     = "stub-code"
+)
+
+// stubOIDCProvider serves discovery, its signing keys and a token endpoint
+// that answers a single authorization code with whatever ID token is set
+type stubOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 stub.issuer(),
+			"authorization_endpoint": stub.issuer() + "/authorize",
+			"token_endpoint":         stub.issuer() + "/token",
+			"jwks_uri":               stub.issuer() + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": stubKeyID,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil ||
+			r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != stubCode ||
+			r.PostForm.Get("client_id") != stubClientID ||
+			r.PostForm.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		writeJSON(w, map[string]string{
+			"access_token": "stub-access-token",
+			"token_type":   "Bearer",
+			"id_token":     stub.idToken,
+		})
+	})
+
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (p *stubOIDCProvider) issuer() string {
+	return p.server.URL
+}
+
+func (p *stubOIDCProvider) provider() config.OAuthProvider {
+	return config.OAuthProvider{
+		Name:     "stub",
+		Type:     config.ProviderTypeOIDC,
+		ClientID: stubClientID,
+		Issuer:   p.issuer(),
+		Scopes:   []string{"openid", "email", "profile"},
+	}
+}
+
+// claims are those of a valid ID token for the nonce
+func (p *stubOIDCProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            p.issuer(),
+		"aud":            stubClientID,
+		"sub":            "stub-subject",
+		"email":          "Jane@Example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// issue makes the token endpoint return the claims signed with key
+func (p *stubOIDCProvider) issue(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey) {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = stubKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idToken = signed
+}
+
+func TestFetchExternalIdentity(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	const nonce = "stub-nonce"
+
+	stub.issue(t, stub.claims(nonce), stub.key)
+
+	identity, err := fetchExternalIdentity(stub.provider(), stubCode, "http://localhost/callback", "verifier", nonce)
+	if err != nil {
+		t.Fatalf("valid ID token rejected: %v", err)
+	}
+	if identity.Subject != "stub-subject" {
+		t.Errorf("subject = %q, want stub-subject", identity.Subject)
+	}
+	if identity.Email != "jane@example.com" {
+		t.Errorf("email = %q, want it normalized to jane@example.com", identity.Email)
+	}
+	if !identity.EmailVerified {
+		t.Error("email_verified was not read")
+	}
+}
+
+func TestFetchExternalIdentityRejectsInvalidIDTokens(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	const nonce = "stub-nonce"
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		key    *rsa.PrivateKey
+		want   string
+	}{
+		{
+			name: "bad signature",
+			key:  otherKey,
+			want: "invalid ID token",
+		},
+		{
+			name:   "wrong issuer",
+			modify: func(claims jwt.MapClaims) { claims["iss"] = "https://attacker.example" },
+			want:   "issued by someone else",
+		},
+		{
+			name:   "wrong audience",
+			modify: func(claims jwt.MapClaims) { claims["aud"] = "another-client" },
+			want:   "meant for another client",
+		},
+		{
+			name:   "nonce mismatch",
+			modify: func(claims jwt.MapClaims) { claims["nonce"] = "replayed-nonce" },
+			want:   "nonce does not match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := stub.claims(nonce)
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			key := tt.key
+			if key == nil {
+				key = stub.key
+			}
+			stub.issue(t, claims, key)
+
+			_, err := fetchExternalIdentity(stub.provider(), stubCode, "http://localhost/callback", "verifier", nonce)
+			if err == nil {
+				t.Fatal("ID token was accepted")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteLoginDoesNotLinkUnverifiedUsers(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	db := newTestDB(t)
+
+	s := &SocialLoginService{
+		db:        db,
+		log:       slog.Default(),
+		providers: map[string]config.OAuthProvider{"stub": stub.provider()},
+	}
+
+	phone := "+14155550123"
+	user := models.User{
+		Username:      "jane",
+		Email:         "jane@example.com",
+		Password:      "hash",
+		Phone:         &phone,
+		EmailVerified: false,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, state, err := s.StartLogin("stub")
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider vouches for the email, but nobody proved it to us
+	stub.issue(t, stub.claims(parsed.Query().Get("nonce")), stub.key)
+
+	_, err = s.CompleteLogin("stub", models.OAuthCallbackRequest{Code: stubCode, State: state}, state)
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || appErr.Code != "user_exists" {
+		t.Fatalf("CompleteLogin error = %v, want user_exists", err)
+	}
+
+	var linked int
+	if err := db.Model(&models.Identity{}).Where("user_id = ?", user.ID).Count(&linked).Error; err != nil {
+		t.Fatal(err)
+	}
+	if linked != 0 {
+		t.Errorf("identity was linked to the unverified user")
+	}
+}
+
+// Helper functions
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.AutoMigrate(&models.User{}, &models.Account{}, &models.Membership{}, &models.Invitation{},
+		&models.JoinLink{}, &models.AccountDomain{}, &models.AccessRequest{}, &models.APIKey{}, &models.ServiceAccount{},
+		&models.ContactChange{}, &models.PasswordHistory{}, &models.UserDeletion{},
+		&models.MagicLink{}, &models.Identity{}, &models.OAuthState{},
+		&models.SAMLConnection{}, &models.SAMLRequest{}, &models.SSOTicket{},
+		&models.SCIMUser{}, &models.AuditEvent{}, &models.AuditChain{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go-backend/config"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords into self describing strings and verifies
+// passwords against hashes made by any supported algorithm
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether a hash was made with outdated settings
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	passwordHasher     PasswordHasher
+	passwordHasherOnce sync.Once
+)
+
+// InitPasswordHasher sets up the hasher from the environment, so invalid
+// settings stop the server at startup rather than failing every login
+func InitPasswordHasher() error {
+	var err error
+	passwordHasherOnce.Do(func() {
+		err = initPasswordHasher()
+	})
+	return err
+}
+
+// GetPasswordHasher returns the hasher configured for this process
+func GetPasswordHasher() PasswordHasher {
+	passwordHasherOnce.Do(func() {
+		if err := initPasswordHasher(); err != nil {
+			panic(fmt.Sprintf("invalid password hashing settings: %v", err))
+		}
+	})
+	return passwordHasher
+}
+
+func initPasswordHasher() error {
+	cfg, err := config.LoadPasswordHashing()
+	if err != nil {
+		return err
+	}
+	if cfg.Algorithm != "argon2id" && cfg.Algorithm != "bcrypt" {
+		slog.Warn("Unknown PASSWORD_HASH_ALGORITHM, using argon2id", "algorithm", cfg.Algorithm)
+		cfg.Algorithm = "argon2id"
+	}
+	passwordHasher = &multiHasher{
+		cfg:    cfg,
+		argon2: &argon2idHasher{cfg: cfg},
+		bcrypt: &bcryptHasher{cost: cfg.BcryptCost},
+	}
+	return nil
+}
+
+// multiHasher writes new hashes with the configured algorithm and dispatches
+// verification on the hash's identifier
+type multiHasher struct {
+	cfg    config.PasswordHashing
+	argon2 *argon2idHasher
+	bcrypt *bcryptHasher
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	if h.cfg.Algorithm == "bcrypt" {
+		return h.bcrypt.Hash(password)
+	}
+	return h.argon2.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, encoded string) (bool, error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		return h.argon2.Verify(password, encoded)
+	}
+	return h.bcrypt.Verify(password, encoded)
+}
+
+func (h *multiHasher) NeedsRehash(encoded string) bool {
+	isArgon2 := strings.HasPrefix(encoded, "$argon2id$")
+	if (h.cfg.Algorithm == "argon2id") != isArgon2 {
+		return true
+	}
+	if isArgon2 {
+		return h.argon2.NeedsRehash(encoded)
+	}
+	return h.bcrypt.NeedsRehash(encoded)
+}
+
+// argon2idHasher produces PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, with a keyid parameter added
+// when a pepper is mixed in
+type argon2idHasher struct {
+	cfg config.PasswordHashing
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyID       string
+	salt        []byte
+	hash        []byte
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	keyID := ""
+	if h.cfg.Pepper != "" {
+		keyID = h.cfg.PepperID
+	}
+
+	hash := argon2.IDKey(pepper(password, h.cfg.Pepper), salt,
+		h.cfg.Argon2Iterations, h.cfg.Argon2Memory, h.cfg.Argon2Parallelism, argon2KeyLength)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", h.cfg.Argon2Memory, h.cfg.Argon2Iterations, h.cfg.Argon2Parallelism)
+	if keyID != "" {
+		params += ",keyid=" + keyID
+	}
+
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func (h *argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	secret, ok := h.pepperSecret(p.keyID)
+	if !ok {
+		return false, fmt.Errorf("hash uses unknown pepper %q", p.keyID)
+	}
+
+	hash := argon2.IDKey(pepper(password, secret), p.salt,
+		p.iterations, p.memory, p.parallelism, uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(hash, p.hash) == 1, nil
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	wantKeyID := ""
+	if h.cfg.Pepper != "" {
+		wantKeyID = h.cfg.PepperID
+	}
+
+	return p.memory != h.cfg.Argon2Memory ||
+		p.iterations != h.cfg.Argon2Iterations ||
+		p.parallelism != h.cfg.Argon2Parallelism ||
+		p.keyID != wantKeyID
+}
+
+// pepperSecret finds the pepper a hash's key id refers to, the current one or
+// a retired one. Hashes without a key id were made without a pepper.
+func (h *argon2idHasher) pepperSecret(keyID string) (string, bool) {
+	switch {
+	case keyID == "":
+		return "", true
+	case keyID == h.cfg.PepperID && h.cfg.Pepper != "":
+		return h.cfg.Pepper, true
+	}
+	secret, ok := h.cfg.RetiredPeppers[keyID]
+	return secret, ok
+}
+
+// pepper mixes the server side secret into the password, if there is one
+func pepper(password, secret string) []byte {
+	if secret == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	// "", "argon2id", "v=19", params, salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2 version")
+	}
+
+	p := &argon2idParams{}
+	for _, param := range strings.Split(parts[3], ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+
+		var err error
+		switch key {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &p.memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &p.iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &p.parallelism)
+		case "keyid":
+			p.keyID = value
+		}
+		if err != nil {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+	}
+	if p.memory == 0 || p.iterations == 0 || p.parallelism == 0 {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("invalid argon2id salt")
+	}
+	if p.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.hash) == 0 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	return p, nil
+}
+
+// bcryptHasher handles legacy hashes and deployments that choose bcrypt.
+// Its modular crypt strings ($2a$10$...) already record the cost.
+type bcryptHasher struct {
+	cost int
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// passwordPolicyError lists every rule a password broke, so clients can show
+// them all at once
+func passwordPolicyError(violations []string) error {
+	return apperrors.Validation("password_policy", "password does not meet the policy: "+strings.Join(violations, "; ")).
+		With("violations", violations)
+}
+
+// PasswordCandidate is the password being set along with what it is checked
+// against besides the character rules
+type PasswordCandidate struct {
+	Password       string
+	Username       string
+	Email          string
+	PreviousHashes []string // Current and historical hashes, newest first
+}
+
+type PasswordPolicy struct {
+	config.PasswordPolicy
+	breached *breachedPasswords
+}
+
+var (
+	passwordPolicy     *PasswordPolicy
+	passwordPolicyOnce sync.Once
+)
+
+// GetPasswordPolicy returns the policy configured for this process
+func GetPasswordPolicy() *PasswordPolicy {
+	passwordPolicyOnce.Do(func() {
+		cfg := config.LoadPasswordPolicy()
+		passwordPolicy = &PasswordPolicy{PasswordPolicy: cfg}
+
+		if cfg.BreachedPasswordsFile != "" {
+			breached, err := loadBreachedPasswords(cfg.BreachedPasswordsFile)
+			if err != nil {
+				slog.Error("Failed to load breached password list", "error", err)
+			} else {
+				passwordPolicy.breached = breached
+			}
+		}
+	})
+	return passwordPolicy
+}
+
+func (p *PasswordPolicy) Validate(candidate PasswordCandidate) error {
+	var violations []string
+	password := candidate.Password
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.ForbidPersonalInfo {
+		lower := strings.ToLower(password)
+		if len(candidate.Username) >= 3 && strings.Contains(lower, strings.ToLower(candidate.Username)) {
+			violations = append(violations, "must not contain your username")
+		}
+		if local := emailLocalPart(candidate.Email); local != "" && strings.Contains(lower, local) {
+			violations = append(violations, "must not contain your email address")
+		}
+	}
+
+	previous := candidate.PreviousHashes
+	if len(previous) > p.HistorySize {
+		previous = previous[:p.HistorySize]
+	}
+	hasher := GetPasswordHasher()
+	for _, hash := range previous {
+		if ok, _ := hasher.Verify(password, hash); ok {
+			violations = append(violations, "must not be one of your recent passwords")
+			break
+		}
+	}
+
+	if p.breached != nil && p.breached.contains(password) {
+		violations = append(violations, "appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return passwordPolicyError(violations)
+	}
+	return nil
+}
+
+// breachedPasswords indexes SHA-1 hashes by their first five hex characters,
+// the same split the Pwned Passwords range API uses, so a lookup only ever
+// compares against one small bucket of suffixes
+type breachedPasswords struct {
+	buckets map[string]map[string]struct{}
+}
+
+func loadBreachedPasswords(path string) (*breachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := &breachedPasswords{buckets: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			line = line[:i]
+		}
+		if len(line) != sha1.Size*2 {
+			continue
+		}
+
+		hash := strings.ToUpper(line)
+		prefix, suffix := hash[:5], hash[5:]
+		if breached.buckets[prefix] == nil {
+			breached.buckets[prefix] = make(map[string]struct{})
+		}
+		breached.buckets[prefix][suffix] = struct{}{}
+	}
+
+	return breached, scanner.Err()
+}
+
+func (b *breachedPasswords) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	bucket, ok := b.buckets[hash[:5]]
+	if !ok {
+		return false
+	}
+	_, found := bucket[hash[5:]]
+	return found
+}
+
+func emailLocalPart(email string) string {
+	local := strings.ToLower(email)
+	if i := strings.IndexByte(local, '@'); i >= 0 {
+		local = local[:i]
+	}
+	// Very short local parts would reject too many passwords
+	if len(local) < 3 {
+		return ""
+	}
+	return local
+}
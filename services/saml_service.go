@@ -0,0 +1,622 @@
+package services
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/metrics"
+	"go-backend/models"
+	"go-backend/tracing"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/jinzhu/gorm"
+)
+
+// Attributes identity providers commonly put the email address in
+var samlEmailAttributes = []string{
+	"email",
+	"mail",
+	"emailaddress",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+}
+
+// ErrSSORequired is returned by the other login methods for users whose
+// account only lets them sign in through its identity provider. The error
+// returned carries the sso_login_url to continue at.
+var ErrSSORequired = apperrors.Forbidden("sso_required", "your organization requires signing in with SSO")
+
+func (s *AccountService) ConfigureSAML(accountID string, principal models.Principal, req models.ConfigureSAMLRequest) (*models.SAMLConnectionResponse, error) {
+	defer s.trace("AccountService.ConfigureSAML")()
+	if err := s.authorize(accountID, principal, models.PermSSOManage); err != nil {
+		return nil, err
+	}
+
+	var connection models.SAMLConnection
+	if err := s.db.Where("account_id = ?", accountID).First(&connection).Error; err != nil {
+		connection = models.SAMLConnection{AccountID: accountID}
+	}
+
+	if req.MetadataXML != "" {
+		metadata, err := parseIDPMetadata([]byte(req.MetadataXML))
+		if err != nil {
+			return nil, apperrors.Validation("invalid_saml_metadata", fmt.Sprintf("invalid metadata: %v", err))
+		}
+		connection.IDPEntityID = metadata.EntityID
+		connection.IDPSSOURL = metadata.ssoURL
+		connection.IDPCertificate = metadata.certificate
+		connection.IDPMetadataXML = req.MetadataXML
+	} else {
+		if req.EntityID == "" || req.SSOURL == "" || req.Certificate == "" {
+			return nil, apperrors.Validation("saml_settings_incomplete", "metadata_xml or entity_id, sso_url and certificate are required")
+		}
+		certificate, err := normalizeCertificate(req.Certificate)
+		if err != nil {
+			return nil, apperrors.Validation("invalid_certificate", err.Error())
+		}
+		connection.IDPEntityID = req.EntityID
+		connection.IDPSSOURL = req.SSOURL
+		connection.IDPCertificate = certificate
+		connection.IDPMetadataXML = ""
+	}
+
+	connection.DefaultRole = req.DefaultRole
+	if connection.DefaultRole == "" {
+		connection.DefaultRole = models.RoleMember
+	}
+	connection.EnforceSSO = req.EnforceSSO
+	connection.AllowIDPInitiated = req.AllowIDPInitiated
+
+	if err := s.db.Save(&connection).Error; err != nil {
+		return nil, fmt.Errorf("failed to save SAML connection: %v", err)
+	}
+
+	s.audit(accountID, principal, models.AuditSSOConfigured, "saml_connection", connection.ID, map[string]interface{}{
+		"idp_entity_id": connection.IDPEntityID,
+		"enforce_sso":   connection.EnforceSSO,
+		"default_role":  connection.DefaultRole,
+	})
+
+	return samlConnectionResponse(connection), nil
+}
+
+func (s *AccountService) GetSAMLConnection(accountID string, principal models.Principal) (*models.SAMLConnectionResponse, error) {
+	defer s.trace("AccountService.GetSAMLConnection")()
+	if err := s.authorize(accountID, principal, models.PermSSOManage); err != nil {
+		return nil, err
+	}
+
+	var connection models.SAMLConnection
+	if err := s.db.Where("account_id = ?", accountID).First(&connection).Error; err != nil {
+		return nil, apperrors.NotFound("saml_not_configured", "SAML is not configured")
+	}
+	return samlConnectionResponse(connection), nil
+}
+
+func (s *AccountService) DeleteSAMLConnection(accountID string, principal models.Principal) error {
+	defer s.trace("AccountService.DeleteSAMLConnection")()
+	if err := s.authorize(accountID, principal, models.PermSSOManage); err != nil {
+		return err
+	}
+
+	result := s.db.Where("account_id = ?", accountID).Delete(&models.SAMLConnection{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		s.audit(accountID, principal, models.AuditSSORemoved, "saml_connection", accountID, nil)
+	}
+	return nil
+}
+
+type SSOService struct {
+	db     *gorm.DB
+	client models.ClientInfo
+	log    *slog.Logger
+}
+
+func NewSSOService() *SSOService {
+	return &SSOService{db: config.DB, log: slog.Default()}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *SSOService) WithClient(client models.ClientInfo) *SSOService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
+}
+
+// Metadata returns the service provider metadata to give to the account's
+// identity provider
+func (s *SSOService) Metadata(accountID string) ([]byte, error) {
+	sp := serviceProvider(accountID, nil)
+
+	metadata := sp.Metadata()
+	// Only the HTTP-POST binding is supported
+	for i := range metadata.SPSSODescriptors {
+		descriptor := &metadata.SPSSODescriptors[i]
+		descriptor.AssertionConsumerServices = descriptor.AssertionConsumerServices[:1]
+		descriptor.NameIDFormats = []saml.NameIDFormat{saml.EmailAddressNameIDFormat}
+	}
+
+	return xml.MarshalIndent(metadata, "", "  ")
+}
+
+// StartLogin returns the identity provider URL carrying a new authentication
+// request
+func (s *SSOService) StartLogin(accountID string) (string, error) {
+	connection, err := s.connection(accountID)
+	if err != nil {
+		return "", err
+	}
+
+	sp := serviceProvider(accountID, connection)
+	authnRequest, err := sp.MakeAuthenticationRequest(connection.IDPSSOURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", fmt.Errorf("failed to create authentication request: %v", err)
+	}
+
+	relayState := generateSecureToken(32)
+	request := models.SAMLRequest{
+		AccountID:      accountID,
+		RequestID:      authnRequest.ID,
+		RelayStateHash: hashSecret(relayState),
+		ExpiresAt:      time.Now().Add(config.SAMLRequestTTL),
+	}
+	if err := s.db.Create(&request).Error; err != nil {
+		return "", err
+	}
+
+	redirectURL, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		return "", fmt.Errorf("failed to create authentication request: %v", err)
+	}
+	return redirectURL.String(), nil
+}
+
+// HandleResponse verifies the identity provider's response, provisions the
+// user and their membership when needed, and returns the app URL carrying a
+// single use code the client exchanges for a token
+func (s *SSOService) HandleResponse(accountID, samlResponse, relayState string) (string, error) {
+	connection, err := s.connection(accountID)
+	if err != nil {
+		return "", err
+	}
+
+	var possibleRequestIDs []string
+	if relayState != "" {
+		var request models.SAMLRequest
+		if err := s.db.Where("relay_state_hash = ? AND account_id = ?", hashSecret(relayState), accountID).
+			First(&request).Error; err == nil {
+			s.db.Delete(&request)
+			if request.ExpiresAt.After(time.Now()) {
+				possibleRequestIDs = append(possibleRequestIDs, request.RequestID)
+			}
+		}
+	}
+	if len(possibleRequestIDs) == 0 && !connection.AllowIDPInitiated {
+		return "", apperrors.Unauthorized("unsolicited_saml_response", "unsolicited SAML responses are not allowed")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return "", ErrInvalidSAMLResponse
+	}
+
+	sp := serviceProvider(accountID, connection)
+	assertion, err := sp.ParseXMLResponse(raw, possibleRequestIDs, sp.AcsURL)
+	if err != nil {
+		var invalidErr *saml.InvalidResponseError
+		if errors.As(err, &invalidErr) {
+			s.log.Warn("Rejected SAML response", "account_id", accountID, "error", invalidErr.PrivateErr)
+		}
+		return "", ErrInvalidSAMLResponse
+	}
+
+	email := assertionEmail(assertion)
+	if email == "" {
+		return "", apperrors.Unauthorized("saml_email_missing", "SAML response has no email address")
+	}
+
+	// An identity provider may only speak for the domains its account has proven
+	if !accountHasVerifiedDomain(s.db, accountID, emailDomain(email)) {
+		return "", apperrors.Forbidden("email_domain_not_verified", "email domain is not verified for this account")
+	}
+
+	user, err := findOrProvisionUser(s.db, email)
+	if err != nil {
+		return "", err
+	}
+
+	accountService := &AccountService{db: s.db, log: s.log}
+	if !accountService.isMember(accountID, user.ID) {
+		if err := addMember(s.db, accountID, user.ID, connection.DefaultRole); err != nil {
+			return "", fmt.Errorf("failed to add membership: %v", err)
+		}
+	}
+
+	code := generateSecureToken(32)
+	ticket := models.SSOTicket{
+		UserID:    user.ID,
+		CodeHash:  hashSecret(code),
+		ExpiresAt: time.Now().Add(config.SSOTicketTTL),
+	}
+	if err := s.db.Create(&ticket).Error; err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/auth/sso/callback?code=%s", appBaseURL(), url.QueryEscape(code)), nil
+}
+
+// Exchange turns the code from a completed SSO login into a token. The
+// identity provider is responsible for MFA, so no further steps follow.
+func (s *SSOService) Exchange(code string) (*models.AuthResponse, error) {
+	var ticket models.SSOTicket
+	if err := s.db.Where("code_hash = ?", hashSecret(code)).First(&ticket).Error; err != nil {
+		return nil, ErrInvalidSSOCode
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.SSOTicket{}).Where("id = ? AND used_at IS NULL", ticket.ID).UpdateColumn("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 || ticket.ExpiresAt.Before(now) {
+		return nil, ErrInvalidSSOCode
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", ticket.UserID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	authService := &AuthService{db: s.db, client: s.client, log: s.log}
+	authService.bindPendingInvitations(user)
+	authService.audit(models.AuditLoginSucceeded, user.ID, user.ID, map[string]interface{}{"method": "saml"})
+	publish(s.db, events.UserLoggedIn{UserID: user.ID, Method: "saml"})
+
+	token, err := generateJWT(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	metrics.Logins.WithLabelValues("saml", metrics.LoginSuccess).Inc()
+
+	return &models.AuthResponse{
+		Token:    token,
+		PrevFlow: "SSO",
+	}, nil
+}
+
+func (s *SSOService) connection(accountID string) (*models.SAMLConnection, error) {
+	var connection models.SAMLConnection
+	if err := s.db.Where("account_id = ?", accountID).First(&connection).Error; err != nil {
+		return nil, apperrors.NotFound("saml_not_configured", "SAML is not configured for this account")
+	}
+	return &connection, nil
+}
+
+func accountHasVerifiedDomain(db *gorm.DB, accountID, domain string) bool {
+	var accountDomain models.AccountDomain
+	return db.Where("account_id = ? AND domain = ? AND verified = ?", accountID, domain, true).
+		First(&accountDomain).Error == nil
+}
+
+// findOrProvisionUser returns the user with the email, creating one just in
+// time when there is none
+func findOrProvisionUser(db *gorm.DB, email string) (*models.User, error) {
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err == nil {
+		// Someone may have registered the address before its owner without
+		// proving it. The organization owns the mailbox, so the credentials
+		// they set are dropped.
+		if !user.EmailVerified {
+			password, err := GetPasswordHasher().Hash(generateSecureToken(32))
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash password: %v", err)
+			}
+			user.Password = password
+			user.EmailVerified = true
+			user.MFAEnabled = false
+			user.MFASecret = ""
+			if err := db.Save(&user).Error; err != nil {
+				return nil, fmt.Errorf("failed to update user: %v", err)
+			}
+		}
+		return &user, nil
+	}
+
+	return provisionUser(db, email)
+}
+
+// ssoRequired returns ErrSSORequired when an account enforcing SSO has
+// verified the email's domain
+func ssoRequired(db *gorm.DB, email string) error {
+	domain := emailDomain(normalizeEmail(email))
+	if domain == "" {
+		return nil
+	}
+
+	var connection models.SAMLConnection
+	err := db.Joins("JOIN account_domains ON account_domains.account_id = saml_connections.account_id").
+		Where("account_domains.domain = ? AND account_domains.verified = ? AND saml_connections.enforce_sso = ?",
+			domain, true, true).
+		First(&connection).Error
+	if err != nil {
+		return nil
+	}
+
+	return ErrSSORequired.With("sso_login_url", samlURL(connection.AccountID, "login"))
+}
+
+// Helper functions
+
+var (
+	spKeyPair     *tls.Certificate
+	spKeyPairOnce sync.Once
+)
+
+// serviceProvider describes this server to the account's identity provider.
+// The optional SAML_SP_CERT_FILE and SAML_SP_KEY_FILE let identity providers
+// encrypt assertions.
+func serviceProvider(accountID string, connection *models.SAMLConnection) *saml.ServiceProvider {
+	spKeyPairOnce.Do(func() {
+		certFile, keyFile := os.Getenv("SAML_SP_CERT_FILE"), os.Getenv("SAML_SP_KEY_FILE")
+		if certFile == "" || keyFile == "" {
+			return
+		}
+		keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			slog.Error("Failed to load SAML service provider key pair", "error", err)
+			return
+		}
+		keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0])
+		if err != nil {
+			slog.Error("Failed to parse SAML service provider certificate", "error", err)
+			return
+		}
+		spKeyPair = &keyPair
+	})
+
+	metadataURL, _ := url.Parse(samlURL(accountID, "metadata"))
+	acsURL, _ := url.Parse(samlURL(accountID, "acs"))
+
+	sp := &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+	}
+	if spKeyPair != nil {
+		if key, ok := spKeyPair.PrivateKey.(crypto.Signer); ok {
+			sp.Key = key
+			sp.Certificate = spKeyPair.Leaf
+		}
+	}
+
+	if connection != nil {
+		sp.AllowIDPInitiated = connection.AllowIDPInitiated
+		sp.IDPMetadata = &saml.EntityDescriptor{
+			EntityID: connection.IDPEntityID,
+			IDPSSODescriptors: []saml.IDPSSODescriptor{{
+				SSODescriptor: saml.SSODescriptor{
+					RoleDescriptor: saml.RoleDescriptor{
+						KeyDescriptors: []saml.KeyDescriptor{{
+							Use: "signing",
+							KeyInfo: saml.KeyInfo{
+								X509Data: saml.X509Data{
+									X509Certificates: []saml.X509Certificate{{Data: connection.IDPCertificate}},
+								},
+							},
+						}},
+					},
+				},
+				SingleSignOnServices: []saml.Endpoint{{
+					Binding:  saml.HTTPRedirectBinding,
+					Location: connection.IDPSSOURL,
+				}},
+			}},
+		}
+	}
+
+	return sp
+}
+
+func samlURL(accountID, endpoint string) string {
+	return fmt.Sprintf("%s/saml/%s/%s", config.APIBaseURL(), accountID, endpoint)
+}
+
+func samlConnectionResponse(connection models.SAMLConnection) *models.SAMLConnectionResponse {
+	return &models.SAMLConnectionResponse{
+		SAMLConnection: connection,
+		SPEntityID:     samlURL(connection.AccountID, "metadata"),
+		SPACSURL:       samlURL(connection.AccountID, "acs"),
+		SPMetadataURL:  samlURL(connection.AccountID, "metadata"),
+		LoginURL:       samlURL(connection.AccountID, "login"),
+	}
+}
+
+type idpMetadata struct {
+	*saml.EntityDescriptor
+	ssoURL      string
+	certificate string
+}
+
+// parseIDPMetadata reads the entity ID, redirect binding SSO URL and signing
+// certificate from an identity provider's metadata
+func parseIDPMetadata(data []byte) (*idpMetadata, error) {
+	entity := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, entity); err != nil {
+		entities := &saml.EntitiesDescriptor{}
+		if xml.Unmarshal(data, entities) != nil {
+			return nil, err
+		}
+		entity = nil
+		for i := range entities.EntityDescriptors {
+			if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+				entity = &entities.EntityDescriptors[i]
+				break
+			}
+		}
+		if entity == nil {
+			return nil, errors.New("no identity provider found")
+		}
+	}
+
+	if entity.EntityID == "" || len(entity.IDPSSODescriptors) == 0 {
+		return nil, errors.New("no identity provider found")
+	}
+
+	metadata := &idpMetadata{EntityDescriptor: entity}
+	for _, descriptor := range entity.IDPSSODescriptors {
+		for _, service := range descriptor.SingleSignOnServices {
+			if service.Binding == saml.HTTPRedirectBinding && metadata.ssoURL == "" {
+				metadata.ssoURL = service.Location
+			}
+		}
+		for _, key := range descriptor.KeyDescriptors {
+			if (key.Use == "" || key.Use == "signing") && len(key.KeyInfo.X509Data.X509Certificates) > 0 && metadata.certificate == "" {
+				metadata.certificate = key.KeyInfo.X509Data.X509Certificates[0].Data
+			}
+		}
+	}
+
+	if metadata.ssoURL == "" {
+		return nil, errors.New("identity provider has no HTTP-Redirect SSO endpoint")
+	}
+	if metadata.certificate == "" {
+		return nil, errors.New("identity provider has no signing certificate")
+	}
+
+	certificate, err := normalizeCertificate(metadata.certificate)
+	if err != nil {
+		return nil, err
+	}
+	metadata.certificate = certificate
+
+	return metadata, nil
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// normalizeCertificate accepts PEM or base64 DER and returns base64 DER
+func normalizeCertificate(certificate string) (string, error) {
+	var der []byte
+	if block, _ := pem.Decode([]byte(certificate)); block != nil {
+		der = block.Bytes
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(whitespace.ReplaceAllString(certificate, ""))
+		if err != nil {
+			return "", errors.New("certificate must be PEM or base64 encoded")
+		}
+		der = decoded
+	}
+
+	if _, err := x509.ParseCertificate(der); err != nil {
+		return "", fmt.Errorf("invalid certificate: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+func assertionEmail(assertion *saml.Assertion) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			for _, name := range samlEmailAttributes {
+				if strings.EqualFold(attribute.Name, name) || strings.EqualFold(attribute.FriendlyName, name) {
+					if len(attribute.Values) > 0 && strings.Contains(attribute.Values[0].Value, "@") {
+						return normalizeEmail(attribute.Values[0].Value)
+					}
+				}
+			}
+		}
+	}
+
+	if assertion.Subject != nil && assertion.Subject.NameID != nil && strings.Contains(assertion.Subject.NameID.Value, "@") {
+		return normalizeEmail(assertion.Subject.NameID.Value)
+	}
+	return ""
+}
+
+// provisionUser creates a user for someone an identity provider vouches for.
+// They sign in through it, so the password is unusable and there is no phone.
+func provisionUser(db *gorm.DB, email string) (*models.User, error) {
+	username, err := uniqueUsername(db, email)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := GetPasswordHasher().Hash(generateSecureToken(32))
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	user := models.User{
+		Username:      username,
+		Email:         email,
+		Password:      password,
+		EmailVerified: true,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+	return &user, nil
+}
+
+// uniqueUsername derives a free username from the email's local part
+func uniqueUsername(db *gorm.DB, email string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToLower(email) {
+		if r == '@' {
+			break
+		}
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+
+	base := b.String()
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	username := base
+	for i := 0; i < 5; i++ {
+		var count int
+		if err := db.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return username, nil
+		}
+		username = base + generateSecureHex(3)
+	}
+
+	return "", errors.New("failed to generate a unique username")
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
@@ -0,0 +1,1189 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/models"
+	"go-backend/tracing"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SCIMError is reported to SCIM clients in the format of RFC 7644 section 3.12
+type SCIMError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+func scimError(status int, scimType, detail string) *SCIMError {
+	return &SCIMError{Status: status, ScimType: scimType, Detail: detail}
+}
+
+// Groups are the roles a membership can be provisioned with. Ownership is
+// never provisioned, so owners are in no group and cannot be deprovisioned.
+var scimGroupRoles = []models.MembershipRole{models.RoleAdmin, models.RoleMember}
+
+type scimColumn struct {
+	name    string
+	boolean bool
+}
+
+// User attributes that can be filtered on
+var scimUserColumns = map[string]scimColumn{
+	"id":           {name: "scim_users.user_id"},
+	"externalid":   {name: "scim_users.external_id"},
+	"username":     {name: "users.email"},
+	"emails":       {name: "users.email"},
+	"emails.value": {name: "users.email"},
+	"displayname":  {name: "users.display_name"},
+	"active":       {name: "scim_users.active", boolean: true},
+}
+
+var scimMemberPath = regexp.MustCompile(`(?i)^members\[value eq "([^"]+)"\]$`)
+
+type SCIMService struct {
+	db     *gorm.DB
+	client models.ClientInfo
+	log    *slog.Logger
+}
+
+func NewSCIMService() *SCIMService {
+	return &SCIMService{db: config.DB, log: slog.Default()}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *SCIMService) WithClient(client models.ClientInfo) *SCIMService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
+}
+
+func (s *SCIMService) ServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":          []string{models.SCIMSchemaServiceProviderConfig},
+		"documentationUri": config.APIBaseURL() + "/scim/v2",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": config.SCIMMaxPageSize},
+		"changePassword":   map[string]interface{}{"supported": false},
+		"sort":             map[string]interface{}{"supported": false},
+		"etag":             map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Account API key",
+			"description": "An API key of the account with the scim:provision scope, sent as a bearer token",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     config.APIBaseURL() + "/scim/v2/ServiceProviderConfig",
+		},
+	}
+}
+
+func (s *SCIMService) ListUsers(principal models.Principal, filter string, startIndex, count int) (*models.SCIMListResponse, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	clauses, err := parseSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Table("scim_users").
+		Joins("JOIN users ON users.id = scim_users.user_id").
+		Where("scim_users.account_id = ?", principal.AccountID)
+	for _, clause := range clauses {
+		column, ok := scimUserColumns[strings.ToLower(clause.attribute)]
+		if !ok {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "unsupported filter attribute: "+clause.attribute)
+		}
+		if query, err = clause.where(query, column); err != nil {
+			return nil, err
+		}
+	}
+
+	var total int
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	startIndex, count = scimPage(startIndex, count)
+	scimUsers := make([]models.SCIMUser, 0)
+	if count > 0 {
+		if err := query.Select("scim_users.*").Order("scim_users.created_at, scim_users.id").
+			Offset(startIndex - 1).Limit(count).Find(&scimUsers).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	users, err := s.userResources(principal.AccountID, scimUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, user)
+	}
+	return scimListResponse(total, startIndex, resources), nil
+}
+
+func (s *SCIMService) GetUser(principal models.Principal, userID string) (*models.SCIMUserResource, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	scimUser, err := s.findUser(s.db, principal.AccountID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.userResource(principal.AccountID, *scimUser)
+}
+
+// CreateUser provisions a user of one of the account's verified domains and
+// makes them a member. A user who already exists with the email is taken
+// over rather than duplicated.
+func (s *SCIMService) CreateUser(principal models.Principal, resource models.SCIMUserResource) (*models.SCIMUserResource, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+	accountID := principal.AccountID
+
+	email := normalizeEmail(resource.PrimaryEmail())
+	if email == "" {
+		email = normalizeEmail(resource.UserName)
+	}
+	if !strings.Contains(email, "@") {
+		return nil, scimError(http.StatusBadRequest, "invalidValue", "userName or emails must hold the user's email address")
+	}
+	if !accountHasVerifiedDomain(s.db, accountID, emailDomain(email)) {
+		return nil, scimError(http.StatusBadRequest, "invalidValue", "email domain is not verified for this account")
+	}
+
+	tx := beginTx(s.db)
+
+	user, err := findOrProvisionUser(tx, email)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var existing models.SCIMUser
+	if err := tx.Where("account_id = ? AND user_id = ?", accountID, user.ID).First(&existing).Error; err == nil {
+		tx.Rollback()
+		return nil, scimError(http.StatusConflict, "uniqueness", "user is already provisioned")
+	}
+
+	scimUser := models.SCIMUser{
+		AccountID:  accountID,
+		UserID:     user.ID,
+		ExternalID: resource.ExternalID,
+	}
+	if err := tx.Create(&scimUser).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to provision user: %v", err)
+	}
+
+	changes := scimUserChanges{
+		email:       user.Email,
+		externalID:  resource.ExternalID,
+		displayName: user.DisplayName,
+		active:      resource.Active == nil || *resource.Active,
+	}
+	if name := scimDisplayName(resource); name != "" {
+		changes.displayName = name
+	}
+	if err := s.saveUser(tx, principal, &scimUser, user, changes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, err
+	}
+	return s.userResource(accountID, scimUser)
+}
+
+func (s *SCIMService) ReplaceUser(principal models.Principal, userID string, resource models.SCIMUserResource) (*models.SCIMUserResource, error) {
+	return s.updateUser(principal, userID, func(changes *scimUserChanges) error {
+		for _, email := range []string{resource.UserName, resource.PrimaryEmail()} {
+			if err := changes.setEmail(email); err != nil {
+				return err
+			}
+		}
+		changes.externalID = resource.ExternalID
+		if name := scimDisplayName(resource); name != "" {
+			changes.displayName = name
+		}
+		if resource.Active != nil {
+			changes.active = *resource.Active
+		}
+		return nil
+	})
+}
+
+func (s *SCIMService) PatchUser(principal models.Principal, userID string, patch models.SCIMPatchRequest) (*models.SCIMUserResource, error) {
+	return s.updateUser(principal, userID, func(changes *scimUserChanges) error {
+		for _, op := range patch.Operations {
+			if err := changes.apply(op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteUser deprovisions the user from the account. The user itself is
+// left alone, they may belong to other accounts.
+func (s *SCIMService) DeleteUser(principal models.Principal, userID string) error {
+	if err := s.authorize(principal); err != nil {
+		return err
+	}
+
+	tx := beginTx(s.db)
+
+	scimUser, err := s.findUser(tx, principal.AccountID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := s.setUserActive(tx, principal, scimUser, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(scimUser).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return commitTx(tx)
+}
+
+func (s *SCIMService) ListGroups(principal models.Principal, filter string, startIndex, count int, excludeMembers bool) (*models.SCIMListResponse, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	clauses, err := parseSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]interface{}, 0, len(scimGroupRoles))
+	for _, role := range scimGroupRoles {
+		group, err := s.groupResource(principal.AccountID, role)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := true
+		for _, clause := range clauses {
+			ok, err := clause.matchGroup(group)
+			if err != nil {
+				return nil, err
+			}
+			matches = matches && ok
+		}
+		if !matches {
+			continue
+		}
+
+		if excludeMembers {
+			group.Members = nil
+		}
+		groups = append(groups, group)
+	}
+
+	total := len(groups)
+	startIndex, count = scimPage(startIndex, count)
+	if startIndex-1 < len(groups) {
+		groups = groups[startIndex-1:]
+	} else {
+		groups = groups[:0]
+	}
+	if count < len(groups) {
+		groups = groups[:count]
+	}
+	return scimListResponse(total, startIndex, groups), nil
+}
+
+func (s *SCIMService) GetGroup(principal models.Principal, groupID string, excludeMembers bool) (*models.SCIMGroupResource, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	role, err := scimGroupRole(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	group, err := s.groupResource(principal.AccountID, role)
+	if err != nil {
+		return nil, err
+	}
+	if excludeMembers {
+		group.Members = nil
+	}
+	return group, nil
+}
+
+func (s *SCIMService) ReplaceGroup(principal models.Principal, groupID string, resource models.SCIMGroupResource) (*models.SCIMGroupResource, error) {
+	return s.updateGroup(principal, groupID, func(tx *gorm.DB, role models.MembershipRole) error {
+		if err := checkSCIMGroupName(role, resource.DisplayName); err != nil {
+			return err
+		}
+		return s.replaceGroupMembers(tx, principal, role, resource.Members)
+	})
+}
+
+// PatchGroup changes the role of the members added to or removed from a
+// group. Leaving the admin group makes an admin a member again. Leaving the
+// member group changes nothing, users lose access by being deprovisioned.
+func (s *SCIMService) PatchGroup(principal models.Principal, groupID string, patch models.SCIMPatchRequest) (*models.SCIMGroupResource, error) {
+	return s.updateGroup(principal, groupID, func(tx *gorm.DB, role models.MembershipRole) error {
+		for _, op := range patch.Operations {
+			if err := s.applyGroupOperation(tx, principal, role, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SCIMService) applyGroupOperation(tx *gorm.DB, principal models.Principal, role models.MembershipRole, op models.SCIMPatchOperation) error {
+	path := strings.ToLower(op.Path)
+
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		replace := strings.EqualFold(op.Op, "replace")
+		if path == "" {
+			var values struct {
+				DisplayName *string                `json:"displayName"`
+				Members     []models.SCIMReference `json:"members"`
+			}
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return scimError(http.StatusBadRequest, "invalidValue", "value must be an object")
+			}
+			if values.DisplayName != nil {
+				if err := checkSCIMGroupName(role, *values.DisplayName); err != nil {
+					return err
+				}
+			}
+			if values.Members == nil {
+				return nil
+			}
+			if replace {
+				return s.replaceGroupMembers(tx, principal, role, values.Members)
+			}
+			return s.addGroupMembers(tx, principal, role, values.Members)
+		}
+
+		switch path {
+		case "displayname":
+			var name string
+			if err := json.Unmarshal(op.Value, &name); err != nil {
+				return scimError(http.StatusBadRequest, "invalidValue", "displayName must be a string")
+			}
+			return checkSCIMGroupName(role, name)
+		case "members":
+			var members []models.SCIMReference
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				return scimError(http.StatusBadRequest, "invalidValue", "members must be a list")
+			}
+			if replace {
+				return s.replaceGroupMembers(tx, principal, role, members)
+			}
+			return s.addGroupMembers(tx, principal, role, members)
+		}
+		return scimError(http.StatusBadRequest, "invalidPath", "unsupported path: "+op.Path)
+
+	case "remove":
+		if match := scimMemberPath.FindStringSubmatch(op.Path); match != nil {
+			return s.removeGroupMember(tx, principal, role, match[1])
+		}
+		if path != "members" {
+			return scimError(http.StatusBadRequest, "invalidPath", "unsupported path: "+op.Path)
+		}
+
+		var members []models.SCIMReference
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				return scimError(http.StatusBadRequest, "invalidValue", "members must be a list")
+			}
+		} else {
+			// Without a value every member leaves
+			return s.replaceGroupMembers(tx, principal, role, nil)
+		}
+		for _, member := range members {
+			if err := s.removeGroupMember(tx, principal, role, member.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return scimError(http.StatusBadRequest, "invalidSyntax", "unsupported operation: "+op.Op)
+}
+
+func (s *SCIMService) addGroupMembers(tx *gorm.DB, principal models.Principal, role models.MembershipRole, members []models.SCIMReference) error {
+	for _, member := range members {
+		if err := s.setUserRole(tx, principal, member.Value, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SCIMService) replaceGroupMembers(tx *gorm.DB, principal models.Principal, role models.MembershipRole, members []models.SCIMReference) error {
+	keep := make(map[string]bool, len(members))
+	for _, member := range members {
+		keep[member.Value] = true
+	}
+
+	current, err := s.groupMembers(tx, principal.AccountID, role)
+	if err != nil {
+		return err
+	}
+	for _, member := range current {
+		if !keep[member.Value] {
+			if err := s.removeGroupMember(tx, principal, role, member.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.addGroupMembers(tx, principal, role, members)
+}
+
+func (s *SCIMService) updateUser(principal models.Principal, userID string, update func(changes *scimUserChanges) error) (*models.SCIMUserResource, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	tx := beginTx(s.db)
+
+	scimUser, err := s.findUser(tx, principal.AccountID, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", scimUser.UserID).Error; err != nil {
+		tx.Rollback()
+		return nil, scimError(http.StatusNotFound, "", "user not found")
+	}
+
+	changes := scimUserChanges{
+		email:       user.Email,
+		externalID:  scimUser.ExternalID,
+		displayName: user.DisplayName,
+		active:      scimUser.Active,
+	}
+	if err := update(&changes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := s.saveUser(tx, principal, scimUser, &user, changes); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := commitTx(tx); err != nil {
+		return nil, err
+	}
+	return s.userResource(principal.AccountID, *scimUser)
+}
+
+func (s *SCIMService) saveUser(tx *gorm.DB, principal models.Principal, scimUser *models.SCIMUser, user *models.User, changes scimUserChanges) error {
+	if changes.displayName != user.DisplayName {
+		if err := tx.Model(user).Update("display_name", changes.displayName).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := s.setUserActive(tx, principal, scimUser, changes.active); err != nil {
+		return err
+	}
+
+	scimUser.ExternalID = changes.externalID
+	return tx.Save(scimUser).Error
+}
+
+func (s *SCIMService) updateGroup(principal models.Principal, groupID string, update func(tx *gorm.DB, role models.MembershipRole) error) (*models.SCIMGroupResource, error) {
+	if err := s.authorize(principal); err != nil {
+		return nil, err
+	}
+
+	role, err := scimGroupRole(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := beginTx(s.db)
+	if err := update(tx, role); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := commitTx(tx); err != nil {
+		return nil, err
+	}
+
+	return s.groupResource(principal.AccountID, role)
+}
+
+// setUserActive adds or removes the user's membership. Owners keep theirs.
+func (s *SCIMService) setUserActive(tx *gorm.DB, principal models.Principal, scimUser *models.SCIMUser, active bool) error {
+	var membership models.Membership
+	isMember := tx.Where("account_id = ? AND user_id = ?", scimUser.AccountID, scimUser.UserID).
+		First(&membership).Error == nil
+
+	if active && !isMember {
+		if err := addMember(tx, scimUser.AccountID, scimUser.UserID, models.RoleMember); err != nil {
+			return fmt.Errorf("failed to add membership: %v", err)
+		}
+		s.audit(tx, principal, models.AuditMemberJoined, scimUser.UserID, map[string]interface{}{
+			"role": models.RoleMember,
+			"via":  "scim",
+		})
+		publish(tx, events.MembershipCreated{
+			AccountID: scimUser.AccountID,
+			UserID:    scimUser.UserID,
+			Role:      models.RoleMember,
+			Via:       "scim",
+		})
+	}
+	if !active && isMember {
+		if membership.Role == models.RoleOwner {
+			return scimError(http.StatusBadRequest, "mutability", "the account owner cannot be deprovisioned")
+		}
+		if err := tx.Delete(&membership).Error; err != nil {
+			return err
+		}
+		s.audit(tx, principal, models.AuditMemberRemoved, scimUser.UserID, map[string]interface{}{
+			"role": membership.Role,
+			"via":  "scim",
+		})
+	}
+
+	scimUser.Active = active
+	return nil
+}
+
+func (s *SCIMService) setUserRole(tx *gorm.DB, principal models.Principal, userID string, role models.MembershipRole) error {
+	var scimUser models.SCIMUser
+	if err := tx.Where("account_id = ? AND user_id = ?", principal.AccountID, userID).First(&scimUser).Error; err != nil {
+		return scimError(http.StatusBadRequest, "invalidValue", "unknown member: "+userID)
+	}
+	if !scimUser.Active {
+		return scimError(http.StatusBadRequest, "invalidValue", "deprovisioned users cannot join groups: "+userID)
+	}
+
+	var membership models.Membership
+	if err := tx.Where("account_id = ? AND user_id = ?", principal.AccountID, userID).First(&membership).Error; err != nil {
+		return scimError(http.StatusBadRequest, "invalidValue", "unknown member: "+userID)
+	}
+	if membership.Role == role {
+		return nil
+	}
+	if membership.Role == models.RoleOwner {
+		return scimError(http.StatusBadRequest, "mutability", "the account owner's role cannot be changed")
+	}
+
+	previousRole := membership.Role
+	if err := tx.Model(&membership).Update("role", role).Error; err != nil {
+		return err
+	}
+
+	s.audit(tx, principal, models.AuditMemberRoleChanged, userID, map[string]interface{}{
+		"previous_role": previousRole,
+		"role":          role,
+		"via":           "scim",
+	})
+	return nil
+}
+
+func (s *SCIMService) removeGroupMember(tx *gorm.DB, principal models.Principal, role models.MembershipRole, userID string) error {
+	if role != models.RoleAdmin {
+		return nil
+	}
+
+	var membership models.Membership
+	if err := tx.Where("account_id = ? AND user_id = ?", principal.AccountID, userID).First(&membership).Error; err != nil {
+		return nil
+	}
+	if membership.Role != models.RoleAdmin {
+		return nil
+	}
+	return s.setUserRole(tx, principal, userID, models.RoleMember)
+}
+
+// audit records a change to a provisioned user. It is part of the
+// transaction, so changes that are rolled back leave no event.
+func (s *SCIMService) audit(tx *gorm.DB, principal models.Principal, action models.AuditAction, userID string, metadata map[string]interface{}) {
+	recordAudit(tx, s.client, models.AuditEvent{
+		AccountID:  principal.AccountID,
+		ActorType:  principal.Type,
+		ActorID:    principal.ID,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+	}, metadata)
+}
+
+// authorize lets through API keys of the account holding the scim:provision scope
+func (s *SCIMService) authorize(principal models.Principal) error {
+	accountService := &AccountService{db: s.db, log: s.log}
+	if !accountService.can(principal.AccountID, principal, models.PermSCIMProvision) {
+		return scimError(http.StatusForbidden, "", "not authorized to provision users")
+	}
+	return nil
+}
+
+func (s *SCIMService) findUser(db *gorm.DB, accountID, userID string) (*models.SCIMUser, error) {
+	var scimUser models.SCIMUser
+	if err := db.Where("account_id = ? AND user_id = ?", accountID, userID).First(&scimUser).Error; err != nil {
+		return nil, scimError(http.StatusNotFound, "", "user not found")
+	}
+	return &scimUser, nil
+}
+
+func (s *SCIMService) userResource(accountID string, scimUser models.SCIMUser) (*models.SCIMUserResource, error) {
+	resources, err := s.userResources(accountID, []models.SCIMUser{scimUser})
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, scimError(http.StatusNotFound, "", "user not found")
+	}
+	return &resources[0], nil
+}
+
+func (s *SCIMService) userResources(accountID string, scimUsers []models.SCIMUser) ([]models.SCIMUserResource, error) {
+	resources := make([]models.SCIMUserResource, 0, len(scimUsers))
+	if len(scimUsers) == 0 {
+		return resources, nil
+	}
+
+	userIDs := make([]string, 0, len(scimUsers))
+	for _, scimUser := range scimUsers {
+		userIDs = append(userIDs, scimUser.UserID)
+	}
+
+	var users []models.User
+	if err := s.db.Where("id IN (?)", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usersByID := make(map[string]models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	var memberships []models.Membership
+	if err := s.db.Where("account_id = ? AND user_id IN (?)", accountID, userIDs).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[string]models.MembershipRole, len(memberships))
+	for _, membership := range memberships {
+		roles[membership.UserID] = membership.Role
+	}
+
+	for _, scimUser := range scimUsers {
+		user, ok := usersByID[scimUser.UserID]
+		if !ok {
+			continue
+		}
+		resources = append(resources, scimUserResource(scimUser, user, roles[user.ID]))
+	}
+	return resources, nil
+}
+
+func (s *SCIMService) groupResource(accountID string, role models.MembershipRole) (*models.SCIMGroupResource, error) {
+	members, err := s.groupMembers(s.db, accountID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SCIMGroupResource{
+		Schemas:     []string{models.SCIMSchemaGroup},
+		ID:          string(role),
+		DisplayName: string(role),
+		Members:     members,
+		Meta: &models.SCIMMeta{
+			ResourceType: "Group",
+			Location:     scimURL("Groups", string(role)),
+		},
+	}, nil
+}
+
+// groupMembers lists the active provisioned users holding the role
+func (s *SCIMService) groupMembers(db *gorm.DB, accountID string, role models.MembershipRole) ([]models.SCIMReference, error) {
+	var rows []struct {
+		UserID string
+		Email  string
+	}
+	err := db.Table("scim_users").Select("users.id AS user_id, users.email AS email").
+		Joins("JOIN users ON users.id = scim_users.user_id").
+		Joins("JOIN memberships ON memberships.user_id = scim_users.user_id AND memberships.account_id = scim_users.account_id").
+		Where("scim_users.account_id = ? AND scim_users.active = ? AND memberships.role = ?", accountID, true, role).
+		Order("users.email").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]models.SCIMReference, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, models.SCIMReference{
+			Value:   row.UserID,
+			Display: row.Email,
+			Ref:     scimURL("Users", row.UserID),
+		})
+	}
+	return members, nil
+}
+
+// scimUserChanges collects the attributes a replace or patch sets before
+// they are saved together
+type scimUserChanges struct {
+	email       string
+	externalID  string
+	displayName string
+	active      bool
+}
+
+func (c *scimUserChanges) apply(op models.SCIMPatchOperation) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+		if op.Path != "" {
+			return c.set(op.Path, op.Value)
+		}
+
+		// Without a path the value holds attributes by name
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return scimError(http.StatusBadRequest, "invalidValue", "value must be an object")
+		}
+		for path, value := range values {
+			if err := c.set(path, value); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "remove":
+		switch strings.ToLower(op.Path) {
+		case "externalid":
+			c.externalID = ""
+		case "displayname", "name", "name.formatted":
+			c.displayName = ""
+		default:
+			return scimError(http.StatusBadRequest, "mutability", "attribute cannot be removed: "+op.Path)
+		}
+		return nil
+	}
+
+	return scimError(http.StatusBadRequest, "invalidSyntax", "unsupported operation: "+op.Op)
+}
+
+func (c *scimUserChanges) set(path string, value json.RawMessage) error {
+	lowerPath := strings.ToLower(path)
+
+	switch {
+	case lowerPath == "active":
+		active, err := scimBool(value)
+		if err != nil {
+			return err
+		}
+		c.active = active
+
+	case lowerPath == "externalid":
+		externalID, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		c.externalID = externalID
+
+	case lowerPath == "displayname" || lowerPath == "name.formatted":
+		name, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		c.displayName = name
+
+	case lowerPath == "name":
+		var name models.SCIMName
+		if err := json.Unmarshal(value, &name); err != nil {
+			return scimError(http.StatusBadRequest, "invalidValue", "name must be an object")
+		}
+		if formatted := scimFormattedName(&name); formatted != "" {
+			c.displayName = formatted
+		}
+
+	case lowerPath == "name.givenname" || lowerPath == "name.familyname":
+		// Users only have a display name, which the formatted name or
+		// displayName sets
+
+	case lowerPath == "username" || (strings.HasPrefix(lowerPath, "emails[") && strings.HasSuffix(lowerPath, ".value")):
+		email, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		return c.setEmail(email)
+
+	case lowerPath == "emails":
+		resource := models.SCIMUserResource{}
+		if err := json.Unmarshal(value, &resource.Emails); err != nil {
+			return scimError(http.StatusBadRequest, "invalidValue", "emails must be a list")
+		}
+		return c.setEmail(resource.PrimaryEmail())
+
+	default:
+		return scimError(http.StatusBadRequest, "invalidPath", "unsupported path: "+path)
+	}
+
+	return nil
+}
+
+// setEmail only accepts the current address. Users change their email
+// themselves, as it is shared by every account they belong to.
+func (c *scimUserChanges) setEmail(email string) error {
+	if email != "" && normalizeEmail(email) != c.email {
+		return scimError(http.StatusBadRequest, "mutability", "email addresses can only be changed by the user")
+	}
+	return nil
+}
+
+type scimFilterClause struct {
+	attribute string
+	operator  string
+	value     interface{} // string, bool or nil
+}
+
+// where adds the clause to a query on the column it filters
+func (c scimFilterClause) where(query *gorm.DB, column scimColumn) (*gorm.DB, error) {
+	if column.boolean {
+		if c.operator == "pr" {
+			return query, nil
+		}
+		value, ok := c.value.(bool)
+		if !ok || (c.operator != "eq" && c.operator != "ne") {
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", c.attribute+" only supports eq and ne with true or false")
+		}
+		if c.operator == "ne" {
+			value = !value
+		}
+		return query.Where(column.name+" = ?", value), nil
+	}
+
+	if c.operator == "pr" {
+		return query.Where(column.name + " IS NOT NULL AND " + column.name + " <> ''"), nil
+	}
+
+	value, ok := c.value.(string)
+	if !ok {
+		return nil, scimError(http.StatusBadRequest, "invalidFilter", c.attribute+" must be compared with a string")
+	}
+
+	switch c.operator {
+	case "eq":
+		return query.Where(column.name+" = ?", value), nil
+	case "ne":
+		return query.Where(column.name+" <> ?", value), nil
+	case "co":
+		return query.Where(column.name+" LIKE ?", "%"+escapeLike(value)+"%"), nil
+	case "sw":
+		return query.Where(column.name+" LIKE ?", escapeLike(value)+"%"), nil
+	case "ew":
+		return query.Where(column.name+" LIKE ?", "%"+escapeLike(value)), nil
+	}
+	return nil, scimError(http.StatusBadRequest, "invalidFilter", "unsupported operator: "+c.operator)
+}
+
+func (c scimFilterClause) matchGroup(group *models.SCIMGroupResource) (bool, error) {
+	switch strings.ToLower(c.attribute) {
+	case "id":
+		return c.matchString(group.ID)
+	case "displayname":
+		return c.matchString(group.DisplayName)
+	case "members", "members.value":
+		for _, member := range group.Members {
+			if ok, err := c.matchString(member.Value); ok || err != nil {
+				return ok, err
+			}
+		}
+		return c.operator == "ne", nil
+	}
+	return false, scimError(http.StatusBadRequest, "invalidFilter", "unsupported filter attribute: "+c.attribute)
+}
+
+func (c scimFilterClause) matchString(actual string) (bool, error) {
+	if c.operator == "pr" {
+		return actual != "", nil
+	}
+
+	value, ok := c.value.(string)
+	if !ok {
+		return false, scimError(http.StatusBadRequest, "invalidFilter", c.attribute+" must be compared with a string")
+	}
+	actual, value = strings.ToLower(actual), strings.ToLower(value)
+
+	switch c.operator {
+	case "eq":
+		return actual == value, nil
+	case "ne":
+		return actual != value, nil
+	case "co":
+		return strings.Contains(actual, value), nil
+	case "sw":
+		return strings.HasPrefix(actual, value), nil
+	case "ew":
+		return strings.HasSuffix(actual, value), nil
+	}
+	return false, scimError(http.StatusBadRequest, "invalidFilter", "unsupported operator: "+c.operator)
+}
+
+// Helper functions
+
+// parseSCIMFilter reads filters of the form `attribute op "value"` joined by
+// "and", which is what identity providers send. "or", grouping and value
+// path filters are rejected.
+func parseSCIMFilter(filter string) ([]scimFilterClause, error) {
+	invalid := scimError(http.StatusBadRequest, "invalidFilter", "invalid filter: "+filter)
+
+	tokens, ok := scimFilterTokens(filter)
+	if !ok {
+		return nil, invalid
+	}
+
+	clauses := make([]scimFilterClause, 0)
+	for i := 0; i < len(tokens); {
+		if len(clauses) > 0 {
+			if tokens[i].quoted || !strings.EqualFold(tokens[i].text, "and") {
+				return nil, invalid
+			}
+			i++
+		}
+		if i+1 >= len(tokens) || tokens[i].quoted || tokens[i+1].quoted {
+			return nil, invalid
+		}
+
+		clause := scimFilterClause{attribute: tokens[i].text, operator: strings.ToLower(tokens[i+1].text)}
+		i += 2
+
+		switch clause.operator {
+		case "pr":
+		case "eq", "ne", "co", "sw", "ew":
+			if i >= len(tokens) {
+				return nil, invalid
+			}
+			value := tokens[i]
+			i++
+
+			switch {
+			case value.quoted:
+				clause.value = value.text
+			case strings.EqualFold(value.text, "true"):
+				clause.value = true
+			case strings.EqualFold(value.text, "false"):
+				clause.value = false
+			case strings.EqualFold(value.text, "null"):
+				clause.value = nil
+			default:
+				return nil, invalid
+			}
+		default:
+			return nil, scimError(http.StatusBadRequest, "invalidFilter", "unsupported operator: "+clause.operator)
+		}
+
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+type scimFilterToken struct {
+	text   string
+	quoted bool
+}
+
+func scimFilterTokens(filter string) ([]scimFilterToken, bool) {
+	tokens := make([]scimFilterToken, 0)
+	runes := []rune(filter)
+
+	for i := 0; i < len(runes); {
+		switch {
+		case runes[i] == ' ' || runes[i] == '\t':
+			i++
+
+		case runes[i] == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, false
+			}
+			i++
+			tokens = append(tokens, scimFilterToken{text: b.String(), quoted: true})
+
+		default:
+			start := i
+			for i < len(runes) && runes[i] != ' ' && runes[i] != '\t' {
+				if runes[i] == '(' || runes[i] == ')' || runes[i] == '"' {
+					return nil, false
+				}
+				i++
+			}
+			tokens = append(tokens, scimFilterToken{text: string(runes[start:i])})
+		}
+	}
+	return tokens, true
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// scimPage applies the defaults and limits of RFC 7644 section 3.4.2.4
+func scimPage(startIndex, count int) (int, int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > config.SCIMMaxPageSize {
+		count = config.SCIMMaxPageSize
+	}
+	return startIndex, count
+}
+
+func scimListResponse(total, startIndex int, resources []interface{}) *models.SCIMListResponse {
+	return &models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func scimUserResource(scimUser models.SCIMUser, user models.User, role models.MembershipRole) models.SCIMUserResource {
+	active := scimUser.Active
+	created := scimUser.CreatedAt
+	lastModified := scimUser.UpdatedAt
+	if user.UpdatedAt.After(lastModified) {
+		lastModified = user.UpdatedAt
+	}
+
+	resource := models.SCIMUserResource{
+		Schemas:     []string{models.SCIMSchemaUser},
+		ID:          user.ID,
+		ExternalID:  scimUser.ExternalID,
+		UserName:    user.Email,
+		DisplayName: user.DisplayName,
+		Emails:      []models.SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &models.SCIMMeta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &lastModified,
+			Location:     scimURL("Users", user.ID),
+		},
+	}
+	if user.DisplayName != "" {
+		resource.Name = &models.SCIMName{Formatted: user.DisplayName}
+	}
+	if scimUser.Active && role != models.RoleOwner && role != "" {
+		resource.Groups = []models.SCIMReference{{
+			Value:   string(role),
+			Display: string(role),
+			Ref:     scimURL("Groups", string(role)),
+		}}
+	}
+	return resource
+}
+
+func scimDisplayName(resource models.SCIMUserResource) string {
+	if resource.DisplayName != "" {
+		return resource.DisplayName
+	}
+	if resource.Name != nil {
+		return scimFormattedName(resource.Name)
+	}
+	return ""
+}
+
+func scimFormattedName(name *models.SCIMName) string {
+	if name.Formatted != "" {
+		return name.Formatted
+	}
+	return strings.TrimSpace(name.GivenName + " " + name.FamilyName)
+}
+
+func scimGroupRole(groupID string) (models.MembershipRole, error) {
+	for _, role := range scimGroupRoles {
+		if string(role) == groupID {
+			return role, nil
+		}
+	}
+	return "", scimError(http.StatusNotFound, "", "group not found")
+}
+
+func checkSCIMGroupName(role models.MembershipRole, name string) error {
+	if name != "" && !strings.EqualFold(name, string(role)) {
+		return scimError(http.StatusBadRequest, "mutability", "groups are the account roles and cannot be renamed")
+	}
+	return nil
+}
+
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	// Some identity providers send booleans as strings
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, scimError(http.StatusBadRequest, "invalidValue", "active must be a boolean")
+}
+
+func scimString(path string, value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", scimError(http.StatusBadRequest, "invalidValue", path+" must be a string")
+	}
+	return s, nil
+}
+
+func scimURL(resourceType, id string) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%s", config.APIBaseURL(), resourceType, id)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/models"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func (s *AccountService) CreateServiceAccount(accountID string, principal models.Principal, req models.CreateServiceAccountRequest) (*models.ServiceAccountResponse, error) {
+	defer s.trace("AccountService.CreateServiceAccount")()
+	if err := s.authorize(accountID, principal, models.PermServiceAccountsManage); err != nil {
+		return nil, err
+	}
+
+	role := req.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+	if err := s.authorizeRoleGrant(accountID, principal, role); err != nil {
+		return nil, err
+	}
+
+	secret := generateSecureToken(32)
+	serviceAccount := models.ServiceAccount{
+		AccountID:        accountID,
+		Name:             req.Name,
+		Role:             role,
+		ClientID:         config.ServiceAccountClientIDPrefix + generateSecureHex(12),
+		ClientSecretHash: hashSecret(secret),
+		CreatedByID:      principal.ID,
+	}
+
+	if err := s.db.Create(&serviceAccount).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditServiceAccountCreated, "service_account", serviceAccount.ID, map[string]interface{}{
+		"name": serviceAccount.Name,
+		"role": role,
+	})
+
+	return &models.ServiceAccountResponse{
+		ServiceAccount: serviceAccount,
+		ClientSecret:   secret,
+	}, nil
+}
+
+func (s *AccountService) ListServiceAccounts(accountID string, principal models.Principal) (*models.ServiceAccountListResponse, error) {
+	defer s.trace("AccountService.ListServiceAccounts")()
+	if err := s.authorize(accountID, principal, models.PermServiceAccountsManage); err != nil {
+		return nil, err
+	}
+
+	var serviceAccounts []models.ServiceAccount
+	if err := s.db.Where("account_id = ?", accountID).Order("created_at desc").Find(&serviceAccounts).Error; err != nil {
+		return nil, err
+	}
+
+	if serviceAccounts == nil {
+		serviceAccounts = []models.ServiceAccount{}
+	}
+	return &models.ServiceAccountListResponse{ServiceAccounts: serviceAccounts}, nil
+}
+
+func (s *AccountService) UpdateServiceAccountRole(accountID, serviceAccountID string, principal models.Principal, role models.MembershipRole) (*models.ServiceAccount, error) {
+	defer s.trace("AccountService.UpdateServiceAccountRole")()
+	if err := s.authorize(accountID, principal, models.PermServiceAccountsManage); err != nil {
+		return nil, err
+	}
+
+	// A machine principal must not be able to raise its own privileges
+	if principal.Type == models.PrincipalServiceAccount && principal.ID == serviceAccountID {
+		return nil, ErrNotAuthorized
+	}
+
+	if err := s.authorizeRoleGrant(accountID, principal, role); err != nil {
+		return nil, err
+	}
+
+	serviceAccount, err := s.findServiceAccount(accountID, serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nor demote a service account that holds more than the caller does
+	if err := s.authorizeRoleGrant(accountID, principal, serviceAccount.Role); err != nil {
+		return nil, err
+	}
+
+	previousRole := serviceAccount.Role
+	serviceAccount.Role = role
+	if err := s.db.Save(serviceAccount).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditServiceAccountUpdated, "service_account", serviceAccount.ID, map[string]interface{}{
+		"previous_role": previousRole,
+		"role":          role,
+	})
+	return serviceAccount, nil
+}
+
+// RotateServiceAccountSecret replaces the client secret, invalidating the old
+// one for future token requests
+func (s *AccountService) RotateServiceAccountSecret(accountID, serviceAccountID string, principal models.Principal) (*models.ServiceAccountResponse, error) {
+	defer s.trace("AccountService.RotateServiceAccountSecret")()
+	if err := s.authorize(accountID, principal, models.PermServiceAccountsManage); err != nil {
+		return nil, err
+	}
+
+	serviceAccount, err := s.findServiceAccount(accountID, serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The new secret carries the service account's role to whoever holds it
+	if err := s.authorizeRoleGrant(accountID, principal, serviceAccount.Role); err != nil {
+		return nil, err
+	}
+
+	secret := generateSecureToken(32)
+	serviceAccount.ClientSecretHash = hashSecret(secret)
+	if err := s.db.Save(serviceAccount).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditServiceAccountRotated, "service_account", serviceAccount.ID, nil)
+
+	return &models.ServiceAccountResponse{
+		ServiceAccount: *serviceAccount,
+		ClientSecret:   secret,
+	}, nil
+}
+
+// DisableServiceAccount stops the service account from obtaining tokens and
+// rejects tokens it already holds
+func (s *AccountService) DisableServiceAccount(accountID, serviceAccountID string, principal models.Principal) error {
+	defer s.trace("AccountService.DisableServiceAccount")()
+	if err := s.authorize(accountID, principal, models.PermServiceAccountsManage); err != nil {
+		return err
+	}
+
+	serviceAccount, err := s.findServiceAccount(accountID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+
+	if serviceAccount.DisabledAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	serviceAccount.DisabledAt = &now
+	if err := s.db.Save(serviceAccount).Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditServiceAccountDisabled, "service_account", serviceAccount.ID, nil)
+	return nil
+}
+
+// IssueClientCredentialsToken implements the OAuth2 client credentials grant.
+// The requested scope is narrowed to what the service account's role allows;
+// an empty scope grants all of it.
+func (s *AccountService) IssueClientCredentialsToken(clientID, clientSecret, scope string) (*models.OAuthTokenResponse, error) {
+	defer s.trace("AccountService.IssueClientCredentialsToken")()
+	var serviceAccount models.ServiceAccount
+	if err := s.db.Where("client_id = ?", clientID).First(&serviceAccount).Error; err != nil {
+		return nil, ErrInvalidClient
+	}
+
+	if subtle.ConstantTimeCompare([]byte(serviceAccount.ClientSecretHash), []byte(hashSecret(clientSecret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+
+	if serviceAccount.DisabledAt != nil {
+		return nil, ErrInvalidClient
+	}
+
+	scopes := make([]string, 0)
+	if scope == "" {
+		for _, permission := range models.RolePermissions[serviceAccount.Role] {
+			scopes = append(scopes, string(permission))
+		}
+	} else {
+		for _, requested := range strings.Fields(scope) {
+			if !serviceAccount.Role.Can(models.Permission(requested)) {
+				return nil, ErrInvalidScope
+			}
+			scopes = append(scopes, requested)
+		}
+	}
+
+	expiresAt := time.Now().Add(config.ServiceAccountTokenTTL)
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["principal_type"] = string(models.PrincipalServiceAccount)
+	claims["sub"] = serviceAccount.ID
+	claims["account_id"] = serviceAccount.AccountID
+	claims["scope"] = strings.Join(scopes, " ")
+	claims["exp"] = expiresAt.Unix()
+
+	tokenString, err := token.SignedString([]byte(config.JWTSecretKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	return &models.OAuthTokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(config.ServiceAccountTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// authorizeRoleGrant keeps a principal from handing a service account more
+// than it holds itself, like API key scopes. Only owners and admins, never
+// keys or other service accounts, grant the admin role
+func (s *AccountService) authorizeRoleGrant(accountID string, principal models.Principal, role models.MembershipRole) error {
+	if role == models.RoleAdmin && !principal.IsUser() {
+		return apperrors.Forbidden("not_authorized", "only owners and admins can grant the admin role")
+	}
+	for _, permission := range models.RolePermissions[role] {
+		if !s.can(accountID, principal, permission) {
+			return apperrors.Forbidden("not_authorized", fmt.Sprintf("not authorized to grant role: %s", role))
+		}
+	}
+	return nil
+}
+
+func (s *AccountService) findServiceAccount(accountID, serviceAccountID string) (*models.ServiceAccount, error) {
+	var serviceAccount models.ServiceAccount
+	if err := s.db.Where("id = ? AND account_id = ?", serviceAccountID, accountID).First(&serviceAccount).Error; err != nil {
+		return nil, apperrors.NotFound("service_account_not_found", "service account not found")
+	}
+	return &serviceAccount, nil
+}
+
+// serviceAccountCan checks a service account principal against its current
+// role, so role changes and disabling apply to tokens already issued
+func (s *AccountService) serviceAccountCan(accountID string, principal models.Principal, permission models.Permission) bool {
+	if principal.AccountID != accountID || !principal.HasScope(permission) {
+		return false
+	}
+
+	var serviceAccount models.ServiceAccount
+	if err := s.db.Where("id = ? AND account_id = ?", principal.ID, accountID).First(&serviceAccount).Error; err != nil {
+		return false
+	}
+	return serviceAccount.DisabledAt == nil && serviceAccount.Role.Can(permission)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"go-backend/config"
+	"go-backend/models"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestIssueClientCredentialsToken(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+
+	created, err := s.CreateServiceAccount(accountID, owner, models.CreateServiceAccountRequest{Name: "ci"})
+	if err != nil {
+		t.Fatalf("CreateServiceAccount: %v", err)
+	}
+	disabled, err := s.CreateServiceAccount(accountID, owner, models.CreateServiceAccountRequest{Name: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DisableServiceAccount(accountID, disabled.ID, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		clientID     string
+		clientSecret string
+		scope        string
+		want         error
+		wantScope    string
+	}{
+		{
+			name:         "role's permissions by default",
+			clientID:     created.ClientID,
+			clientSecret: created.ClientSecret,
+			wantScope:    "account:read invitations:write",
+		},
+		{
+			name:         "narrowed scope",
+			clientID:     created.ClientID,
+			clientSecret: created.ClientSecret,
+			scope:        "account:read",
+			wantScope:    "account:read",
+		},
+		{
+			name:         "scope beyond the role",
+			clientID:     created.ClientID,
+			clientSecret: created.ClientSecret,
+			scope:        "account:read members:manage",
+			want:         ErrInvalidScope,
+		},
+		{
+			name:         "wrong secret",
+			clientID:     created.ClientID,
+			clientSecret: disabled.ClientSecret,
+			want:         ErrInvalidClient,
+		},
+		{
+			name:         "unknown client",
+			clientID:     "sa_unknown",
+			clientSecret: created.ClientSecret,
+			want:         ErrInvalidClient,
+		},
+		{
+			name:         "disabled service account",
+			clientID:     disabled.ClientID,
+			clientSecret: disabled.ClientSecret,
+			want:         ErrInvalidClient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := s.IssueClientCredentialsToken(tt.clientID, tt.clientSecret, tt.scope)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("error = %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("token refused: %v", err)
+			}
+			if response.Scope != tt.wantScope {
+				t.Errorf("scope = %q, want %q", response.Scope, tt.wantScope)
+			}
+
+			claims := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(response.AccessToken, claims, func(*jwt.Token) (interface{}, error) {
+				return []byte(config.JWTSecretKey), nil
+			}); err != nil {
+				t.Fatalf("token does not verify: %v", err)
+			}
+			if claims["principal_type"] != string(models.PrincipalServiceAccount) ||
+				claims["sub"] != created.ID || claims["account_id"] != accountID || claims["scope"] != tt.wantScope {
+				t.Errorf("claims = %v, want a service account token of %s", claims, created.ID)
+			}
+		})
+	}
+}
+
+func TestRotateServiceAccountSecret(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+
+	created, err := s.CreateServiceAccount(accountID, owner, models.CreateServiceAccountRequest{Name: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := s.RotateServiceAccountSecret(accountID, created.ID, owner)
+	if err != nil {
+		t.Fatalf("RotateServiceAccountSecret: %v", err)
+	}
+	if rotated.ClientSecret == created.ClientSecret || rotated.ClientID != created.ClientID {
+		t.Fatalf("rotation kept the secret or changed the client ID")
+	}
+	if _, err := s.IssueClientCredentialsToken(created.ClientID, created.ClientSecret, ""); !errors.Is(err, ErrInvalidClient) {
+		t.Errorf("old secret still accepted: %v", err)
+	}
+	if _, err := s.IssueClientCredentialsToken(created.ClientID, rotated.ClientSecret, ""); err != nil {
+		t.Errorf("new secret refused: %v", err)
+	}
+}
+
+func TestRotateServiceAccountSecretNeedsItsRole(t *testing.T) {
+	t.Setenv("AUDIT_CHAIN_KEY", "test-key")
+	db := newTestDB(t)
+	s := &AccountService{db: db, log: slog.Default()}
+	accountID, owner := createTestAccount(t, db, models.RoleOwner)
+
+	admin, err := s.CreateServiceAccount(accountID, owner, models.CreateServiceAccountRequest{Name: "deploy", Role: models.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key holding every admin permission still is no owner or admin, and
+	// must not get the admin service account's credentials
+	apiKey := models.Principal{
+		Type:      models.PrincipalAPIKey,
+		ID:        "key",
+		AccountID: accountID,
+		Scopes:    models.RolePermissions[models.RoleAdmin],
+	}
+	if _, err := s.RotateServiceAccountSecret(accountID, admin.ID, apiKey); err == nil || !strings.Contains(err.Error(), "admin role") {
+		t.Errorf("API key rotated an admin service account's secret: %v", err)
+	}
+	if _, err := s.IssueClientCredentialsToken(admin.ClientID, admin.ClientSecret, ""); err != nil {
+		t.Errorf("refused rotation changed the secret: %v", err)
+	}
+}
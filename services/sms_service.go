@@ -10,6 +10,7 @@ import (
 
 type SMSService struct {
 	client *twilio.RestClient
+	from   string
 }
 
 func NewSMSService() *SMSService {
@@ -17,21 +18,24 @@ func NewSMSService() *SMSService {
 
 	return &SMSService{
 		client: client,
+		from:   os.Getenv("TWILIO_FROM_PHONE"),
 	}
 }
 
+// Configured reports whether Twilio credentials and a sender number have
+// been set up
+func (s *SMSService) Configured() bool {
+	return os.Getenv("TWILIO_ACCOUNT_SID") != "" && s.from != ""
+}
+
 func (s *SMSService) SendVerificationCode(to, code string, template string) error {
 	params := &twilioApi.CreateMessageParams{}
 	params.SetTo(to)
-	params.SetFrom(os.Getenv("TWILIO_PHONE_NUMBER"))
+	params.SetFrom(s.from)
 	params.SetBody(fmt.Sprintf(template, code))
 
-	_, err := s.client.Api.CreateMessage(params)
-	if err != nil {
-		fmt.Println(err.Error())
-	} else {
-		fmt.Println("SMS sent successfully!")
+	if _, err := s.client.Api.CreateMessage(params); err != nil {
+		return fmt.Errorf("failed to send SMS: %v", err)
 	}
-
 	return nil
 }
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/metrics"
+	"go-backend/models"
+	"go-backend/tracing"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+)
+
+type SocialLoginService struct {
+	db        *gorm.DB
+	client    models.ClientInfo
+	log       *slog.Logger
+	providers map[string]config.OAuthProvider
+}
+
+var (
+	oauthProviders     map[string]config.OAuthProvider
+	oauthProvidersOnce sync.Once
+)
+
+func NewSocialLoginService() *SocialLoginService {
+	oauthProvidersOnce.Do(func() {
+		oauthProviders = config.LoadOAuthProviders()
+	})
+	return &SocialLoginService{db: config.DB, log: slog.Default(), providers: oauthProviders}
+}
+
+// WithClient sets where the request came from, for the audit log, the
+// request's log lines and its trace
+func (s *SocialLoginService) WithClient(client models.ClientInfo) *SocialLoginService {
+	s.client = client
+	s.log = requestLogger(client)
+	s.db = tracing.WithContext(s.db, client.Context)
+	return s
+}
+
+// StartLogin returns the provider URL to send the browser to, and the state
+// the browser must present again when it comes back
+func (s *SocialLoginService) StartLogin(providerName string) (authURL, state string, err error) {
+	return s.start("", providerName, loginRedirectURI(providerName))
+}
+
+// StartLink is StartLogin for a signed in user adding a provider to their user
+func (s *SocialLoginService) StartLink(userID, providerName string) (authURL, state string, err error) {
+	return s.start(userID, providerName, linkRedirectURI(providerName))
+}
+
+// CompleteLogin signs in the user linked to the provider identity. An
+// identity nobody has linked yet either links to the user with the same
+// verified email or continues with the SocialRegistration flow.
+func (s *SocialLoginService) CompleteLogin(providerName string, req models.OAuthCallbackRequest, browserState string) (*models.AuthResponse, error) {
+	oauthState, err := s.consumeState(providerName, req.State, browserState)
+	if err != nil {
+		return nil, err
+	}
+	if oauthState.UserID != "" {
+		return nil, ErrInvalidLoginAttempt
+	}
+
+	provider := s.providers[providerName]
+	external, err := fetchExternalIdentity(provider, req.Code, loginRedirectURI(providerName), oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		s.log.Warn("Social login failed", "provider", providerName, "error", err)
+		return nil, ErrProviderSignInFailed
+	}
+
+	if err := ssoRequired(s.db, external.Email); err != nil {
+		return nil, err
+	}
+
+	authService := &AuthService{db: s.db, client: s.client, log: s.log}
+	method := map[string]interface{}{"method": "oauth:" + providerName}
+
+	var identity models.Identity
+	if err := s.db.Where("provider = ? AND subject = ?", providerName, external.Subject).First(&identity).Error; err == nil {
+		var user models.User
+		if err := s.db.First(&user, "id = ?", identity.UserID).Error; err != nil {
+			return nil, ErrUserNotFound
+		}
+
+		if err := ssoRequired(s.db, user.Email); err != nil {
+			return nil, err
+		}
+
+		s.touchIdentity(&identity, external.Email)
+		authService.bindPendingInvitations(user)
+		authService.audit(models.AuditLoginSucceeded, user.ID, user.ID, method)
+		publish(s.db, events.UserLoggedIn{UserID: user.ID, Method: "oauth:" + providerName})
+		return authService.completeLogin(user, "oauth")
+	}
+
+	if external.Email != "" {
+		var user models.User
+		if err := s.db.Where("email = ?", external.Email).First(&user).Error; err == nil {
+			// Linking on an email nobody has proven would hand the account to
+			// whoever registered it first, or let the provider claim any address
+			if !external.EmailVerified || !user.EmailVerified {
+				return nil, apperrors.Conflict("user_exists", fmt.Sprintf("a user with this email already exists, sign in with your password and link %s from your settings", providerName))
+			}
+
+			identity = models.Identity{UserID: user.ID, Provider: providerName, Subject: external.Subject, Email: external.Email}
+			if err := s.db.Create(&identity).Error; err != nil {
+				return nil, fmt.Errorf("failed to link identity: %v", err)
+			}
+			s.touchIdentity(&identity, external.Email)
+			authService.bindPendingInvitations(user)
+			authService.audit(models.AuditLoginSucceeded, user.ID, user.ID, method)
+			publish(s.db, events.UserLoggedIn{UserID: user.ID, Method: "oauth:" + providerName})
+			return authService.completeLogin(user, "oauth")
+		}
+	}
+
+	registrationToken, err := generateSocialRegistrationToken(providerName, external)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthResponse{
+		NextFlow:          "SocialRegistration",
+		PrevFlow:          "SocialLogin",
+		RegistrationToken: registrationToken,
+	}, nil
+}
+
+// Register creates a user for a provider identity. Like a password
+// registration it continues with phone verification.
+func (s *SocialLoginService) Register(req models.SocialRegisterRequest) (*models.AuthResponse, error) {
+	providerName, external, err := parseSocialRegistrationToken(req.RegistrationToken)
+	if err != nil {
+		return nil, apperrors.Validation("invalid_registration_token", "invalid or expired registration token")
+	}
+
+	var identity models.Identity
+	if err := s.db.Where("provider = ? AND subject = ?", providerName, external.Subject).First(&identity).Error; err == nil {
+		return nil, apperrors.Conflict("identity_linked", "this identity is already linked to a user")
+	}
+
+	if external.Email == "" {
+		return nil, apperrors.Validation("email_not_shared", fmt.Sprintf("%s did not share an email address", providerName))
+	}
+
+	var existingUser models.User
+	if err := s.db.Where("email = ? OR username = ? OR phone = ?", external.Email, req.Username, req.Phone).
+		First(&existingUser).Error; err == nil {
+		return nil, apperrors.Conflict("user_exists", "user with this email, username or phone already exists")
+	}
+
+	// The user signs in through the provider; a password can be set later
+	// through the reset flow
+	unusablePassword, err := GetPasswordHasher().Hash(generateSecureToken(32))
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	verificationCode := generateRandomCode()
+	user := models.User{
+		Username:         req.Username,
+		Email:            external.Email,
+		Password:         unusablePassword,
+		Phone:            &req.Phone,
+		VerificationCode: verificationCode,
+		EmailVerified:    external.EmailVerified,
+	}
+
+	tx := beginTx(s.db)
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	now := time.Now()
+	identity = models.Identity{
+		UserID:      user.ID,
+		Provider:    providerName,
+		Subject:     external.Subject,
+		Email:       external.Email,
+		LastLoginAt: &now,
+	}
+	if err := tx.Create(&identity).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to link identity: %v", err)
+	}
+	publish(tx, events.UserRegistered{User: user, Method: "oauth:" + providerName})
+	if err := commitTx(tx); err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	authService := &AuthService{db: s.db, client: s.client, log: s.log}
+	authService.bindPendingInvitations(user)
+	authService.audit(models.AuditUserRegistered, user.ID, user.ID, map[string]interface{}{"method": "oauth:" + providerName})
+	metrics.Registrations.WithLabelValues("oauth").Inc()
+
+	return &models.AuthResponse{
+		NextFlow: "PhoneVerification",
+		PrevFlow: "SocialRegistration",
+	}, nil
+}
+
+// CompleteLink adds the provider identity to the signed in user that started
+// the link
+func (s *SocialLoginService) CompleteLink(userID, providerName string, req models.OAuthCallbackRequest, browserState string) (*models.Identity, error) {
+	oauthState, err := s.consumeState(providerName, req.State, browserState)
+	if err != nil {
+		return nil, err
+	}
+	if oauthState.UserID != userID {
+		return nil, ErrInvalidLoginAttempt
+	}
+
+	provider := s.providers[providerName]
+	external, err := fetchExternalIdentity(provider, req.Code, linkRedirectURI(providerName), oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		s.log.Warn("Linking identity failed", "provider", providerName, "error", err)
+		return nil, ErrProviderSignInFailed
+	}
+
+	var identity models.Identity
+	if err := s.db.Where("provider = ? AND subject = ?", providerName, external.Subject).First(&identity).Error; err == nil {
+		if identity.UserID == userID {
+			return &identity, nil
+		}
+		return nil, apperrors.Conflict("identity_linked", fmt.Sprintf("this %s account is already linked to another user", providerName))
+	}
+
+	if err := s.db.Where("user_id = ? AND provider = ?", userID, providerName).First(&identity).Error; err == nil {
+		return nil, apperrors.Conflict("provider_already_linked", fmt.Sprintf("a %s account is already linked, unlink it first", providerName))
+	}
+
+	identity = models.Identity{
+		UserID:   userID,
+		Provider: providerName,
+		Subject:  external.Subject,
+		Email:    external.Email,
+	}
+	if err := s.db.Create(&identity).Error; err != nil {
+		return nil, fmt.Errorf("failed to link identity: %v", err)
+	}
+
+	return &identity, nil
+}
+
+func (s *SocialLoginService) ListIdentities(userID string) (*models.IdentityListResponse, error) {
+	var identities []models.Identity
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+	return &models.IdentityListResponse{Identities: identities}, nil
+}
+
+// Unlink removes the user's identity at a provider. Users keep their
+// password, so they can always sign in without it.
+func (s *SocialLoginService) Unlink(userID, providerName string) error {
+	result := s.db.Where("user_id = ? AND provider = ?", userID, providerName).Delete(&models.Identity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return apperrors.NotFound("identity_not_found", "identity not found")
+	}
+	return nil
+}
+
+func (s *SocialLoginService) start(userID, providerName, redirectURI string) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state := generateSecureToken(32)
+	oauthState := models.OAuthState{
+		StateHash:    hashSecret(state),
+		Provider:     providerName,
+		Nonce:        generateSecureToken(16),
+		CodeVerifier: generateSecureToken(32),
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(config.OAuthStateTTL),
+	}
+
+	authURL, err := authorizationURL(provider, redirectURI, state, oauthState.Nonce, oauthState.CodeVerifier)
+	if err != nil {
+		s.log.Error("Failed to start social login", "provider", providerName, "error", err)
+		return "", "", errors.New("provider is unavailable")
+	}
+
+	if err := s.db.Create(&oauthState).Error; err != nil {
+		return "", "", fmt.Errorf("failed to start login: %v", err)
+	}
+
+	return authURL, state, nil
+}
+
+// consumeState checks the state the provider sent back against the one kept
+// in the browser, which stops login CSRF, and makes it single use
+func (s *SocialLoginService) consumeState(providerName, state, browserState string) (*models.OAuthState, error) {
+	if _, ok := s.providers[providerName]; !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	if state == "" || state != browserState {
+		return nil, ErrInvalidLoginAttempt
+	}
+
+	var oauthState models.OAuthState
+	if err := s.db.Where("state_hash = ? AND provider = ?", hashSecret(state), providerName).First(&oauthState).Error; err != nil {
+		return nil, ErrInvalidLoginAttempt
+	}
+
+	result := s.db.Delete(&oauthState)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 || oauthState.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidLoginAttempt
+	}
+
+	return &oauthState, nil
+}
+
+func (s *SocialLoginService) touchIdentity(identity *models.Identity, email string) {
+	now := time.Now()
+	if err := s.db.Model(identity).UpdateColumns(map[string]interface{}{
+		"last_login_at": now,
+		"email":         email,
+	}).Error; err != nil {
+		s.log.Error("Failed to update identity", "identity_id", identity.ID, "error", err)
+	}
+}
+
+// Helper functions
+
+func loginRedirectURI(providerName string) string {
+	return fmt.Sprintf("%s/auth/oauth/%s/callback", appBaseURL(), providerName)
+}
+
+func linkRedirectURI(providerName string) string {
+	return fmt.Sprintf("%s/settings/identities/%s/callback", appBaseURL(), providerName)
+}
+
+func generateSocialRegistrationToken(providerName string, external *externalIdentity) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["purpose"] = "social_registration"
+	claims["provider"] = providerName
+	claims["sub"] = external.Subject
+	claims["email"] = external.Email
+	claims["email_verified"] = external.EmailVerified
+	claims["exp"] = time.Now().Add(config.SocialRegistrationTTL).Unix()
+
+	tokenString, err := token.SignedString([]byte(config.JWTSecretKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign registration token: %v", err)
+	}
+
+	return tokenString, nil
+}
+
+func parseSocialRegistrationToken(tokenString string) (string, *externalIdentity, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(config.JWTSecretKey), nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != "social_registration" {
+		return "", nil, errors.New("invalid registration token")
+	}
+
+	providerName := claimString(claims, "provider")
+	external := &externalIdentity{
+		Subject:       claimString(claims, "sub"),
+		Email:         claimString(claims, "email"),
+		EmailVerified: claimBool(claims, "email_verified"),
+	}
+	if providerName == "" || external.Subject == "" {
+		return "", nil, errors.New("invalid registration token")
+	}
+
+	return providerName, external, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"go-backend/models"
+	"go-backend/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-backend/services")
+
+// trace starts a span for an AuthService method. Database calls and nested
+// spans become its children until the returned function ends it.
+func (s *AuthService) trace(name string) func() {
+	client, db := s.client, s.db
+	ctx, span := tracer.Start(requestContext(client), name)
+	s.client.Context = ctx
+	s.db = tracing.WithContext(db, ctx)
+
+	return func() {
+		span.End()
+		s.client, s.db = client, db
+	}
+}
+
+// trace starts a span for an AccountService method, see AuthService.trace
+func (s *AccountService) trace(name string) func() {
+	client, db := s.client, s.db
+	ctx, span := tracer.Start(requestContext(client), name)
+	s.client.Context = ctx
+	s.db = tracing.WithContext(db, ctx)
+
+	return func() {
+		span.End()
+		s.client, s.db = client, db
+	}
+}
+
+// Helper functions
+
+// requestContext is the context of the request the client made, which
+// carries its trace
+func requestContext(client models.ClientInfo) context.Context {
+	if client.Context == nil {
+		return context.Background()
+	}
+	return client.Context
+}
+
+// endSpan ends a span around a call to an outside service, marking it failed
+// when the call was
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
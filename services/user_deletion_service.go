@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/events"
+	"go-backend/models"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type UserDeletionService struct {
+	db  *gorm.DB
+	log *slog.Logger
+}
+
+func NewUserDeletionService() *UserDeletionService {
+	return &UserDeletionService{db: config.DB, log: slog.Default()}
+}
+
+// ownedAccountsError lists the owned accounts a deletion request did not
+// decide about
+func ownedAccountsError(accountIDs []string) error {
+	return apperrors.Conflict("owned_accounts", "decide whether to transfer or delete these accounts first: "+strings.Join(accountIDs, ", ")).
+		With("owned_accounts", accountIDs)
+}
+
+// RequestDeletion schedules the user for deletion after the grace period.
+// Owned accounts are transferred or deleted when the user is purged.
+//
+// The user confirms it is them with their password, or by having logged in
+// moments ago. Users who sign in through SSO, a social provider or magic
+// links may have no password, but a fresh login works for every method.
+func (s *UserDeletionService) RequestDeletion(userID string, authTime time.Time, req models.DeleteUserRequest) (*models.UserDeletion, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	if req.Password != "" {
+		if ok, _ := GetPasswordHasher().Verify(req.Password, user.Password); !ok {
+			return nil, ErrInvalidPassword
+		}
+	} else if time.Since(authTime) > config.DeletionReauthenticationWindow {
+		return nil, ErrReauthenticationNeeded
+	}
+
+	if _, err := s.pendingDeletion(userID); err == nil {
+		return nil, apperrors.Conflict("deletion_scheduled", "deletion is already scheduled")
+	}
+
+	if err := s.checkDispositions(userID, req.OwnedAccounts); err != nil {
+		return nil, err
+	}
+
+	actions, err := json.Marshal(req.OwnedAccounts)
+	if err != nil {
+		return nil, err
+	}
+
+	deletion := models.UserDeletion{
+		UserID:         userID,
+		AccountActions: string(actions),
+		PurgeAt:        time.Now().Add(config.UserDeletionGracePeriod()),
+	}
+	if err := s.db.Create(&deletion).Error; err != nil {
+		return nil, fmt.Errorf("failed to schedule deletion: %v", err)
+	}
+
+	publish(s.db, events.UserDeletionScheduled{Deletion: deletion, Email: user.Email})
+
+	return &deletion, nil
+}
+
+func (s *UserDeletionService) CancelDeletion(userID string) error {
+	deletion, err := s.pendingDeletion(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deletion.CancelledAt = &now
+	return s.db.Save(deletion).Error
+}
+
+// PurgeDueDeletions deletes every user whose grace period has ended. A user
+// whose owned accounts can no longer be handled as planned stays scheduled.
+func (s *UserDeletionService) PurgeDueDeletions() (int, error) {
+	var deletions []models.UserDeletion
+	if err := s.db.Where("purge_at < ? AND cancelled_at IS NULL AND completed_at IS NULL", time.Now()).
+		Find(&deletions).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range deletions {
+		if err := s.purge(&deletions[i]); err != nil {
+			s.log.Error("Failed to purge user", "user_id", deletions[i].UserID, "error", err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Export collects everything stored about the user
+func (s *UserDeletionService) Export(userID string) (*models.UserExport, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	export := &models.UserExport{
+		ExportedAt:          time.Now(),
+		User:                user,
+		Memberships:         []models.Membership{},
+		OwnedAccounts:       []models.Account{},
+		InvitationsReceived: []models.Invitation{},
+		InvitationsSent:     []models.Invitation{},
+		AccessRequests:      []models.AccessRequest{},
+		ContactChanges:      []models.ContactChange{},
+		APIKeysCreated:      []models.APIKey{},
+		Identities:          []models.Identity{},
+		SecurityEvents:      []models.AuditEventResponse{},
+	}
+
+	queries := []struct {
+		dest  interface{}
+		query string
+		args  []interface{}
+	}{
+		{&export.Memberships, "user_id = ?", []interface{}{userID}},
+		{&export.OwnedAccounts, "owner_id = ?", []interface{}{userID}},
+		{&export.InvitationsReceived, "user_id = ? OR email = ?", []interface{}{userID, normalizeEmail(user.Email)}},
+		{&export.InvitationsSent, "inviter_id = ?", []interface{}{userID}},
+		{&export.AccessRequests, "user_id = ?", []interface{}{userID}},
+		{&export.ContactChanges, "user_id = ?", []interface{}{userID}},
+		{&export.APIKeysCreated, "created_by_id = ?", []interface{}{userID}},
+		{&export.Identities, "user_id = ?", []interface{}{userID}},
+	}
+	for _, q := range queries {
+		if err := s.db.Where(q.query, q.args...).Order("created_at").Find(q.dest).Error; err != nil {
+			return nil, fmt.Errorf("database error: %v", err)
+		}
+	}
+
+	var events []models.AuditEvent
+	if err := s.db.Where("account_id = '' AND target_type = ? AND target_id = ?", "user", userID).
+		Order("created_at").Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+	for _, event := range events {
+		export.SecurityEvents = append(export.SecurityEvents, auditEventResponse(event))
+	}
+
+	if deletion, err := s.pendingDeletion(userID); err == nil {
+		export.PendingDeletion = deletion
+	}
+
+	return export, nil
+}
+
+func (s *UserDeletionService) pendingDeletion(userID string) (*models.UserDeletion, error) {
+	var deletion models.UserDeletion
+	if err := s.db.Where("user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL", userID).
+		First(&deletion).Error; err != nil {
+		return nil, apperrors.NotFound("no_deletion_scheduled", "no deletion is scheduled")
+	}
+	return &deletion, nil
+}
+
+// checkDispositions makes sure every account the user owns has a valid
+// decision and nothing else is listed
+func (s *UserDeletionService) checkDispositions(userID string, dispositions []models.OwnedAccountDisposition) error {
+	var owned []models.Account
+	if err := s.db.Where("owner_id = ?", userID).Find(&owned).Error; err != nil {
+		return fmt.Errorf("database error: %v", err)
+	}
+
+	decided := make(map[string]bool)
+	accountService := &AccountService{db: s.db, log: s.log}
+	for _, d := range dispositions {
+		if !ownsAccount(owned, d.AccountID) {
+			return apperrors.Validation("invalid_owned_account", fmt.Sprintf("you do not own account %s", d.AccountID))
+		}
+		if d.Action == models.OwnedAccountTransfer {
+			if d.NewOwnerID == "" || d.NewOwnerID == userID {
+				return apperrors.Validation("new_owner_required", fmt.Sprintf("account %s needs a new owner", d.AccountID))
+			}
+			if !accountService.isMember(d.AccountID, d.NewOwnerID) {
+				return apperrors.Validation("new_owner_not_member", fmt.Sprintf("new owner of account %s must be a member", d.AccountID))
+			}
+		}
+		decided[d.AccountID] = true
+	}
+
+	var missing []string
+	for _, account := range owned {
+		if !decided[account.ID] {
+			missing = append(missing, account.ID)
+		}
+	}
+	if len(missing) > 0 {
+		return ownedAccountsError(missing)
+	}
+	return nil
+}
+
+// purge carries out the owned account decisions, removes the user's own data
+// and anonymizes what other records say the user did
+func (s *UserDeletionService) purge(deletion *models.UserDeletion) error {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", deletion.UserID).Error; err != nil {
+		return ErrUserNotFound
+	}
+
+	var dispositions []models.OwnedAccountDisposition
+	if err := json.Unmarshal([]byte(deletion.AccountActions), &dispositions); err != nil {
+		return fmt.Errorf("invalid account actions: %v", err)
+	}
+
+	// Membership or ownership may have changed during the grace period
+	if err := s.checkDispositions(user.ID, dispositions); err != nil {
+		return err
+	}
+
+	tx := s.db.Begin()
+
+	for _, d := range dispositions {
+		var err error
+		if d.Action == models.OwnedAccountTransfer {
+			err = transferAccount(tx, d.AccountID, d.NewOwnerID)
+		} else {
+			err = deleteAccount(tx, d.AccountID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to %s account %s: %v", d.Action, d.AccountID, err)
+		}
+	}
+
+	owned := []interface{}{
+		&models.Membership{}, &models.AccessRequest{}, &models.ContactChange{}, &models.PasswordHistory{},
+		&models.MagicLink{}, &models.Identity{}, &models.SSOTicket{}, &models.SCIMUser{},
+	}
+	for _, model := range owned {
+		if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Where("user_id = ? OR email = ?", user.ID, normalizeEmail(user.Email)).
+		Delete(&models.Invitation{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// The user's own security events go with them. Account audit logs keep
+	// their events until they expire, with the user redacted.
+	if err := tx.Where("chain_id = ?", user.ID).Delete(&models.AuditEvent{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("id = ?", user.ID).Delete(&models.AuditChain{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := redactAuditEvents(tx, user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	references := []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.Invitation{}, "inviter_id"},
+		{&models.JoinLink{}, "created_by_id"},
+		{&models.APIKey{}, "created_by_id"},
+		{&models.ServiceAccount{}, "created_by_id"},
+		{&models.AccessRequest{}, "reviewed_by_id"},
+		{&models.WebhookEndpoint{}, "created_by_id"},
+	}
+	for _, ref := range references {
+		if err := tx.Model(ref.model).Where(ref.column+" = ?", user.ID).
+			UpdateColumn(ref.column, config.DeletedUserID).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Delete(&user).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+	deletion.CompletedAt = &now
+	if err := tx.Save(deletion).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Helper functions
+
+// notifyDeletionScheduled tells the user when they will be deleted, so they
+// can cancel if it was not them
+func notifyDeletionScheduled(email string, deletion *models.UserDeletion) {
+	body := fmt.Sprintf("Your user will be deleted on %s.\n\n"+
+		"If you did not ask for this or changed your mind, sign in and cancel the deletion before then.\n",
+		deletion.PurgeAt.Format("January 2, 2006 15:04 MST"))
+	if err := sendNotificationEmail(context.Background(), email, "Your user is scheduled for deletion", body); err != nil {
+		slog.Error("Failed to send deletion notice", "user_id", deletion.UserID, "error", err)
+	}
+}
+
+func ownsAccount(owned []models.Account, accountID string) bool {
+	for _, account := range owned {
+		if account.ID == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+func transferAccount(tx *gorm.DB, accountID, newOwnerID string) error {
+	if err := tx.Model(&models.Account{}).Where("id = ?", accountID).
+		UpdateColumn("owner_id", newOwnerID).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Membership{}).Where("account_id = ? AND user_id = ?", accountID, newOwnerID).
+		UpdateColumn("role", models.RoleOwner).Error
+}
+
+func deleteAccount(tx *gorm.DB, accountID string) error {
+	related := []interface{}{
+		&models.Membership{}, &models.Invitation{}, &models.JoinLink{}, &models.AccountDomain{},
+		&models.AccessRequest{}, &models.APIKey{}, &models.ServiceAccount{},
+		&models.SAMLConnection{}, &models.SAMLRequest{}, &models.SCIMUser{}, &models.AuditEvent{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{},
+	}
+	for _, model := range related {
+		if err := tx.Where("account_id = ?", accountID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("id = ?", accountID).Delete(&models.AuditChain{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id = ?", accountID).Delete(&models.Account{}).Error
+}
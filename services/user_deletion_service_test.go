@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"go-backend/models"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRequestDeletionReauthentication(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+
+	tests := []struct {
+		name     string
+		password string
+		authTime time.Time
+		want     error
+	}{
+		{
+			name:     "login moments ago",
+			authTime: time.Now().Add(-time.Minute),
+		},
+		{
+			name:     "stale login",
+			authTime: time.Now().Add(-time.Hour),
+			want:     ErrReauthenticationNeeded,
+		},
+		{
+			name: "token without auth_time",
+			want: ErrReauthenticationNeeded,
+		},
+		{
+			name:     "wrong password despite a fresh login",
+			password: "wrong",
+			authTime: time.Now(),
+			want:     ErrInvalidPassword,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			s := &UserDeletionService{db: db, log: slog.Default()}
+
+			// Created through SSO, so the user never had a password
+			user := models.User{Username: "sso-user", Email: "sso@example.com"}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := s.RequestDeletion(user.ID, tt.authTime, models.DeleteUserRequest{Password: tt.password})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RequestDeletion error = %v, want %v", err, tt.want)
+			}
+
+			var scheduled int
+			if err := db.Model(&models.UserDeletion{}).Where("user_id = ?", user.ID).Count(&scheduled).Error; err != nil {
+				t.Fatal(err)
+			}
+			if (scheduled == 1) != (tt.want == nil) {
+				t.Errorf("%d deletions scheduled", scheduled)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StartUserDeletionSweeper periodically purges users whose deletion grace
+// period has ended until stop is closed, then marks done once a run in
+// progress finished
+func StartUserDeletionSweeper(interval time.Duration, stop <-chan struct{}, done *sync.WaitGroup) {
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				purged, err := NewUserDeletionService().PurgeDueDeletions()
+				if err != nil {
+					slog.Error("Failed to purge deleted users", "error", err)
+				} else if purged > 0 {
+					slog.Info("Purged deleted users", "count", purged)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"go-backend/apperrors"
 	"go-backend/models"
 	"go-backend/repositories"
 )
@@ -10,7 +11,11 @@ func CreateUser(user models.User) (models.User, error) {
 }
 
 func GetUserByID(id string) (models.User, error) {
-	return repositories.GetUserByID(id)
+	user, err := repositories.GetUserByID(id)
+	if err != nil {
+		return user, ErrUserNotFound
+	}
+	return user, nil
 }
 
 func UpdateUser(id string, user models.User) (models.User, error) {
@@ -20,3 +25,62 @@ func UpdateUser(id string, user models.User) (models.User, error) {
 func DeleteUser(id string) error {
 	return repositories.DeleteUser(id)
 }
+
+// UpdateProfile applies the fields present in the request to the user's profile
+func UpdateProfile(id string, req models.UpdateProfileRequest) (models.User, error) {
+	user, err := repositories.GetUserByID(id)
+	if err != nil {
+		return user, ErrUserNotFound
+	}
+
+	if req.Username != nil && *req.Username != user.Username {
+		if _, err := repositories.GetUserByUsername(*req.Username); err == nil {
+			return user, apperrors.Conflict("username_taken", "username is already taken")
+		}
+		user.Username = *req.Username
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+	if req.AvatarURL != nil {
+		user.AvatarURL = *req.AvatarURL
+	}
+	if req.Locale != nil {
+		user.Locale = *req.Locale
+	}
+	if req.Timezone != nil {
+		user.Timezone = *req.Timezone
+	}
+
+	return repositories.UpdateUser(id, user)
+}
+
+// GetVisibleUser returns another user's public profile if the viewer shares
+// an account with them
+func GetVisibleUser(viewerID, id string) (*models.PublicUserResponse, error) {
+	if viewerID != id {
+		shared, err := repositories.UsersShareAccount(viewerID, id)
+		if err != nil {
+			return nil, err
+		}
+		// Not revealing whether the user exists at all
+		if !shared {
+			return nil, ErrUserNotFound
+		}
+	}
+
+	user, err := repositories.GetUserByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	return &models.PublicUserResponse{
+		ID:          user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		AvatarURL:   user.AvatarURL,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+	}, nil
+}
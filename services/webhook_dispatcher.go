@@ -0,0 +1,29 @@
+package services
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// StartWebhookDispatcher periodically sends the webhook deliveries that are
+// due until stop is closed, then marks done once a run in progress finished
+func StartWebhookDispatcher(interval time.Duration, stop <-chan struct{}, done *sync.WaitGroup) {
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := NewAccountService().DispatchWebhooks(); err != nil {
+					slog.Error("Failed to dispatch webhooks", "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,556 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-backend/apperrors"
+	"go-backend/config"
+	"go-backend/metrics"
+	"go-backend/models"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+var webhookHTTPClient = &http.Client{
+	Timeout: config.WebhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: config.WebhookTimeout,
+			Control: webhookDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: config.WebhookTimeout,
+	},
+	// An endpoint answers for itself, redirects could point anywhere
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func (s *AccountService) CreateWebhookEndpoint(accountID string, principal models.Principal, req models.CreateWebhookEndpointRequest) (*models.WebhookEndpointResponse, error) {
+	defer s.trace("AccountService.CreateWebhookEndpoint")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+	eventTypes, err := webhookEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := models.WebhookEndpoint{
+		AccountID:   accountID,
+		URL:         req.URL,
+		Description: req.Description,
+		EventTypes:  eventTypes,
+		Secret:      config.WebhookSecretPrefix + generateSecureToken(24),
+		CreatedByID: principal.ID,
+	}
+
+	if err := s.db.Create(&endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditWebhookCreated, "webhook", endpoint.ID, map[string]interface{}{
+		"url":         endpoint.URL,
+		"event_types": endpoint.EventTypeList(),
+	})
+
+	response := webhookEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+	return response, nil
+}
+
+func (s *AccountService) ListWebhookEndpoints(accountID string, principal models.Principal) (*models.WebhookEndpointListResponse, error) {
+	defer s.trace("AccountService.ListWebhookEndpoints")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := s.db.Where("account_id = ?", accountID).Order("created_at desc").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.WebhookEndpointListResponse{Endpoints: []models.WebhookEndpointResponse{}}
+	for _, endpoint := range endpoints {
+		response.Endpoints = append(response.Endpoints, *webhookEndpointResponse(endpoint))
+	}
+	return response, nil
+}
+
+func (s *AccountService) GetWebhookEndpoint(accountID, endpointID string, principal models.Principal) (*models.WebhookEndpointResponse, error) {
+	defer s.trace("AccountService.GetWebhookEndpoint")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	return webhookEndpointResponse(*endpoint), nil
+}
+
+func (s *AccountService) UpdateWebhookEndpoint(accountID, endpointID string, principal models.Principal, req models.UpdateWebhookEndpointRequest) (*models.WebhookEndpointResponse, error) {
+	defer s.trace("AccountService.UpdateWebhookEndpoint")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		if err := validateWebhookURL(*req.URL); err != nil {
+			return nil, err
+		}
+		endpoint.URL = *req.URL
+	}
+	if req.Description != nil {
+		endpoint.Description = *req.Description
+	}
+	if req.EventTypes != nil {
+		eventTypes, err := webhookEventTypes(req.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.EventTypes = eventTypes
+	}
+	if req.Enabled != nil {
+		if *req.Enabled && endpoint.DisabledAt != nil {
+			endpoint.DisabledAt = nil
+			endpoint.DisabledReason = ""
+			endpoint.ConsecutiveFailures = 0
+		}
+		if !*req.Enabled && endpoint.DisabledAt == nil {
+			now := time.Now()
+			endpoint.DisabledAt = &now
+			endpoint.DisabledReason = "disabled by " + string(principal.Type)
+		}
+	}
+
+	if err := s.db.Save(endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditWebhookUpdated, "webhook", endpoint.ID, map[string]interface{}{
+		"url":         endpoint.URL,
+		"event_types": endpoint.EventTypeList(),
+		"enabled":     endpoint.DisabledAt == nil,
+	})
+
+	return webhookEndpointResponse(*endpoint), nil
+}
+
+// DeleteWebhookEndpoint removes the endpoint along with its delivery log
+func (s *AccountService) DeleteWebhookEndpoint(accountID, endpointID string, principal models.Principal) error {
+	defer s.trace("AccountService.DeleteWebhookEndpoint")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return err
+	}
+
+	tx := s.db.Begin()
+	if err := tx.Where("endpoint_id = ?", endpoint.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(endpoint).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	s.audit(accountID, principal, models.AuditWebhookDeleted, "webhook", endpoint.ID, map[string]interface{}{
+		"url": endpoint.URL,
+	})
+	return nil
+}
+
+// RotateWebhookSecret replaces the signing secret. Deliveries sent from now
+// on, including retries, are signed with the new one.
+func (s *AccountService) RotateWebhookSecret(accountID, endpointID string, principal models.Principal) (*models.WebhookEndpointResponse, error) {
+	defer s.trace("AccountService.RotateWebhookSecret")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Secret = config.WebhookSecretPrefix + generateSecureToken(24)
+	if err := s.db.Save(endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	s.audit(accountID, principal, models.AuditWebhookSecretRotated, "webhook", endpoint.ID, nil)
+
+	response := webhookEndpointResponse(*endpoint)
+	response.Secret = endpoint.Secret
+	return response, nil
+}
+
+// ListWebhookDeliveries returns the endpoint's latest deliveries, optionally
+// filtered by status
+func (s *AccountService) ListWebhookDeliveries(accountID, endpointID string, principal models.Principal, status models.WebhookDeliveryStatus) (*models.WebhookDeliveryListResponse, error) {
+	defer s.trace("AccountService.ListWebhookDeliveries")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.Where("endpoint_id = ?", endpoint.ID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Order("created_at desc").Limit(config.WebhookDeliveryListSize).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+
+	response := &models.WebhookDeliveryListResponse{Deliveries: []models.WebhookDeliveryResponse{}}
+	for _, delivery := range deliveries {
+		response.Deliveries = append(response.Deliveries, *webhookDeliveryResponse(delivery))
+	}
+	return response, nil
+}
+
+// ReplayWebhookDelivery sends a delivery's event to the endpoint again as a
+// new delivery. The event keeps its ID, so receivers can tell it apart from
+// a new event.
+func (s *AccountService) ReplayWebhookDelivery(accountID, endpointID, deliveryID string, principal models.Principal) (*models.WebhookDeliveryResponse, error) {
+	defer s.trace("AccountService.ReplayWebhookDelivery")()
+	if err := s.authorize(accountID, principal, models.PermWebhooksManage); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.findWebhookEndpoint(accountID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.DisabledAt != nil {
+		return nil, apperrors.Conflict("webhook_endpoint_disabled", "webhook endpoint is disabled")
+	}
+
+	var original models.WebhookDelivery
+	if err := s.db.Where("id = ? AND endpoint_id = ?", deliveryID, endpoint.ID).First(&original).Error; err != nil {
+		return nil, apperrors.NotFound("webhook_delivery_not_found", "webhook delivery not found")
+	}
+
+	now := time.Now()
+	replay := models.WebhookDelivery{
+		EndpointID:    endpoint.ID,
+		AccountID:     accountID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+		ReplayOfID:    original.ID,
+	}
+	if err := s.db.Create(&replay).Error; err != nil {
+		return nil, err
+	}
+
+	return webhookDeliveryResponse(replay), nil
+}
+
+// DispatchWebhooks sends the deliveries that are due and returns how many it
+// attempted
+func (s *AccountService) DispatchWebhooks() (int, error) {
+	var due []models.WebhookDelivery
+	if err := s.db.Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at").Limit(config.WebhookDeliveryBatch).Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range due {
+		// Claiming the delivery keeps other instances from sending it too.
+		// Should this one stop midway, the delivery is retried once the
+		// claim runs out.
+		now := time.Now()
+		result := s.db.Model(&models.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at <= ?", due[i].ID, models.WebhookDeliveryPending, now).
+			UpdateColumn("next_attempt_at", now.Add(config.WebhookDeliveryLease))
+		if result.Error != nil {
+			return attempted, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		s.attemptWebhookDelivery(&due[i])
+		attempted++
+	}
+	return attempted, nil
+}
+
+// emitWebhook queues the event for every enabled endpoint of the account
+// subscribed to it. Deliveries are sent by the dispatcher, so slow endpoints
+// never hold up the request that caused the event.
+func (s *AccountService) emitWebhook(accountID string, eventType models.WebhookEventType, data interface{}) {
+	var endpoints []models.WebhookEndpoint
+	if err := s.db.Where("account_id = ? AND disabled_at IS NULL", accountID).Find(&endpoints).Error; err != nil {
+		s.log.Error("Failed to find webhook endpoints", "event_type", eventType, "account_id", accountID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	payload := models.WebhookPayload{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		AccountID: accountID,
+		CreatedAt: now.UTC(),
+		Data:      data,
+	}
+
+	var body []byte
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribes(eventType) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(payload); err != nil {
+				s.log.Error("Failed to encode webhook", "event_type", eventType, "error", err)
+				return
+			}
+		}
+
+		delivery := models.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			AccountID:     accountID,
+			EventID:       payload.ID,
+			EventType:     eventType,
+			Payload:       string(body),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: &now,
+		}
+		if err := s.db.Create(&delivery).Error; err != nil {
+			s.log.Error("Failed to queue webhook", "event_type", eventType, "endpoint_id", endpoint.ID, "error", err)
+		}
+	}
+}
+
+func (s *AccountService) attemptWebhookDelivery(delivery *models.WebhookDelivery) {
+	var endpoint models.WebhookEndpoint
+	if err := s.db.First(&endpoint, "id = ?", delivery.EndpointID).Error; err != nil {
+		return
+	}
+
+	now := time.Now()
+	delivery.LastAttemptAt = &now
+
+	if endpoint.DisabledAt != nil {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.Error = "webhook endpoint is disabled"
+		if err := s.db.Save(delivery).Error; err != nil {
+			s.log.Error("Failed to save webhook delivery", "delivery_id", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseStatus, delivery.ResponseBody, delivery.Error = sendWebhook(endpoint, delivery)
+	if delivery.Error != "" {
+		metrics.MessageDeliveryFailures.WithLabelValues(metrics.ChannelWebhook).Inc()
+	}
+
+	switch {
+	case delivery.Error == "":
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = nil
+		if endpoint.ConsecutiveFailures > 0 {
+			if err := s.db.Model(&endpoint).UpdateColumn("consecutive_failures", 0).Error; err != nil {
+				s.log.Error("Failed to reset failures of webhook endpoint", "endpoint_id", endpoint.ID, "error", err)
+			}
+		}
+	case delivery.Attempts >= config.WebhookMaxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		s.recordWebhookFailure(&endpoint)
+	default:
+		next := now.Add(config.WebhookRetryBaseDelay << uint(delivery.Attempts-1))
+		delivery.NextAttemptAt = &next
+	}
+
+	if err := s.db.Save(delivery).Error; err != nil {
+		s.log.Error("Failed to save webhook delivery", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+// recordWebhookFailure counts a delivery that failed every attempt against
+// its endpoint, and disables endpoints that keep failing
+func (s *AccountService) recordWebhookFailure(endpoint *models.WebhookEndpoint) {
+	if err := s.db.Model(&models.WebhookEndpoint{}).Where("id = ?", endpoint.ID).
+		UpdateColumn("consecutive_failures", gorm.Expr("consecutive_failures + 1")).Error; err != nil {
+		s.log.Error("Failed to count failure of webhook endpoint", "endpoint_id", endpoint.ID, "error", err)
+		return
+	}
+	if err := s.db.First(endpoint, "id = ?", endpoint.ID).Error; err != nil {
+		return
+	}
+	if endpoint.DisabledAt != nil || endpoint.ConsecutiveFailures < config.WebhookDisableAfterFailures {
+		return
+	}
+
+	now := time.Now()
+	reason := fmt.Sprintf("%d deliveries in a row failed", endpoint.ConsecutiveFailures)
+	if err := s.db.Model(endpoint).UpdateColumns(map[string]interface{}{
+		"disabled_at":     now,
+		"disabled_reason": reason,
+	}).Error; err != nil {
+		s.log.Error("Failed to disable webhook endpoint", "endpoint_id", endpoint.ID, "error", err)
+		return
+	}
+
+	s.audit(endpoint.AccountID, models.Principal{}, models.AuditWebhookDisabled, "webhook", endpoint.ID, map[string]interface{}{
+		"url":    endpoint.URL,
+		"reason": reason,
+	})
+}
+
+func (s *AccountService) findWebhookEndpoint(accountID, endpointID string) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := s.db.Where("id = ? AND account_id = ?", endpointID, accountID).First(&endpoint).Error; err != nil {
+		return nil, apperrors.NotFound("webhook_endpoint_not_found", "webhook endpoint not found")
+	}
+	return &endpoint, nil
+}
+
+// Helper functions
+
+// sendWebhook POSTs the delivery's payload and returns the endpoint's answer,
+// with an error message unless the endpoint accepted it
+func sendWebhook(endpoint models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, string, string) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-backend-webhooks")
+	req.Header.Set("X-Webhook-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", "v1="+signWebhook(endpoint.Secret, timestamp, body))
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, "", truncate(err.Error(), 500)
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, config.WebhookResponseBodyMax))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, strings.ToValidUTF8(string(responseBody), ""), fmt.Sprintf("endpoint answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, strings.ToValidUTF8(string(responseBody), ""), ""
+}
+
+// signWebhook signs the timestamp along with the body, so receivers that
+// check the timestamp is recent cannot be sent a captured request again
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return apperrors.Validation("invalid_webhook_url", "webhook URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// webhookDialControl keeps webhooks from reaching the network this server
+// runs in. It checks the address actually dialed, after DNS resolution.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if config.WebhookAllowPrivateNetworks() {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return apperrors.Validation("webhook_address_not_public", fmt.Sprintf("webhook address %s is not publicly routable", host))
+	}
+	return nil
+}
+
+func webhookEventTypes(eventTypes []models.WebhookEventType) (string, error) {
+	values := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if !models.IsValidWebhookEventType(eventType) {
+			return "", apperrors.Validation("unknown_event_type", fmt.Sprintf("unknown event type: %s", eventType))
+		}
+		values = append(values, string(eventType))
+	}
+	return strings.Join(values, ","), nil
+}
+
+func webhookEndpointResponse(endpoint models.WebhookEndpoint) *models.WebhookEndpointResponse {
+	return &models.WebhookEndpointResponse{
+		WebhookEndpoint: endpoint,
+		EventTypes:      endpoint.EventTypeList(),
+		Enabled:         endpoint.DisabledAt == nil,
+	}
+}
+
+func webhookDeliveryResponse(delivery models.WebhookDelivery) *models.WebhookDeliveryResponse {
+	return &models.WebhookDeliveryResponse{
+		WebhookDelivery: delivery,
+		Payload:         json.RawMessage(delivery.Payload),
+	}
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return strings.ToValidUTF8(value[:max], "")
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contextKey = "tracing:context"
+	spanKey    = "tracing:span"
+)
+
+var tracer = otel.Tracer("go-backend/db")
+
+// WithContext returns a handle whose queries are traced as children of the
+// span in ctx. gorm v1 has no context of its own, so it travels as a value
+// the callbacks registered by RegisterCallbacks pick up.
+func WithContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	if ctx == nil {
+		return db
+	}
+	return db.Set(contextKey, ctx)
+}
+
+// RegisterCallbacks creates a span for every query made through a handle from
+// WithContext. Queries without one, from the sweepers for example, are not
+// traced.
+func RegisterCallbacks(db *gorm.DB) {
+	callbacks := db.Callback()
+
+	callbacks.Create().Before("gorm:create").Register("tracing:before_create", startSpan("INSERT"))
+	callbacks.Create().After("gorm:create").Register("tracing:after_create", endSpan)
+	callbacks.Query().Before("gorm:query").Register("tracing:before_query", startSpan("SELECT"))
+	callbacks.Query().After("gorm:query").Register("tracing:after_query", endSpan)
+	callbacks.Update().Before("gorm:update").Register("tracing:before_update", startSpan("UPDATE"))
+	callbacks.Update().After("gorm:update").Register("tracing:after_update", endSpan)
+	callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startSpan("DELETE"))
+	callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endSpan)
+	callbacks.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startSpan("SELECT"))
+	callbacks.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", endSpan)
+}
+
+// Helper functions
+
+func startSpan(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		value, ok := scope.Get(contextKey)
+		if !ok {
+			return
+		}
+		ctx, ok := value.(context.Context)
+		if !ok {
+			return
+		}
+
+		table := scope.TableName()
+		_, span := tracer.Start(ctx, operation+" "+table,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemMySQL,
+				semconv.DBOperationName(operation),
+				semconv.DBCollectionName(table),
+			),
+		)
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	// Statements hold placeholders, the values bound to them are not recorded
+	span.SetAttributes(
+		semconv.DBQueryText(scope.SQL),
+		attribute.Int64("db.rows_affected", scope.DB().RowsAffected),
+	)
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}